@@ -0,0 +1,239 @@
+package pdp11
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"sort"
+	"strings"
+
+	"github.com/davecheney/pdp11/v6fs"
+)
+
+// a.out magic numbers
+const (
+	OMAGIC = 0407 // text and data contiguous
+	NMAGIC = 0410 // read only text, data on the next 8KB boundary
+	IMAGIC = 0411 // separate instruction and data space
+)
+
+// AOutHeader is the header of a UNIX V6 or V7 a.out file.
+type AOutHeader struct {
+	Magic  uint16
+	Text   uint16 // size of text segment
+	Data   uint16 // size of initialised data
+	Bss    uint16 // size of uninitialised data
+	Syms   uint16 // size of symbol table
+	Entry  uint16 // entry point
+	Unused uint16
+	Flag   uint16 // relocation info stripped
+}
+
+// Symbol types
+const (
+	SymUndef = 00
+	SymAbs   = 01
+	SymText  = 02
+	SymData  = 03
+	SymBss   = 04
+	SymExt   = 040 // external symbol
+)
+
+// Symbol is an entry in an a.out symbol table.
+type Symbol struct {
+	Name  string
+	Type  uint16
+	Value uint16
+}
+
+// Symtab is an a.out symbol table, or kernel namelist. Its symbols must
+// be sorted by value, as they are in the tables made by NewSymtab and
+// ReadAOut, for Find to work.
+type Symtab []Symbol
+
+// NewSymtab returns a symbol table holding syms, sorted by value.
+func NewSymtab(syms []Symbol) Symtab {
+	s := append(Symtab(nil), syms...)
+	sort.SliceStable(s, func(i, j int) bool { return s[i].Value < s[j].Value })
+	return s
+}
+
+// Lookup returns the symbol called name.
+func (s Symtab) Lookup(name string) (Symbol, bool) {
+	for _, sym := range s {
+		if sym.Name == name {
+			return sym, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// Find returns the symbol at or immediately before addr whose type is
+// one of types. Of symbols with the same value, the type listed first
+// is preferred.
+func (s Symtab) Find(addr uint16, types ...uint16) (Symbol, bool) {
+	rank := func(sym Symbol) int {
+		for i, typ := range types {
+			if sym.Type == typ {
+				return i
+			}
+		}
+		return len(types)
+	}
+	i := sort.Search(len(s), func(i int) bool { return s[i].Value > addr })
+	for i > 0 {
+		// the symbols with the value of s[i-1].
+		best, r := s[i-1], rank(s[i-1])
+		for i--; i > 0 && s[i-1].Value == best.Value; i-- {
+			if rr := rank(s[i-1]); rr <= r {
+				best, r = s[i-1], rr
+			}
+		}
+		if r < len(types) {
+			return best, true
+		}
+	}
+	return Symbol{}, false
+}
+
+// AOut is a UNIX V6 or V7 a.out executable.
+type AOut struct {
+	AOutHeader
+	TextSeg, DataSeg []byte
+	Symtab
+}
+
+// ReadAOut reads an a.out file from r.
+func ReadAOut(r io.Reader) (*AOut, error) {
+	var a AOut
+	if err := binary.Read(r, binary.LittleEndian, &a.AOutHeader); err != nil {
+		return nil, err
+	}
+	switch a.Magic {
+	case OMAGIC, NMAGIC, IMAGIC:
+	default:
+		return nil, fmt.Errorf("a.out: bad magic %06o", a.Magic)
+	}
+	a.TextSeg = make([]byte, a.Text)
+	if _, err := io.ReadFull(r, a.TextSeg); err != nil {
+		return nil, err
+	}
+	a.DataSeg = make([]byte, a.Data)
+	if _, err := io.ReadFull(r, a.DataSeg); err != nil {
+		return nil, err
+	}
+	if a.Flag == 0 {
+		// skip relocation information.
+		if _, err := io.CopyN(ioutil.Discard, r, int64(a.Text)+int64(a.Data)); err != nil {
+			return nil, err
+		}
+	}
+	syms := make([]byte, a.Syms)
+	if _, err := io.ReadFull(r, syms); err != nil {
+		if err == io.ErrUnexpectedEOF || err == io.EOF {
+			return nil, errors.New("a.out: short symbol table")
+		}
+		return nil, err
+	}
+	var symtab []Symbol
+	for i := 0; i+12 <= len(syms); i += 12 {
+		symtab = append(symtab, Symbol{
+			Name:  strings.TrimRight(string(syms[i:i+8]), "\x00"),
+			Type:  binary.LittleEndian.Uint16(syms[i+8:]),
+			Value: binary.LittleEndian.Uint16(syms[i+10:]),
+		})
+	}
+	a.Symtab = NewSymtab(symtab)
+	return &a, nil
+}
+
+// Namelist returns the symbol table of the a.out file at path on the V6
+// filesystem attached as drive 0.
+func (p *PDP1140) Namelist(path string) (Symtab, error) {
+	unit := p.rk.unit[0]
+	if unit == nil {
+		return nil, errors.New("drive 0 not attached")
+	}
+	fs, err := v6fs.Mount(unit.disk)
+	if err != nil {
+		return nil, err
+	}
+	f, err := fs.ReadFile(strings.TrimPrefix(path, "/"))
+	if err != nil {
+		return nil, err
+	}
+	a, err := ReadAOut(bytes.NewReader(f))
+	if err != nil {
+		return nil, fmt.Errorf("%s: %v", path, err)
+	}
+	return a.Symtab, nil
+}
+
+// stackTop is the top of the stack of a program loaded by LoadAOut.
+const stackTop = 0160000
+
+// LoadAOut loads the UNIX V6 or V7 a.out program read from r into memory
+// to run standalone, without an operating system, in kernel mode with
+// memory management disabled. The arguments are placed on the stack as
+// by the V6 exec, below the I/O page, with argv ended by -1 rather than
+// V7's null pointer; it is followed by a null pointer, which V7 programs
+// take to be an empty environment. The PC is set to the entry point. If
+// no arguments are given, argv[0] is "a.out". Programs using separate
+// instruction and data space cannot be run, as the 11/40 does not
+// support it.
+func (p *PDP1140) LoadAOut(r io.Reader, args ...string) (*AOut, error) {
+	a, err := ReadAOut(r)
+	if err != nil {
+		return nil, err
+	}
+	var data int
+	switch a.Magic {
+	case OMAGIC:
+		data = int(a.Text)
+	case NMAGIC:
+		data = (int(a.Text) + 017777) &^ 017777
+	default:
+		return nil, fmt.Errorf("a.out: magic %06o: separate I and D space is not supported", a.Magic)
+	}
+	if len(args) == 0 {
+		args = []string{"a.out"}
+	}
+	size := 0
+	for _, arg := range args {
+		size += len(arg) + 1
+	}
+	strs := stackTop - (size+1)&^1
+	sp := strs - 2*(len(args)+3)
+	if end := data + int(a.Data) + int(a.Bss); end > sp {
+		return nil, fmt.Errorf("a.out: program too large, ends at %06o", end)
+	}
+	load := func(addr int, seg []byte) {
+		for i, b := range seg {
+			p.unibus.write8(uint18(addr+i), uint16(b))
+		}
+	}
+	load(0, a.TextSeg)
+	load(data, a.DataSeg)
+	load(data+int(a.Data), make([]byte, a.Bss))
+
+	// argc, argv[0] ... argv[argc-1], -1, 0, then the strings.
+	p.unibus.write16(uint18(sp), uint16(len(args)))
+	for i, arg := range args {
+		p.unibus.write16(uint18(sp+2+2*i), uint16(strs))
+		load(strs, append([]byte(arg), 0))
+		strs += len(arg) + 1
+	}
+	p.unibus.write16(uint18(sp+2+2*len(args)), 0177777)
+	p.unibus.write16(uint18(sp+4+2*len(args)), 0)
+
+	p.mmu.writeSR0(0)
+	p.cpu.curuser = false
+	p.cpu.prevuser = false
+	p.cpu.PS = 0
+	p.cpu.R[6] = sp
+	p.cpu.R[7] = int(a.Entry)
+	return a, nil
+}
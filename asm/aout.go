@@ -0,0 +1,50 @@
+package asm
+
+import (
+	"bufio"
+	"encoding/binary"
+	"io"
+)
+
+// a.out constants, as in package pdp11.
+const (
+	omagic  = 0407
+	symAbs  = 01
+	symText = 02
+	symExt  = 040
+)
+
+// WriteAOut writes the program to w as a UNIX V6 0407 a.out file. The
+// text segment holds memory from address 0, and the symbol table every
+// symbol, truncated to 8 characters. Relocation information is omitted.
+func (p *Program) WriteAOut(w io.Writer) error {
+	text := p.Bytes()
+	syms := p.symbols()
+	bw := bufio.NewWriter(w)
+	hdr := [8]uint16{
+		omagic,
+		uint16(len(text)),
+		0, // data
+		0, // bss
+		uint16(12 * len(syms)),
+		p.Entry,
+		0, // unused
+		1, // relocation info stripped
+	}
+	if err := binary.Write(bw, binary.LittleEndian, hdr); err != nil {
+		return err
+	}
+	bw.Write(text)
+	for _, name := range syms {
+		var sym [12]byte
+		copy(sym[:8], name)
+		typ := uint16(symAbs | symExt)
+		if p.labels[name] {
+			typ = symText | symExt
+		}
+		binary.LittleEndian.PutUint16(sym[8:], typ)
+		binary.LittleEndian.PutUint16(sym[10:], p.Symbols[name])
+		bw.Write(sym[:])
+	}
+	return bw.Flush()
+}
@@ -0,0 +1,633 @@
+// Package asm implements an assembler for PDP-11 programs written in a
+// subset of MACRO-11 or UNIX as syntax.
+//
+// Each line holds optional labels, then an instruction, a directive, an
+// assignment or a list of expressions to store as words. Mnemonics,
+// directives and register names may be written in either case; symbols
+// are case sensitive. Comments begin with ; or /, except that in UNIX as
+// syntax ; separates statements on a line, as in inc r0; inc r1. The
+// syntax may be given to AssembleSyntax. Assemble takes a .mac file to
+// be MACRO-11, and any other to be UNIX as if the first statement of a
+// line has a / comment or an operand beginning with $ or *, which
+// MACRO-11 does not allow.
+//
+//	label:	mov	#10., r0	; MACRO-11
+//	1:	mov	$10., r0	/ UNIX as
+//		sob	r0, 1b
+//
+// Numbers are octal unless they end in a decimal point, or are prefixed
+// by ^D, ^O or ^B. 'c is the value of the character c and "cc of the
+// pair of characters cc. Expressions are evaluated strictly left to
+// right, as by both assemblers, using + - * \/ & ! | and the unary
+// operators - + and ^C. Use <> or [] to group. Since / begins a comment,
+// division is written \/. The symbol . is the location counter, and
+// . = expr sets it.
+//
+// Operands may use every addressing mode, written R, (R)+, -(R), X(R),
+// #X, @#X and X, or deferred with @ or *. The immediate prefix may be #
+// or $. Registers are R0 to R7, SP, PC or %n.
+//
+// Labels are symbols followed by a colon. Local labels are either
+// MACRO-11 style n$, which are scoped by the labels around them, or UNIX
+// style n, which are referred to as nb, the nearest n before, or nf, the
+// nearest after.
+//
+// The operand of sys may be the name of a UNIX V6 system call, as in
+// sys write.
+//
+// The directives are .word, .byte, .ascii, .asciz, .blkw, .blkb, .even,
+// .odd and .end, which gives the transfer address. .globl, .title,
+// .sbttl, .ident, .list, .nlist, .enabl and .dsabl are accepted and
+// ignored.
+package asm
+
+import (
+	"errors"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Program is an assembled program.
+type Program struct {
+	// Entry is the transfer address given by .end, or the first
+	// address assembled if there is none.
+	Entry uint16
+
+	// Symbols holds the value of each label and assigned symbol,
+	// excluding local labels.
+	Symbols map[string]uint16
+
+	mem    map[uint16]byte
+	labels map[string]bool // symbols which are labels
+}
+
+// Words returns the words of memory assembled, by address.
+func (p *Program) Words() map[uint16]uint16 {
+	words := make(map[uint16]uint16)
+	for a, b := range p.mem {
+		w := words[a&^1]
+		if a&1 == 0 {
+			w = w&0177400 | uint16(b)
+		} else {
+			w = w&0377 | uint16(b)<<8
+		}
+		words[a&^1] = w
+	}
+	return words
+}
+
+// Bytes returns the memory assembled from address 0 to the highest
+// address assembled.
+func (p *Program) Bytes() []byte {
+	var top int
+	for a := range p.mem {
+		if int(a) >= top {
+			top = int(a) + 1
+		}
+	}
+	b := make([]byte, (top+1)&^1)
+	for a, v := range p.mem {
+		b[a] = v
+	}
+	return b
+}
+
+// symbols returns the names of the program's symbols, sorted by value.
+func (p *Program) symbols() []string {
+	var names []string
+	for name := range p.Symbols {
+		names = append(names, name)
+	}
+	sort.Slice(names, func(i, j int) bool {
+		a, b := p.Symbols[names[i]], p.Symbols[names[j]]
+		if a != b {
+			return a < b
+		}
+		return names[i] < names[j]
+	})
+	return names
+}
+
+// Syntax is the dialect of assembly language a source file is written in.
+type Syntax int
+
+const (
+	// Detect chooses the syntax from the name and contents of the file.
+	Detect Syntax = iota
+	MACRO11
+	UnixAs
+)
+
+// Assemble assembles src, the contents of the file name, detecting its
+// syntax.
+func Assemble(name string, src []byte) (*Program, error) {
+	return AssembleSyntax(name, src, Detect)
+}
+
+// AssembleSyntax assembles src, the contents of the file name, written
+// in the given syntax.
+func AssembleSyntax(name string, src []byte, syntax Syntax) (*Program, error) {
+	a := assembler{
+		name:    name,
+		symbols: make(map[string]symbol),
+		locals:  make(map[string][]local),
+	}
+	lines := strings.Split(string(src), "\n")
+	if syntax == Detect {
+		syntax = detectSyntax(name, lines)
+	}
+	scope := 0
+	for i, line := range lines {
+		stmts, err := parseLine(line, syntax)
+		if err != nil {
+			return nil, fmt.Errorf("%s:%d: %v", name, i+1, err)
+		}
+		for _, s := range stmts {
+			s.line = i + 1
+			for _, l := range s.labels {
+				if !isLocal(l) && !isNumeric(l) {
+					scope++
+				}
+			}
+			s.scope = scope
+			a.stmts = append(a.stmts, s)
+		}
+	}
+	for a.pass = 1; a.pass <= 2; a.pass++ {
+		if err := a.assemble(); err != nil {
+			return nil, err
+		}
+	}
+	p := &Program{
+		Entry:   a.entry,
+		Symbols: make(map[string]uint16),
+		mem:     a.mem,
+		labels:  make(map[string]bool),
+	}
+	for name, sym := range a.symbols {
+		if strings.Contains(name, "$") && isLocal(name[:strings.IndexByte(name, '$')+1]) {
+			continue
+		}
+		p.Symbols[name] = sym.value
+		p.labels[name] = sym.label
+	}
+	return p, nil
+}
+
+// stmt is a parsed line of source.
+type stmt struct {
+	line   int
+	scope  int      // count of non-local labels defined so far
+	labels []string // labels defined by the line
+	assign string   // symbol assigned to, or ""
+	op     string   // lower case mnemonic or directive, or ""
+	args   string   // operands, expression or directive arguments
+}
+
+type symbol struct {
+	value uint16
+	label bool
+}
+
+// local is a definition of a UNIX style numeric local label.
+type local struct {
+	stmt  int
+	value uint16
+}
+
+type assembler struct {
+	name    string
+	stmts   []stmt
+	pass    int
+	stmt    int // index of the current statement
+	dot     uint16
+	symbols map[string]symbol
+	locals  map[string][]local
+	mem     map[uint16]byte
+	entry   uint16
+	started bool // entry has been set
+	wrapped bool // dot has passed 0177777
+}
+
+func (a *assembler) assemble() error {
+	a.dot = 0
+	a.mem = make(map[uint16]byte)
+	a.started = false
+	a.wrapped = false
+	for i, s := range a.stmts {
+		a.stmt = i
+		end, err := a.statement(s)
+		if err != nil {
+			return fmt.Errorf("%s:%d: %v", a.name, s.line, err)
+		}
+		if end {
+			break
+		}
+	}
+	return nil
+}
+
+// statement assembles s, reporting whether it was .end.
+func (a *assembler) statement(s stmt) (bool, error) {
+	for _, l := range s.labels {
+		if err := a.label(s, l); err != nil {
+			return false, err
+		}
+	}
+	if s.assign != "" {
+		v, ok, err := a.eval(s.args)
+		if err != nil {
+			return false, err
+		}
+		if s.assign == "." {
+			if !ok {
+				return false, fmt.Errorf("location counter set to undefined value")
+			}
+			a.dot = v
+			a.wrapped = false
+			return false, nil
+		}
+		if ok {
+			if sym, ok := a.symbols[s.assign]; ok && sym.label {
+				return false, fmt.Errorf("%s is a label", s.assign)
+			}
+			a.symbols[s.assign] = symbol{value: v}
+		}
+		return false, nil
+	}
+	switch {
+	case s.op == "":
+		return false, a.words(s.args)
+	case s.op[0] == '.':
+		return a.directive(s.op, s.args)
+	default:
+		return false, a.instruction(s.op, s.args)
+	}
+}
+
+func (a *assembler) label(s stmt, l string) error {
+	if isNumeric(l) {
+		if a.pass == 1 {
+			a.locals[l] = append(a.locals[l], local{a.stmt, a.dot})
+		}
+		return nil
+	}
+	name := l
+	if isLocal(l) {
+		name = localName(l, s.scope)
+	}
+	sym, ok := a.symbols[name]
+	switch {
+	case !ok:
+		a.symbols[name] = symbol{value: a.dot, label: true}
+	case a.pass == 1:
+		return fmt.Errorf("%s multiply defined", l)
+	case sym.value != a.dot:
+		return fmt.Errorf("phase error, %s was %06o, now %06o", l, sym.value, a.dot)
+	}
+	return nil
+}
+
+func localName(l string, scope int) string { return l + strconv.Itoa(scope) }
+
+func (a *assembler) emitByte(b byte) error {
+	if a.wrapped {
+		return errPastTop
+	}
+	if !a.started {
+		a.entry = a.dot
+		a.started = true
+	}
+	if a.pass == 2 {
+		a.mem[a.dot] = b
+	}
+	a.dot++
+	a.wrapped = a.dot == 0
+	return nil
+}
+
+var errPastTop = errors.New("location counter past 177777")
+
+func (a *assembler) emitWord(w uint16) error {
+	if a.dot&1 != 0 {
+		return fmt.Errorf("word at odd address %06o", a.dot)
+	}
+	if err := a.emitByte(byte(w)); err != nil {
+		return err
+	}
+	return a.emitByte(byte(w >> 8))
+}
+
+// value evaluates expr, which may be undefined in the first pass.
+func (a *assembler) value(expr string) (uint16, error) {
+	v, _, err := a.eval(expr)
+	return v, err
+}
+
+// words assembles a comma separated list of expressions as words.
+func (a *assembler) words(args string) error {
+	for _, arg := range splitArgs(args) {
+		v, err := a.value(arg)
+		if err != nil {
+			return err
+		}
+		if err := a.emitWord(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (a *assembler) directive(op, args string) (bool, error) {
+	switch op {
+	case ".word":
+		return false, a.words(args)
+	case ".byte":
+		for _, arg := range splitArgs(args) {
+			v, err := a.value(arg)
+			if err != nil {
+				return false, err
+			}
+			if err := a.emitByte(byte(v)); err != nil {
+				return false, err
+			}
+		}
+	case ".ascii", ".asciz":
+		str, err := delimited(args)
+		if err != nil {
+			return false, err
+		}
+		if op == ".asciz" {
+			str += "\x00"
+		}
+		for i := 0; i < len(str); i++ {
+			if err := a.emitByte(str[i]); err != nil {
+				return false, err
+			}
+		}
+	case ".blkw", ".blkb":
+		n := 1
+		if strings.TrimSpace(args) != "" {
+			v, ok, err := a.eval(args)
+			if err != nil {
+				return false, err
+			}
+			if !ok {
+				return false, fmt.Errorf("%s size must be defined before use", op)
+			}
+			n = int(v)
+		}
+		if op == ".blkw" {
+			if a.dot&1 != 0 {
+				return false, fmt.Errorf(".blkw at odd address %06o", a.dot)
+			}
+			n *= 2
+		}
+		if n > 0 && (a.wrapped || int(a.dot)+n > 0200000) {
+			return false, errPastTop
+		}
+		a.wrapped = a.wrapped || int(a.dot)+n == 0200000
+		a.dot += uint16(n)
+	case ".even":
+		a.wrapped = a.wrapped || a.dot == 0177777
+		a.dot += a.dot & 1
+	case ".odd":
+		a.dot |= 1
+	case ".end":
+		if strings.TrimSpace(args) != "" {
+			v, err := a.value(args)
+			if err != nil {
+				return false, err
+			}
+			a.entry = v
+			a.started = true
+		}
+		return true, nil
+	case ".globl", ".title", ".sbttl", ".ident", ".list", ".nlist", ".enabl", ".dsabl":
+	default:
+		return false, fmt.Errorf("unknown directive %s", op)
+	}
+	return false, nil
+}
+
+func (a *assembler) instruction(mnemonic, args string) error {
+	op := opcodes[mnemonic]
+	ops := splitArgs(args)
+	want := map[class]int{none: 0, single: 1, double: 2, branch: 1, regdst: 2, srcreg: 2, reg: 1, sob: 2, trap: 0, mark: 1}[op.class]
+	if op.class == trap && len(ops) == 1 {
+		want = 1
+	}
+	if len(ops) != want {
+		return fmt.Errorf("%s takes %d operands, found %d", mnemonic, want, len(ops))
+	}
+	code := op.code
+	var operands []operand
+	switch op.class {
+	case single:
+		dst, err := a.operand(ops[0])
+		if err != nil {
+			return err
+		}
+		code |= dst.mode
+		operands = append(operands, dst)
+	case double:
+		src, err := a.operand(ops[0])
+		if err != nil {
+			return err
+		}
+		dst, err := a.operand(ops[1])
+		if err != nil {
+			return err
+		}
+		code |= src.mode<<6 | dst.mode
+		operands = append(operands, src, dst)
+	case branch:
+		off, err := a.offset(ops[0], 2)
+		if err != nil {
+			return err
+		}
+		if off < -128 || off > 127 {
+			return fmt.Errorf("branch out of range")
+		}
+		code |= uint16(off) & 0377
+	case regdst:
+		r, err := register(ops[0])
+		if err != nil {
+			return err
+		}
+		dst, err := a.operand(ops[1])
+		if err != nil {
+			return err
+		}
+		code |= r<<6 | dst.mode
+		operands = append(operands, dst)
+	case srcreg:
+		src, err := a.operand(ops[0])
+		if err != nil {
+			return err
+		}
+		r, err := register(ops[1])
+		if err != nil {
+			return err
+		}
+		code |= r<<6 | src.mode
+		operands = append(operands, src)
+	case reg:
+		r, err := register(ops[0])
+		if err != nil {
+			return err
+		}
+		code |= r
+	case sob:
+		r, err := register(ops[0])
+		if err != nil {
+			return err
+		}
+		off, err := a.offset(ops[1], 2)
+		if err != nil {
+			return err
+		}
+		if off > 0 || off < -077 {
+			return fmt.Errorf("sob out of range")
+		}
+		code |= r<<6 | uint16(-off)
+	case trap, mark:
+		var n uint16
+		if len(ops) == 1 {
+			if sc, ok := syscalls[ops[0]]; ok && mnemonic == "sys" {
+				n = sc
+			} else {
+				v, err := a.value(ops[0])
+				if err != nil {
+					return err
+				}
+				n = v
+			}
+		}
+		max := uint16(0377)
+		if op.class == mark {
+			max = 077
+		}
+		if n > max {
+			return fmt.Errorf("%s argument %o out of range", mnemonic, n)
+		}
+		code |= n
+	}
+	if err := a.emitWord(code); err != nil {
+		return err
+	}
+	for _, o := range operands {
+		if !o.extra {
+			continue
+		}
+		v := o.value
+		if o.pcrel {
+			v -= a.dot + 2
+		}
+		if err := a.emitWord(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// offset returns the word offset from the instruction at dot, size bytes
+// long, to the address expr.
+func (a *assembler) offset(expr string, size uint16) (int, error) {
+	v, err := a.value(expr)
+	if err != nil || a.pass == 1 {
+		return 0, err
+	}
+	d := int(int16(v - (a.dot + size)))
+	if d&1 != 0 {
+		return 0, fmt.Errorf("branch to odd address %06o", v)
+	}
+	return d / 2, nil
+}
+
+// operand is an assembled instruction operand.
+type operand struct {
+	mode  uint16 // addressing mode and register
+	extra bool   // followed by a word holding value
+	value uint16
+	pcrel bool // value is relative to the end of the extra word
+}
+
+func (a *assembler) operand(s string) (operand, error) {
+	s = strings.TrimSpace(s)
+	var deferred uint16
+	if strings.HasPrefix(s, "@") || strings.HasPrefix(s, "*") {
+		deferred = 010
+		s = strings.TrimSpace(s[1:])
+	}
+	if s == "" {
+		return operand{}, fmt.Errorf("missing operand")
+	}
+	if s[0] == '#' || s[0] == '$' {
+		v, err := a.value(s[1:])
+		return operand{mode: 027 | deferred, extra: true, value: v}, err
+	}
+	if r, err := register(s); err == nil {
+		return operand{mode: r | deferred}, nil
+	}
+	if strings.HasPrefix(s, "-(") && strings.HasSuffix(s, ")") {
+		r, err := register(s[2 : len(s)-1])
+		return operand{mode: 040 | deferred | r}, err
+	}
+	if strings.HasPrefix(s, "(") && strings.HasSuffix(s, ")+") {
+		r, err := register(s[1 : len(s)-2])
+		return operand{mode: 020 | deferred | r}, err
+	}
+	if strings.HasSuffix(s, ")") {
+		if i := strings.LastIndexByte(s, '('); i >= 0 {
+			r, err := register(s[i+1 : len(s)-1])
+			if err != nil {
+				return operand{}, err
+			}
+			index := strings.TrimSpace(s[:i])
+			if index == "" && deferred == 0 {
+				return operand{mode: 010 | r}, nil
+			}
+			if index == "" {
+				index = "0" // @(R) is @0(R)
+			}
+			v, err := a.value(index)
+			return operand{mode: 060 | deferred | r, extra: true, value: v}, err
+		}
+	}
+	v, err := a.value(s)
+	return operand{mode: 067 | deferred, extra: true, value: v, pcrel: true}, err
+}
+
+// register returns the number of the register named s.
+func register(s string) (uint16, error) {
+	s = strings.ToLower(strings.TrimSpace(s))
+	switch {
+	case s == "sp":
+		return 6, nil
+	case s == "pc":
+		return 7, nil
+	case len(s) == 2 && (s[0] == 'r' || s[0] == '%') && s[1] >= '0' && s[1] <= '7':
+		return uint16(s[1] - '0'), nil
+	}
+	return 0, fmt.Errorf("bad register %q", s)
+}
+
+// delimited returns the string between the delimiters which begin and
+// end s, as in .ascii /text/.
+func delimited(s string) (string, error) {
+	s = strings.TrimSpace(s)
+	if s == "" {
+		return "", fmt.Errorf("missing string")
+	}
+	end := strings.IndexByte(s[1:], s[0])
+	if end < 0 {
+		return "", fmt.Errorf("unterminated string")
+	}
+	if rest := strings.TrimSpace(s[end+2:]); rest != "" && rest[0] != ';' && rest[0] != '/' {
+		return "", fmt.Errorf("junk after string: %q", rest)
+	}
+	return s[1 : end+1], nil
+}
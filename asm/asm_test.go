@@ -0,0 +1,191 @@
+package asm
+
+import (
+	"bytes"
+	"encoding/binary"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+var asmTests = []struct {
+	src  string
+	want []uint16 // words from address 0
+}{
+	{"halt", []uint16{0}},
+	{"mov r1, r2", []uint16{010102}},
+	{"MOV R1,R2", []uint16{010102}},
+	{"movb (r1)+, -(sp)", []uint16{0112146}},
+	{"mov @(r1)+, @-(r2)", []uint16{013152}},
+	{"mov 2(r1), @4(r2)", []uint16{016172, 2, 4}},
+	{"clr @(r5)", []uint16{005075, 0}},
+	{"mov #10., r0", []uint16{012700, 10}},
+	{"mov $10, r0", []uint16{012700, 010}},
+	{"mov @#177776, r0", []uint16{013700, 0177776}},
+	{"mov *$177776, r0", []uint16{013700, 0177776}},
+	{"clr (sp)", []uint16{005016}},
+	{"clr %3", []uint16{005003}},
+	{"x: inc x", []uint16{005267, 0177774}},
+	{"x: inc @x", []uint16{005277, 0177774}},
+	{"mov x, y\nx: 1\ny: 2", []uint16{016767, 2, 2, 1, 2}},
+	{"br .", []uint16{000777}},
+	{"1: bne 1f\n br 1b\n1: halt", []uint16{001001, 000776, 0}},
+	{"a: 1$: br 2$\n 2$: br 1$\nb: 1$: br 1$", []uint16{000400, 000776, 000777}},
+	{"jsr pc, @(r0)+", []uint16{004730}},
+	{"rts pc", []uint16{000207}},
+	{"mul #3, r2", []uint16{070227, 3}},
+	{"ashc $-1, r0", []uint16{073027, 0177777}},
+	{"xor r1, (r2)", []uint16{074112}},
+	{"loop: sob r0, loop", []uint16{077001}},
+	{"emt 1\ntrap 2\nsys 3\nmark 4", []uint16{0104001, 0104402, 0104403, 0006404}},
+	{"sys exit\nsys write", []uint16{0104401, 0104404}},
+	{"emt", []uint16{0104000}},
+	{"bpt\niot", []uint16{3, 4}},
+	{"fadd r1", []uint16{075001}},
+	{".word 1, 2, -1", []uint16{1, 2, 0177777}},
+	{"1+2*3", []uint16{011}},
+	{"1+<2*3>", []uint16{7}},
+	{"[10\\/2]", []uint16{4}},
+	{"^C0", []uint16{0177777}},
+	{"^D10!^B101", []uint16{10 | 5}},
+	{"'A", []uint16{'A'}},
+	{"\"AB", []uint16{'A' | 'B'<<8}},
+	{".byte 1, 2, 3\n.even\n.word .", []uint16{0x201, 3, 4}},
+	{".ascii /a;b/ ; comment\n.even", []uint16{'a' | ';'<<8, 'b'}},
+	{".asciz \"ab\"", []uint16{'a' | 'b'<<8, 0}},
+	{".blkw 2\n.blkb 1\n.even\n.word 7", []uint16{0, 0, 0, 7}},
+	{"a = 5\nb == a+1\nmov #b, r0", []uint16{012700, 6}},
+	{". = 4\n.word 1", []uint16{0, 0, 1}},
+	{"clr r0 / UNIX comment", []uint16{005000}},
+	{"/ UNIX\ninc r0; inc r1", []uint16{005200, 005201}},
+	{"/ UNIX\n.ascii /a;b/; .even; inc r0", []uint16{'a' | ';'<<8, 'b', 005200}},
+	{"clr r0 ; clear it", []uint16{005000}},
+	{"wait\t; wait for an interrupt", []uint16{000001}},
+	{"mov r0, r1\t; clr the flag later", []uint16{010001}},
+	{"inc r0; inc r1", []uint16{005200}},
+	{"inc r0; mov $1, r1", []uint16{005200}},
+	{"inc r0\n.end\ninc r1", []uint16{005200}},
+}
+
+func words(p *Program) []uint16 {
+	w := p.Words()
+	var top uint16
+	for a := range w {
+		if a >= top {
+			top = a + 2
+		}
+	}
+	s := make([]uint16, top/2)
+	for a, v := range w {
+		s[a/2] = v
+	}
+	return s
+}
+
+func TestAssemble(t *testing.T) {
+	for _, tt := range asmTests {
+		p, err := Assemble("test.s", []byte(tt.src))
+		if err != nil {
+			t.Errorf("%q: %v", tt.src, err)
+			continue
+		}
+		if got := words(p); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: got %06o, want %06o", tt.src, got, tt.want)
+		}
+	}
+}
+
+var errorTests = []struct {
+	src, err string
+}{
+	{"mov r1", "test.s:1: mov takes 2 operands, found 1"},
+	{"clr x", "test.s:1: undefined symbol x"},
+	{"x: halt\nx: halt", "test.s:2: x multiply defined"},
+	{"br 1f", "test.s:1: undefined local label 1f"},
+	{"br .+1000", "test.s:1: branch out of range"},
+	{".byte 1\n.word 2", "test.s:2: word at odd address 000001"},
+	{"jsr r8, x", `test.s:1: bad register "r8"`},
+	{"9", "test.s:1: bad octal number 9"},
+	{".foo", "test.s:1: unknown directive .foo"},
+	{". = x\nx: halt", "test.s:1: location counter set to undefined value"},
+	{".blkw 100001", "test.s:1: location counter past 177777"},
+	{". = 177776\n.blkw 1\n.blkb 1", "test.s:3: location counter past 177777"},
+	{". = 177776\n.word 1\nhalt", "test.s:3: location counter past 177777"},
+}
+
+func TestAssembleErrors(t *testing.T) {
+	for _, tt := range errorTests {
+		_, err := Assemble("test.s", []byte(tt.src))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.src, err, tt.err)
+		}
+	}
+}
+
+func TestSyntax(t *testing.T) {
+	for _, tt := range []struct {
+		name   string
+		src    string
+		syntax Syntax
+		want   []uint16
+	}{
+		{"test.s", "inc r0; inc r1", UnixAs, []uint16{005200, 005201}},
+		{"test.s", "inc r0; inc r1", MACRO11, []uint16{005200}},
+		{"test.s", "mov $1, r0; inc r1", Detect, []uint16{012700, 1, 005201}},
+		{"test.s", "jmp *(r0); inc r1", Detect, []uint16{000170, 0, 005201}},
+		{"test.mac", "inc r0; inc r1 / no", Detect, []uint16{005200}},
+	} {
+		p, err := AssembleSyntax(tt.name, []byte(tt.src), tt.syntax)
+		if err != nil {
+			t.Errorf("%s %q: %v", tt.name, tt.src, err)
+			continue
+		}
+		if got := words(p); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s %q: got %06o, want %06o", tt.name, tt.src, got, tt.want)
+		}
+	}
+}
+
+func TestEntry(t *testing.T) {
+	for _, tt := range []struct {
+		src  string
+		want uint16
+	}{
+		{". = 1000\nhalt", 01000},
+		{". = 1000\nhalt\nstart: br start\n.end start", 01002},
+	} {
+		p, err := Assemble("test.s", []byte(tt.src))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if p.Entry != tt.want {
+			t.Errorf("%q: entry %06o, want %06o", tt.src, p.Entry, tt.want)
+		}
+	}
+}
+
+func TestWriteAOut(t *testing.T) {
+	p, err := Assemble("test.s", []byte("start: mov $1, r0\nn = 42\nsys 1\n.end start"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := p.WriteAOut(&buf); err != nil {
+		t.Fatal(err)
+	}
+	var hdr [8]uint16
+	if err := binary.Read(&buf, binary.LittleEndian, &hdr); err != nil {
+		t.Fatal(err)
+	}
+	if want := [8]uint16{0407, 6, 0, 0, 24, 0, 0, 1}; hdr != want {
+		t.Fatalf("header: got %o, want %o", hdr, want)
+	}
+	text := buf.Next(6)
+	if !bytes.Equal(text, []byte{0300, 025, 1, 0, 1, 0211}) {
+		t.Errorf("text: got %o", text)
+	}
+	syms := buf.Bytes()
+	if len(syms) != 24 || !strings.HasPrefix(string(syms), "start\x00") || !strings.HasPrefix(string(syms[12:]), "n\x00") {
+		t.Errorf("symbols: got %q", syms)
+	}
+}
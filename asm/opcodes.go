@@ -0,0 +1,143 @@
+package asm
+
+// class describes the operands an instruction takes.
+type class int
+
+const (
+	none   class = iota // HALT
+	single              // CLR dst
+	double              // MOV src, dst
+	branch              // BR label
+	regdst              // JSR reg, dst
+	srcreg              // MUL src, reg
+	reg                 // RTS reg
+	sob                 // SOB reg, label
+	trap                // EMT n
+	mark                // MARK n
+)
+
+type opcode struct {
+	code  uint16
+	class class
+}
+
+// opcodes are the instructions of the 11/40 with the EIS and FIS options,
+// by lower case mnemonic.
+var opcodes = map[string]opcode{
+	"halt":  {0000000, none},
+	"wait":  {0000001, none},
+	"rti":   {0000002, none},
+	"bpt":   {0000003, none},
+	"iot":   {0000004, none},
+	"reset": {0000005, none},
+	"rtt":   {0000006, none},
+	"nop":   {0000240, none},
+	"clc":   {0000241, none},
+	"clv":   {0000242, none},
+	"clz":   {0000244, none},
+	"cln":   {0000250, none},
+	"ccc":   {0000257, none},
+	"sec":   {0000261, none},
+	"sev":   {0000262, none},
+	"sez":   {0000264, none},
+	"sen":   {0000270, none},
+	"scc":   {0000277, none},
+	"setd":  {0170011, none},
+
+	"jmp":  {0000100, single},
+	"swab": {0000300, single},
+	"clr":  {0005000, single},
+	"com":  {0005100, single},
+	"inc":  {0005200, single},
+	"dec":  {0005300, single},
+	"neg":  {0005400, single},
+	"adc":  {0005500, single},
+	"sbc":  {0005600, single},
+	"tst":  {0005700, single},
+	"ror":  {0006000, single},
+	"rol":  {0006100, single},
+	"asr":  {0006200, single},
+	"asl":  {0006300, single},
+	"mfpi": {0006500, single},
+	"mtpi": {0006600, single},
+	"sxt":  {0006700, single},
+	"clrb": {0105000, single},
+	"comb": {0105100, single},
+	"incb": {0105200, single},
+	"decb": {0105300, single},
+	"negb": {0105400, single},
+	"adcb": {0105500, single},
+	"sbcb": {0105600, single},
+	"tstb": {0105700, single},
+	"rorb": {0106000, single},
+	"rolb": {0106100, single},
+	"asrb": {0106200, single},
+	"aslb": {0106300, single},
+	"mfpd": {0106500, single},
+	"mtpd": {0106600, single},
+
+	"mov":  {0010000, double},
+	"cmp":  {0020000, double},
+	"bit":  {0030000, double},
+	"bic":  {0040000, double},
+	"bis":  {0050000, double},
+	"add":  {0060000, double},
+	"movb": {0110000, double},
+	"cmpb": {0120000, double},
+	"bitb": {0130000, double},
+	"bicb": {0140000, double},
+	"bisb": {0150000, double},
+	"sub":  {0160000, double},
+
+	"br":   {0000400, branch},
+	"bne":  {0001000, branch},
+	"beq":  {0001400, branch},
+	"bge":  {0002000, branch},
+	"blt":  {0002400, branch},
+	"bgt":  {0003000, branch},
+	"ble":  {0003400, branch},
+	"bpl":  {0100000, branch},
+	"bmi":  {0100400, branch},
+	"bhi":  {0101000, branch},
+	"blos": {0101400, branch},
+	"bvc":  {0102000, branch},
+	"bvs":  {0102400, branch},
+	"bcc":  {0103000, branch},
+	"bhis": {0103000, branch},
+	"bcs":  {0103400, branch},
+	"blo":  {0103400, branch},
+
+	"jsr": {0004000, regdst},
+	"xor": {0074000, regdst},
+
+	"mul":  {0070000, srcreg},
+	"div":  {0071000, srcreg},
+	"ash":  {0072000, srcreg},
+	"ashc": {0073000, srcreg},
+
+	"rts":  {0000200, reg},
+	"fadd": {0075000, reg},
+	"fsub": {0075010, reg},
+	"fmul": {0075020, reg},
+	"fdiv": {0075030, reg},
+
+	"sob": {0077000, sob},
+
+	"emt":  {0104000, trap},
+	"trap": {0104400, trap},
+	"sys":  {0104400, trap}, // UNIX as
+
+	"mark": {0006400, mark},
+}
+
+// syscalls are the UNIX V6 system call names accepted by sys.
+var syscalls = map[string]uint16{
+	"indir": 0, "exit": 1, "fork": 2, "read": 3, "write": 4, "open": 5,
+	"close": 6, "wait": 7, "creat": 8, "link": 9, "unlink": 10, "exec": 11,
+	"chdir": 12, "time": 13, "mknod": 14, "chmod": 15, "chown": 16,
+	"break": 17, "stat": 18, "seek": 19, "getpid": 20, "mount": 21,
+	"umount": 22, "setuid": 23, "getuid": 24, "stime": 25, "ptrace": 26,
+	"fstat": 28, "stty": 31, "gtty": 32, "nice": 34, "sleep": 35, "sync": 36,
+	"kill": 37, "switch": 38, "dup": 41, "pipe": 42, "times": 43, "prof": 44,
+	"setgid": 46, "getgid": 47, "signal": 48,
+}
@@ -0,0 +1,420 @@
+package asm
+
+import (
+	"fmt"
+	"strings"
+)
+
+func isDigit(c byte) bool { return c >= '0' && c <= '9' }
+func isSymStart(c byte) bool {
+	return c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c == '_' || c == '.'
+}
+func isSymChar(c byte) bool     { return isSymStart(c) || isDigit(c) || c == '$' }
+func isComment(c byte) bool     { return c == ';' || c == '/' }
+func isSpace(c byte) bool       { return c == ' ' || c == '\t' || c == '\r' || c == '\f' }
+func isNumeric(s string) bool   { return s != "" && strings.TrimLeft(s, "0123456789") == "" }
+func isLocal(s string) bool     { return len(s) > 1 && s[len(s)-1] == '$' && isNumeric(s[:len(s)-1]) }
+func trimSpace(s string) string { return strings.Trim(s, " \t\r\f") }
+
+// token returns the symbol or number at the start of s, including the
+// $ of a MACRO-11 local label.
+func token(s string) string {
+	i := 0
+	switch {
+	case i < len(s) && isSymStart(s[i]):
+		for i < len(s) && isSymChar(s[i]) {
+			i++
+		}
+	case i < len(s) && isDigit(s[i]):
+		for i < len(s) && isDigit(s[i]) {
+			i++
+		}
+		if i < len(s) && s[i] == '$' {
+			i++
+		}
+	}
+	return s[:i]
+}
+
+// parseLine splits a line of source into its statements. In UNIX as
+// syntax ; separates statements; in MACRO-11 it begins a comment.
+func parseLine(line string, syntax Syntax) ([]stmt, error) {
+	var stmts []stmt
+	for {
+		s, comment, err := parseStmt(line)
+		if err != nil {
+			return nil, err
+		}
+		stmts = append(stmts, s)
+		if syntax != UnixAs || !strings.HasPrefix(comment, ";") {
+			return stmts, nil
+		}
+		line = comment[1:]
+	}
+}
+
+// detectSyntax returns the syntax of the source lines of the file name.
+// A .mac file is MACRO-11. Otherwise the file is UNIX as if the first
+// statement of any line has a / comment, or an operand which begins with
+// $ or *, none of which MACRO-11 allows. Later statements are not
+// examined, since in MACRO-11 they are part of a ; comment.
+func detectSyntax(name string, lines []string) Syntax {
+	if strings.HasSuffix(strings.ToLower(name), ".mac") {
+		return MACRO11
+	}
+	for _, line := range lines {
+		s, comment, err := parseStmt(line)
+		if err != nil {
+			continue
+		}
+		if strings.HasPrefix(comment, "/") {
+			return UnixAs
+		}
+		if _, ok := opcodes[s.op]; !ok {
+			continue
+		}
+		for _, arg := range splitArgs(s.args) {
+			if strings.HasPrefix(arg, "$") || strings.HasPrefix(arg, "*") {
+				return UnixAs
+			}
+		}
+	}
+	return MACRO11
+}
+
+// parseStmt splits the statement at the start of line into its labels,
+// operation and arguments, and returns any comment, or the rest of the
+// line after a ;, which follows it.
+func parseStmt(line string) (stmt, string, error) {
+	var s stmt
+	rest := line
+	for {
+		rest = strings.TrimLeft(rest, " \t\r\f")
+		if rest == "" || isComment(rest[0]) {
+			return s, rest, nil
+		}
+		tok := token(rest)
+		if tok == "" {
+			// an expression to be stored as a word.
+			s.args, rest = splitComment(rest)
+			return s, rest, nil
+		}
+		after := strings.TrimLeft(rest[len(tok):], " \t\r\f")
+		switch {
+		case strings.HasPrefix(after, ":"):
+			if isDigit(tok[0]) && !isNumeric(tok) && !isLocal(tok) {
+				return s, "", fmt.Errorf("bad label %q", tok)
+			}
+			s.labels = append(s.labels, tok)
+			rest = strings.TrimPrefix(after[1:], ":") // MACRO-11 global label
+			continue
+		case strings.HasPrefix(after, "=") && isSymStart(tok[0]):
+			s.assign = tok
+			s.args, rest = splitComment(strings.TrimPrefix(after[1:], "=")) // MACRO-11 global assignment
+			return s, rest, nil
+		}
+		op := strings.ToLower(tok)
+		if _, ok := opcodes[op]; ok || op[0] == '.' && op != "." {
+			s.op = op
+			if (op == ".ascii" || op == ".asciz") && after != "" {
+				// the delimiters of the string may be comment characters.
+				if end := strings.IndexByte(after[1:], after[0]); end >= 0 {
+					junk, comment := splitComment(after[end+2:])
+					s.args = after[:end+2] + " " + junk
+					return s, comment, nil
+				}
+			}
+			s.args, rest = splitComment(after)
+			return s, rest, nil
+		}
+		s.args, rest = splitComment(rest)
+		return s, rest, nil
+	}
+}
+
+// splitComment splits s into the code before any comment, and the
+// comment, or the rest of the line after a ;.
+func splitComment(s string) (string, string) {
+	for i := 0; i < len(s); i++ {
+		switch {
+		case s[i] == '\'' || s[i] == '\\':
+			i++
+		case s[i] == '"':
+			i += 2
+		case isComment(s[i]):
+			return trimSpace(s[:i]), s[i:]
+		}
+	}
+	return trimSpace(s), ""
+}
+
+// splitArgs splits s at commas which are not within brackets or
+// character constants.
+func splitArgs(s string) []string {
+	s = trimSpace(s)
+	if s == "" {
+		return nil
+	}
+	var args []string
+	depth, start := 0, 0
+	for i := 0; i < len(s); i++ {
+		switch s[i] {
+		case '\'', '\\':
+			i++
+		case '"':
+			i += 2
+		case '<', '[', '(':
+			depth++
+		case '>', ']', ')':
+			depth--
+		case ',':
+			if depth == 0 {
+				args = append(args, trimSpace(s[start:i]))
+				start = i + 1
+			}
+		}
+	}
+	return append(args, trimSpace(s[start:]))
+}
+
+// eval evaluates expr, reporting whether every symbol in it is defined.
+func (a *assembler) eval(expr string) (uint16, bool, error) {
+	e := evaluator{a: a, s: expr, ok: true}
+	v, err := e.expr()
+	if err != nil {
+		return 0, false, err
+	}
+	if e.skip(); e.i < len(e.s) {
+		return 0, false, fmt.Errorf("junk in expression %q", trimSpace(expr))
+	}
+	return v, e.ok, nil
+}
+
+type evaluator struct {
+	a  *assembler
+	s  string
+	i  int
+	ok bool // all symbols are defined
+}
+
+func (e *evaluator) skip() {
+	for e.i < len(e.s) && isSpace(e.s[e.i]) {
+		e.i++
+	}
+}
+
+func (e *evaluator) peek() byte {
+	e.skip()
+	if e.i < len(e.s) {
+		return e.s[e.i]
+	}
+	return 0
+}
+
+func (e *evaluator) expr() (uint16, error) {
+	v, err := e.term()
+	if err != nil {
+		return 0, err
+	}
+	for {
+		op := e.peek()
+		switch op {
+		case '+', '-', '*', '&', '!', '|':
+			e.i++
+		case '\\':
+			if e.i+1 >= len(e.s) || e.s[e.i+1] != '/' {
+				return v, nil
+			}
+			e.i += 2
+		default:
+			return v, nil
+		}
+		w, err := e.term()
+		if err != nil {
+			return 0, err
+		}
+		switch op {
+		case '+':
+			v += w
+		case '-':
+			v -= w
+		case '*':
+			v *= w
+		case '\\':
+			if w == 0 {
+				if e.ok {
+					return 0, fmt.Errorf("division by zero")
+				}
+				w = 1
+			}
+			v /= w
+		case '&':
+			v &= w
+		case '!', '|':
+			v |= w
+		}
+	}
+}
+
+func (e *evaluator) term() (uint16, error) {
+	switch c := e.peek(); {
+	case c == 0:
+		return 0, fmt.Errorf("missing expression")
+	case c == '-':
+		e.i++
+		v, err := e.term()
+		return -v, err
+	case c == '+':
+		e.i++
+		return e.term()
+	case c == '<' || c == '[':
+		e.i++
+		v, err := e.expr()
+		if err != nil {
+			return 0, err
+		}
+		want := byte('>')
+		if c == '[' {
+			want = ']'
+		}
+		if e.peek() != want {
+			return 0, fmt.Errorf("missing %c", want)
+		}
+		e.i++
+		return v, nil
+	case c == '\'':
+		if e.i+1 >= len(e.s) {
+			return 0, fmt.Errorf("missing character after '")
+		}
+		e.i += 2
+		return uint16(e.s[e.i-1]), nil
+	case c == '"':
+		if e.i+2 >= len(e.s) {
+			return 0, fmt.Errorf("missing characters after \"")
+		}
+		e.i += 3
+		return uint16(e.s[e.i-2]) | uint16(e.s[e.i-1])<<8, nil
+	case c == '^':
+		if e.i+1 >= len(e.s) {
+			return 0, fmt.Errorf("missing operator after ^")
+		}
+		op := e.s[e.i+1] | 040
+		e.i += 2
+		switch op {
+		case 'c':
+			v, err := e.term()
+			return ^v, err
+		case 'd':
+			return e.radix(10)
+		case 'o':
+			return e.radix(8)
+		case 'b':
+			return e.radix(2)
+		}
+		return 0, fmt.Errorf("unknown operator ^%c", e.s[e.i-1])
+	case isDigit(c):
+		return e.number()
+	case isSymStart(c):
+		tok := token(e.s[e.i:])
+		e.i += len(tok)
+		return e.symbol(tok, tok)
+	}
+	return 0, fmt.Errorf("bad expression %q", trimSpace(e.s))
+}
+
+// radix parses a number in the given radix.
+func (e *evaluator) radix(base uint16) (uint16, error) {
+	e.skip()
+	start := e.i
+	var v uint16
+	for e.i < len(e.s) && isDigit(e.s[e.i]) {
+		d := uint16(e.s[e.i] - '0')
+		if d >= base {
+			return 0, fmt.Errorf("digit %c out of range in radix %d", e.s[e.i], base)
+		}
+		v = v*base + d
+		e.i++
+	}
+	if e.i == start {
+		return 0, fmt.Errorf("missing number")
+	}
+	return v, nil
+}
+
+// number parses an octal or decimal number, or a local label.
+func (e *evaluator) number() (uint16, error) {
+	start := e.i
+	for e.i < len(e.s) && isDigit(e.s[e.i]) {
+		e.i++
+	}
+	digits := e.s[start:e.i]
+	if e.i < len(e.s) {
+		switch c := e.s[e.i]; {
+		case c == '$':
+			e.i++
+			return e.symbol(localName(digits+"$", e.a.stmts[e.a.stmt].scope), digits+"$")
+		case (c == 'b' || c == 'f') && (e.i+1 == len(e.s) || !isSymChar(e.s[e.i+1])):
+			e.i++
+			return e.numeric(digits, c == 'f')
+		case c == '.':
+			e.i++
+			var v uint16
+			for _, d := range digits {
+				v = v*10 + uint16(d-'0')
+			}
+			return v, nil
+		}
+	}
+	var v uint16
+	for _, d := range digits {
+		if d > '7' {
+			return 0, fmt.Errorf("bad octal number %s", digits)
+		}
+		v = v*8 + uint16(d-'0')
+	}
+	return v, nil
+}
+
+// numeric returns the value of the UNIX style local label n, the nearest
+// before the current statement, or after it if forward is set.
+func (e *evaluator) numeric(n string, forward bool) (uint16, error) {
+	defs := e.a.locals[n]
+	if forward {
+		for _, d := range defs {
+			if d.stmt > e.a.stmt {
+				return d.value, nil
+			}
+		}
+	} else {
+		for i := len(defs) - 1; i >= 0; i-- {
+			if d := defs[i]; d.stmt <= e.a.stmt {
+				return d.value, nil
+			}
+		}
+	}
+	if e.a.pass == 1 {
+		e.ok = false
+		return 0, nil
+	}
+	dir := "b"
+	if forward {
+		dir = "f"
+	}
+	return 0, fmt.Errorf("undefined local label %s%s", n, dir)
+}
+
+// symbol returns the value of the symbol called name, written as src.
+func (e *evaluator) symbol(name, src string) (uint16, error) {
+	if name == "." {
+		return e.a.dot, nil
+	}
+	sym, ok := e.a.symbols[name]
+	if !ok {
+		if e.a.pass == 2 {
+			return 0, fmt.Errorf("undefined symbol %s", src)
+		}
+		e.ok = false
+		return 0, nil
+	}
+	return sym.value, nil
+}
@@ -0,0 +1,18 @@
+package pdp11
+
+import "github.com/davecheney/pdp11/asm"
+
+// Assemble assembles src, written in MACRO-11 or UNIX as syntax as
+// described in package asm, returning the words assembled for
+// LoadMemory.
+func Assemble(src string) (map[uint18]uint16, error) {
+	prog, err := asm.Assemble("asm", []byte(src))
+	if err != nil {
+		return nil, err
+	}
+	core := make(map[uint18]uint16)
+	for a, w := range prog.Words() {
+		core[uint18(a)] = w
+	}
+	return core, nil
+}
@@ -0,0 +1,76 @@
+package pdp11
+
+// The block cache holds predecoded instructions for each 32 word block of
+// physical memory the processor has executed from. A block is the unit of
+// KT11 page length checking, so if one instruction in a block can be
+// fetched, every instruction in it can be, and the virtual addresses of
+// the block map linearly onto its physical addresses. Once the processor
+// has fetched an instruction through the MMU, following fetches from the
+// same virtual block skip the MMU and memory entirely until control
+// leaves the block or the mapping changes.
+//
+// A block is invalidated when memory within it is written and the
+// processor's current block is forgotten when the mapping changes: a
+// PAR, PDR or SR0 write, or a change of mode.
+
+type decoded struct {
+	instr INST
+	op    *opcode
+}
+
+type block struct {
+	ops   [32]decoded
+	valid bool
+}
+
+// fetch returns the decoded instruction at virtual address pc.
+func (k *cpu) fetch(pc uint16) *decoded {
+	if b := k.blk; b != nil && b.valid && k.blkgen == k.mmu.gen && pc&^077 == k.blkbase {
+		return &b.ops[(pc&077)>>1]
+	}
+	ia := k.mmu.decode(pc, false, k.curuser)
+	if ia >= MEMSIZE || k.nocache {
+		k.blk = nil
+		k.slow.instr = INST(k.unibus.read16(ia))
+		k.slow.op = optable[k.slow.instr]
+		return &k.slow
+	}
+	b := k.unibus.block(ia &^ 077)
+	k.blk = b
+	k.blkbase = pc &^ 077
+	k.blkgen = k.mmu.gen
+	return &b.ops[(ia&077)>>1]
+}
+
+// block returns the decoded block at physical address a, decoding it
+// if necessary.
+func (u *unibus) block(a uint18) *block {
+	i := a >> 6
+	if b := u.blocks[i]; b != nil {
+		return b
+	}
+	b := &block{valid: true}
+	for j := range b.ops {
+		instr := INST(u.Memory[(a>>1)+uint18(j)])
+		b.ops[j] = decoded{instr, optable[instr]}
+	}
+	u.blocks[i] = b
+	return b
+}
+
+// invalidate discards the decoded block containing physical address a.
+func (u *unibus) invalidate(a uint18) {
+	i := a >> 6
+	u.blocks[i].valid = false
+	u.blocks[i] = nil
+}
+
+// flush discards every decoded block.
+func (u *unibus) flush() {
+	for i, b := range u.blocks {
+		if b != nil {
+			b.valid = false
+			u.blocks[i] = nil
+		}
+	}
+}
@@ -0,0 +1,189 @@
+// Command pdp11dis disassembles PDP-11 programs.
+//
+// Usage:
+//
+//	pdp11dis [flags] file
+//
+// The file is an a.out executable, or a raw memory image such as a boot
+// block or a disk image. A raw image is loaded at the address given by
+// -base, after skipping -block 512 byte blocks, so that the boot block of
+// the RK05 image is listed by
+//
+//	pdp11dis -raw -count 1 rk0
+//
+// -raw is needed as the boot block, like an a.out, begins with 000407.
+// The text segment of an a.out is listed with its symbols. Addresses in a
+// raw image are resolved against the symbols of the a.out named by -syms,
+// such as the kernel.
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"strconv"
+
+	"github.com/davecheney/pdp11"
+)
+
+// octal is a flag holding an octal number.
+type octal uint32
+
+func (o *octal) String() string { return fmt.Sprintf("%o", uint32(*o)) }
+
+func (o *octal) Set(s string) error {
+	v, err := strconv.ParseUint(s, 8, 32)
+	if err != nil {
+		return fmt.Errorf("bad octal number %q", s)
+	}
+	*o = octal(v)
+	return nil
+}
+
+var (
+	raw   = flag.Bool("raw", false, "treat the file as a raw image even if it looks like an a.out")
+	block = flag.Int("block", 0, "skip this many 512 byte blocks of a raw image")
+	count = flag.Int("count", 0, "list this many 512 byte blocks of a raw image, 0 is all")
+	syms  = flag.String("syms", "", "resolve addresses against the symbols of this a.out")
+	data  = flag.Bool("data", false, "list the data segment of an a.out as well as the text")
+	base  octal
+	start octal
+	end   octal
+)
+
+func init() {
+	flag.Var(&base, "base", "load address of a raw image, in octal")
+	flag.Var(&start, "start", "start listing at this address, in octal")
+	flag.Var(&end, "end", "stop listing before this address, in octal")
+}
+
+// image is a program in memory.
+type image struct {
+	mem        map[uint32]uint16
+	start, end uint32 // range to list
+	syms       pdp11.Symtab
+}
+
+func (m *image) read(addr uint32) (uint16, bool) {
+	w, ok := m.mem[addr]
+	return w, ok
+}
+
+// load places b in memory at addr.
+func (m *image) load(addr uint32, b []byte) {
+	if len(b)&1 == 1 {
+		b = append(b, 0)
+	}
+	for i := 0; i < len(b); i += 2 {
+		m.mem[addr+uint32(i)] = uint16(b[i]) | uint16(b[i+1])<<8
+	}
+}
+
+// readAOut loads the text, and data if -data is set, of an a.out.
+func readAOut(b []byte) (*image, error) {
+	a, err := pdp11.ReadAOut(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	m := &image{mem: make(map[uint32]uint16), syms: a.Symtab}
+	m.load(0, a.TextSeg)
+	m.end = uint32(a.Text)
+	if *data {
+		var addr uint32
+		switch a.Magic {
+		case pdp11.OMAGIC:
+			addr = uint32(a.Text)
+		case pdp11.NMAGIC:
+			addr = (uint32(a.Text) + 017777) &^ 017777
+		default:
+			return nil, fmt.Errorf("cannot list data in separate I and D space")
+		}
+		m.load(addr, a.DataSeg)
+		m.end = addr + uint32(a.Data)
+	}
+	return m, nil
+}
+
+// readRaw loads a raw image.
+func readRaw(b []byte) (*image, error) {
+	off := *block * 512
+	if off > len(b) {
+		return nil, fmt.Errorf("block %d is beyond the end of the image", *block)
+	}
+	b = b[off:]
+	if n := *count * 512; n > 0 && n < len(b) {
+		b = b[:n]
+	}
+	m := &image{mem: make(map[uint32]uint16), start: uint32(base)}
+	m.load(uint32(base), b)
+	m.end = uint32(base) + uint32(len(b))
+	return m, nil
+}
+
+func isAOut(b []byte) bool {
+	if len(b) < 16 {
+		return false
+	}
+	switch uint16(b[0]) | uint16(b[1])<<8 {
+	case pdp11.OMAGIC, pdp11.NMAGIC, pdp11.IMAGIC:
+		return true
+	}
+	return false
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("pdp11dis: ")
+	flag.Usage = func() {
+		fmt.Fprintf(os.Stderr, "usage: pdp11dis [flags] file\n")
+		flag.PrintDefaults()
+	}
+	flag.Parse()
+	if flag.NArg() != 1 {
+		flag.Usage()
+		os.Exit(2)
+	}
+	path := flag.Arg(0)
+	b, err := ioutil.ReadFile(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	var m *image
+	if isAOut(b) && !*raw {
+		m, err = readAOut(b)
+	} else {
+		m, err = readRaw(b)
+	}
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if *syms != "" {
+		b, err := ioutil.ReadFile(*syms)
+		if err != nil {
+			log.Fatal(err)
+		}
+		a, err := pdp11.ReadAOut(bytes.NewReader(b))
+		if err != nil {
+			log.Fatalf("%s: %v", *syms, err)
+		}
+		m.syms = a.Symtab
+	}
+	if start != 0 {
+		m.start = uint32(start)
+	}
+	if end != 0 {
+		m.end = uint32(end)
+	}
+	w := bufio.NewWriter(os.Stdout)
+	d := pdp11.Disassembler{Read: m.read, Symbols: m.syms}
+	if err := d.List(w, m.start, m.end); err != nil {
+		log.Fatal(err)
+	}
+	if err := w.Flush(); err != nil {
+		log.Fatal(err)
+	}
+}
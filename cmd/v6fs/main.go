@@ -0,0 +1,359 @@
+// Command v6fs copies files into and out of UNIX V6 filesystem images,
+// such as rk0.
+//
+// Usage:
+//
+//	v6fs [-i image] command [args]
+//
+// The commands are
+//
+//	ls [path...]               list files, or the contents of directories
+//	cat path...                write files to standard output
+//	put [-m mode] file path    copy the host file to path, or into the directory path
+//	get path file              copy path to the host file
+//	tar-in [dir]               unpack the tar archive on standard input into dir
+//	tar-out [dir]              write the tree at dir to standard output as a tar archive
+//	fsck [-r]                  check the consistency of the filesystem, and repair it with -r
+//
+// Paths in the image may be written with or without a leading slash.
+// The image must not be changed while the emulator is running from it.
+package main
+
+import (
+	"archive/tar"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"io/fs"
+	"io/ioutil"
+	"log"
+	"os"
+	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+
+	"github.com/davecheney/pdp11/v6fs"
+)
+
+var image = flag.String("i", "rk0", "path of the disk image")
+
+// commands are the subcommands, and whether they change the image.
+var commands = map[string]struct {
+	run    func(f *v6fs.FS, args []string) error
+	writes bool
+}{
+	"ls":      {ls, false},
+	"cat":     {cat, false},
+	"get":     {get, false},
+	"tar-out": {tarOut, false},
+	"put":     {put, true},
+	"tar-in":  {tarIn, true},
+	"fsck":    {fsck, false},
+}
+
+func usage() {
+	fmt.Fprintf(os.Stderr, `usage: v6fs [-i image] command [args]
+
+commands:
+	ls [path...]
+	cat path...
+	put [-m mode] file path
+	get path file
+	tar-in [dir]
+	tar-out [dir]
+	fsck [-r]
+
+flags:
+`)
+	flag.PrintDefaults()
+	os.Exit(2)
+}
+
+// name returns the io/fs name of the path p in the image.
+func name(p string) string {
+	p = strings.Trim(path.Clean("/"+p), "/")
+	if p == "" {
+		return "."
+	}
+	return p
+}
+
+func ls(f *v6fs.FS, args []string) error {
+	if len(args) == 0 {
+		args = []string{"."}
+	}
+	for _, arg := range args {
+		fi, err := f.Stat(name(arg))
+		if err != nil {
+			return err
+		}
+		if !fi.IsDir() {
+			fmt.Println(long(fi))
+			continue
+		}
+		if len(args) > 1 {
+			fmt.Printf("%s:\n", arg)
+		}
+		des, err := f.ReadDir(name(arg))
+		if err != nil {
+			return err
+		}
+		for _, de := range des {
+			fi, err := de.Info()
+			if err != nil {
+				return err
+			}
+			fmt.Println(long(fi))
+		}
+	}
+	return nil
+}
+
+// long formats fi in the style of ls -l.
+func long(fi fs.FileInfo) string {
+	ip := fi.Sys().(*v6fs.Inode)
+	mode := []byte("-rwxrwxrwx")
+	switch ip.Mode & v6fs.IFMT {
+	case v6fs.IFDIR:
+		mode[0] = 'd'
+	case v6fs.IFCHR:
+		mode[0] = 'c'
+	case v6fs.IFBLK:
+		mode[0] = 'b'
+	}
+	for i := 0; i < 9; i++ {
+		if ip.Mode&(0400>>uint(i)) == 0 {
+			mode[i+1] = '-'
+		}
+	}
+	if ip.Mode&v6fs.ISUID != 0 {
+		mode[3] = 's'
+	}
+	if ip.Mode&v6fs.ISGID != 0 {
+		mode[6] = 's'
+	}
+	if ip.Mode&v6fs.ISVTX != 0 {
+		mode[9] = 't'
+	}
+	size := strconv.Itoa(ip.Size)
+	if fi.Mode()&fs.ModeDevice != 0 {
+		size = fmt.Sprintf("%d,%d", ip.Addr[0]>>8, ip.Addr[0]&0377)
+	}
+	return fmt.Sprintf("%5d %s %2d %3d %7s %s %s", ip.Ino, mode, ip.Nlink, ip.Uid, size, ip.Mtime.Format("Jan _2 15:04 2006"), fi.Name())
+}
+
+func cat(f *v6fs.FS, args []string) error {
+	for _, arg := range args {
+		buf, err := f.ReadFile(name(arg))
+		if err != nil {
+			return err
+		}
+		if _, err := os.Stdout.Write(buf); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func get(f *v6fs.FS, args []string) error {
+	if len(args) != 2 {
+		usage()
+	}
+	buf, err := f.ReadFile(name(args[0]))
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(args[1], buf, 0666)
+}
+
+func put(f *v6fs.FS, args []string) error {
+	flags := flag.NewFlagSet("put", flag.ExitOnError)
+	mode := flags.String("m", "", "permissions of the file, in octal; the default is those of the host file")
+	flags.Parse(args)
+	if flags.NArg() != 2 {
+		usage()
+	}
+	src, dst := flags.Arg(0), name(flags.Arg(1))
+	buf, err := ioutil.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	perm := fs.FileMode(0644)
+	if fi, err := os.Stat(src); err == nil {
+		perm = fi.Mode().Perm()
+	}
+	if *mode != "" {
+		m, err := strconv.ParseUint(*mode, 8, 16)
+		if err != nil {
+			return fmt.Errorf("bad mode %q", *mode)
+		}
+		perm = fs.FileMode(m & 0777)
+	}
+	if fi, err := f.Stat(dst); err == nil && fi.IsDir() {
+		dst = path.Join(dst, filepath.Base(src))
+	}
+	return f.WriteFile(dst, buf, perm)
+}
+
+func tarIn(f *v6fs.FS, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = name(args[0])
+	}
+	tr := tar.NewReader(os.Stdin)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		p := name(path.Join(dir, hdr.Name))
+		if p == "." {
+			continue
+		}
+		perm := fs.FileMode(hdr.Mode).Perm()
+		switch hdr.Typeflag {
+		case tar.TypeDir:
+			if err := f.Mkdir(p, perm); err != nil && !errors.Is(err, fs.ErrExist) {
+				return err
+			}
+		case tar.TypeReg, tar.TypeRegA:
+			buf, err := ioutil.ReadAll(tr)
+			if err != nil {
+				return err
+			}
+			if err := f.WriteFile(p, buf, perm); err != nil {
+				return err
+			}
+		default:
+			log.Printf("%s: skipping file of type %q", hdr.Name, hdr.Typeflag)
+		}
+	}
+}
+
+func tarOut(f *v6fs.FS, args []string) error {
+	dir := "."
+	if len(args) > 0 {
+		dir = name(args[0])
+	}
+	tw := tar.NewWriter(os.Stdout)
+	err := fs.WalkDir(f, dir, func(p string, de fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		fi, err := de.Info()
+		if err != nil {
+			return err
+		}
+		ip := fi.Sys().(*v6fs.Inode)
+		rel := strings.TrimPrefix(strings.TrimPrefix(p, dir), "/")
+		if dir == "." {
+			rel = p
+		}
+		if rel == "." || rel == "" {
+			return nil
+		}
+		hdr := &tar.Header{
+			Name:    rel,
+			Mode:    int64(fi.Mode().Perm()),
+			Uid:     int(ip.Uid),
+			Gid:     int(ip.Gid),
+			ModTime: ip.Mtime,
+			Format:  tar.FormatUSTAR,
+		}
+		var buf []byte
+		switch ip.Mode & v6fs.IFMT {
+		case v6fs.IFDIR:
+			hdr.Typeflag = tar.TypeDir
+			hdr.Name += "/"
+		case v6fs.IFCHR, v6fs.IFBLK:
+			hdr.Typeflag = tar.TypeChar
+			if ip.Mode&v6fs.IFMT == v6fs.IFBLK {
+				hdr.Typeflag = tar.TypeBlock
+			}
+			hdr.Devmajor, hdr.Devminor = int64(ip.Addr[0]>>8), int64(ip.Addr[0]&0377)
+		default:
+			hdr.Typeflag = tar.TypeReg
+			if buf, err = f.ReadFile(p); err != nil {
+				return err
+			}
+			hdr.Size = int64(len(buf))
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err = tw.Write(buf)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	return tw.Close()
+}
+
+// repair is set by fsck -r.
+var repair bool
+
+func fsck(f *v6fs.FS, args []string) error {
+	r, err := f.Check(repair)
+	if err != nil {
+		return err
+	}
+	for _, p := range r.Problems {
+		fmt.Println(p)
+	}
+	blocks, _ := f.Size()
+	fmt.Printf("%d files, %d directories, %d free inodes\n", r.Files, r.Dirs, r.FreeInodes)
+	fmt.Printf("%d blocks: %d used, %d free, %d missing\n", blocks, r.Used, r.Free, r.Missing)
+	switch {
+	case r.Repaired:
+		fmt.Println("repaired")
+	case !r.OK():
+		return errors.New("filesystem is inconsistent")
+	}
+	return nil
+}
+
+func main() {
+	log.SetFlags(0)
+	log.SetPrefix("v6fs: ")
+	flag.Usage = usage
+	flag.Parse()
+	if flag.NArg() < 1 {
+		usage()
+	}
+	cmd, ok := commands[flag.Arg(0)]
+	if !ok {
+		usage()
+	}
+	args := flag.Args()[1:]
+	if flag.Arg(0) == "fsck" {
+		flags := flag.NewFlagSet("fsck", flag.ExitOnError)
+		flags.BoolVar(&repair, "r", false, "repair the filesystem")
+		flags.Parse(args)
+		args = flags.Args()
+	}
+	mode := os.O_RDONLY
+	if cmd.writes || repair {
+		mode = os.O_RDWR
+	}
+	img, err := os.OpenFile(*image, mode, 0)
+	if err != nil {
+		log.Fatal(err)
+	}
+	f, err := v6fs.Mount(img)
+	if err != nil {
+		log.Fatalf("%s: %v", *image, err)
+	}
+	err = cmd.run(f, args)
+	if cerr := img.Close(); err == nil {
+		err = cerr
+	}
+	if err != nil {
+		log.Fatal(err)
+	}
+}
@@ -49,6 +49,7 @@ func (c *Console) addchar(char int) {
 	}
 	c.TKS |= 0x80
 	c.ready = false
+	c.unibus.cpu.stats.cons[0]++
 	if c.TKS&(1<<6) != 0 {
 		c.unibus.cpu.interrupt(intTTYIN, 4)
 	}
@@ -79,6 +80,7 @@ func (c *Console) Step() {
 	}
 	if c.TPS&0x80 == 0 {
 		c.writeterminal(c.TPB & 0x7f)
+		c.unibus.cpu.stats.cons[1]++
 		c.TPS |= 0x80
 		if c.TPS&(1<<6) != 0 {
 			c.unibus.cpu.interrupt(intTTYOUT, 4)
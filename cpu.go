@@ -10,11 +10,6 @@ const (
 	timeInstr  = false
 )
 
-var (
-	clkcounter int
-	waiting    = false
-)
-
 // traps
 const (
 	intBUS    = 0004
@@ -25,7 +20,12 @@ const (
 	intTTYOUT = 0064
 	intFAULT  = 0250
 	intCLOCK  = 0100
+	intKWP    = 0104
 	intRK     = 0220
+	intRL     = 0160
+	intRP     = 0254
+	intTM     = 0224
+	intRX     = 0264
 )
 
 func xor(a, b bool) bool { return a != b }
@@ -62,15 +62,33 @@ type cpu struct {
 	pc                uint16 // address of currently executing instructoin
 	KSP, USP          uint16 // kernel and user stack pointer
 	curuser, prevuser bool
+	waiting           bool // executed WAIT, waiting for an interrupt
 
 	Input  chan uint8
 	unibus *unibus
 	mmu    KT11
 
 	interrupts [8]intr
+
+	vtime time.Duration // simulated execution time
+	shift int           // positions shifted by the last ASH or ASHC
+
+	trapvec int // vector of the last trap taken, see RunUntilExit
+
+	prof  *Profile
+	stats stats
+	sys   *Syscalls // system call emulation, see LoadAOut
+
+	// block cache, see blockcache.go
+	blk     *block  // current block
+	blkbase uint16  // virtual address of the current block
+	blkgen  uint64  // mmu.gen when the current block was entered
+	slow    decoded // instruction fetched outside the block cache
+	nocache bool    // disable the block cache
 }
 
 func (k *cpu) switchmode(newm bool) {
+	k.mmu.gen++
 	k.prevuser = k.curuser
 	k.curuser = newm
 	if k.prevuser {
@@ -241,285 +259,138 @@ const (
 )
 
 // total CPU runtime, if timeInstr is true.
+//
+// Deprecated: Runtime is only counted if the package is built with
+// timeInstr set. Use the Cycles method, which is always counted, instead.
 var Runtime time.Duration
 
 func (k *cpu) step() {
-	if waiting {
-		select {
-		case v, ok := <-k.unibus.cons.Input:
-			if ok {
-				k.unibus.cons.addchar(int(v))
-			}
-		default:
-		}
+	if k.waiting {
 		return
 	}
 	k.pc = uint16(k.R[7])
-	ia := k.mmu.decode(k.pc, false, k.curuser)
+	dec := k.fetch(k.pc)
+	instr, op := dec.instr, dec.op
 	k.R[7] += 2
-	instr := INST(k.unibus.read16(ia))
 	if printState {
 		k.printstate()
 	}
+	if k.curuser {
+		k.stats.instrs[1]++
+	} else {
+		k.stats.instrs[0]++
+	}
+	if op == nil {
+		panic(trap{intINVAL, "invalid instruction"})
+	}
+	op.exec(k, instr)
+	d := k.timing(op, uint16(instr))
+	k.vtime += d
 	if timeInstr {
-		Runtime += k.timing(ia)
+		Runtime += d
 	}
-	switch instr & 0070000 {
-	case 0010000: // MOV
-		MOV(k, instr)
-		return
-	case 0020000: // CMP
-		CMP(k, instr)
-		return
-	case 0030000: // BIT
-		BIT(k, instr)
-		return
-	case 0040000: // BIC
-		BIC(k, instr)
-		return
-	case 0050000: // BIS
-		BIS(k, instr)
-		return
+	if k.prof != nil {
+		k.prof.record(k, d)
 	}
-	switch instr & 0170000 {
-	case 0060000: // ADD
-		ADD(k, instr)
-		return
-	case 0160000: // SUB
-		SUB(k, instr)
-		return
+}
+
+func RTS(c *cpu, i INST) {
+	d := i.D()
+	c.R[7] = c.R[d&7]
+	c.R[d&7] = int(c.pop())
+}
+
+// BR executes all the branch instructions.
+func BR(c *cpu, i INST) {
+	if c.taken(uint16(i)) {
+		c.branch(i.O())
 	}
-	switch instr & 0177000 {
-	case 0004000: // JSR
-		JSR(k, instr)
-		return
-	case 0070000: // MUL
-		MUL(k, instr)
-		return
-	case 0071000: // DIV
-		DIV(k, instr)
-		return
-	case 0072000: // ASH
-		ASH(k, instr)
-		return
-	case 0073000: // ASHC
-		ASHC(k, instr)
-		return
-	case 0074000: // XOR
-		XOR(k, instr)
-		return
-	case 0077000: // SOB
-		SOB(k, instr)
-		return
+}
+
+// EMT executes the EMT, TRAP, BPT and IOT instructions.
+func EMT(c *cpu, i INST) {
+	var vec int
+	switch {
+	case (i & 0177400) == 0104000:
+		vec = 030
+	case (i & 0177400) == 0104400:
+		vec = 034
+	case i == 3:
+		vec = 014
+	default:
+		println("IOT")
+		vec = 020
 	}
-	switch instr & 0077700 {
-	case 0005000: // CLR
-		CLR(k, instr)
-		return
-	case 0005100: // COM
-		COM(k, instr)
-		return
-	case 0005200: // INC
-		INC(k, instr)
-		return
-	case 0005300: // DEC
-		DEC(k, instr)
-		return
-	case 0005400: // NEG
-		NEG(k, instr)
-		return
-	case 0005500: // ADC
-		ADC(k, instr)
-		return
-	case 0005600: // SBC
-		SBC(k, instr)
-		return
-	case 0005700: // TST
-		TST(k, instr)
-		return
-	case 0006000: // ROR
-		ROR(k, instr)
-		return
-	case 0006100: // ROL
-		ROL(k, instr)
-		return
-	case 0006200: // ASR
-		ASR(k, instr)
-		return
-	case 0006300: // ASL
-		ASL(k, instr)
-		return
-	case 0006700: // SXT
-		SXT(k, instr)
+	if vec == 034 && c.sys != nil && c.sys.call(c, uint16(i)&0377) {
 		return
 	}
-	switch instr & 0177700 {
-	case 0000100: // JMP
-		JMP(k, instr)
-		return
-	case 0000300: // SWAB
-		SWAB(k, instr)
-		return
-	case 0006400: // MARK
-		MARK(k, instr)
-		break
-	case 0006500: // MFPI
-		MFPI(k, instr)
-		return
-	case 0006600: // MTPI
-		MTPI(k, instr)
-		return
+	c.stats.traps[vec>>2]++
+	c.trapvec = vec
+	prev := uint16(c.PS)
+	c.switchmode(false)
+	c.push(prev)
+	c.push(uint16(c.R[7]))
+	c.R[7] = int(c.unibus.read16(uint18(vec)))
+	c.PS = psw(c.unibus.read16(uint18(vec + 2)))
+	if c.prevuser {
+		c.PS |= (1 << 13) | (1 << 12)
 	}
-	if (instr & 0177770) == 0000200 { // RTS
-		d := instr.D()
-		k.R[7] = k.R[d&7]
-		k.R[d&7] = int(k.pop())
-		return
+}
+
+// CCC executes the condition code operators, CL? and SE?.
+func CCC(c *cpu, i INST) {
+	if i&020 == 020 {
+		c.PS |= psw(i) & 017
+	} else {
+		c.PS &= ^(psw(i) & 017)
 	}
-	switch o := instr.O(); instr & 0177400 {
-	case 0000400:
-		k.branch(o)
-		return
-	case 0001000:
-		if !(k.PS&flagZ == flagZ) {
-			k.branch(o)
-		}
-		return
-	case 0001400:
-		if k.PS&flagZ == flagZ {
-			k.branch(o)
-		}
-		return
-	case 0002000:
-		if !xor(k.PS.N(), k.PS.V()) {
-			k.branch(o)
-		}
-		return
-	case 0002400:
-		if xor(k.PS.N(), k.PS.V()) {
-			k.branch(o)
-		}
-		return
-	case 0003000:
-		if !xor(k.PS.N(), k.PS.V()) && !k.PS.Z() {
-			k.branch(o)
-		}
-		return
-	case 0003400:
-		if xor(k.PS.N(), k.PS.V()) || k.PS.Z() {
-			k.branch(o)
-		}
-		return
-	case 0100000:
-		if !k.PS.N() {
-			k.branch(o)
-		}
-		return
-	case 0100400:
-		if k.PS.N() {
-			k.branch(o)
-		}
-		return
-	case 0101000:
-		if !k.PS.C() && !k.PS.Z() {
-			k.branch(o)
-		}
-		return
-	case 0101400:
-		if k.PS.C() || k.PS.Z() {
-			k.branch(o)
-		}
-		return
-	case 0102000:
-		if !k.PS.V() {
-			k.branch(o)
-		}
-		return
-	case 0102400:
-		if k.PS.V() {
-			k.branch(o)
-		}
-		return
-	case 0103000:
-		if !k.PS.C() {
-			k.branch(o)
-		}
-		return
-	case 0103400:
-		if k.PS.C() {
-			k.branch(o)
-		}
-		return
+}
+
+func HALT(c *cpu, i INST) {
+	if c.curuser {
+		panic(trap{intINVAL, "invalid instruction"})
 	}
-	if (instr&0177000) == 0104000 || instr == 3 || instr == 4 { // EMT TRAP IOT BPT
-		var vec int
-		switch {
-		case (instr & 0177400) == 0104000:
-			vec = 030
-		case (instr & 0177400) == 0104400:
-			vec = 034
-		case instr == 3:
-			vec = 014
-		default:
-			println("IOT")
-			vec = 020
-		}
-		prev := uint16(k.PS)
-		k.switchmode(false)
-		k.push(prev)
-		k.push(uint16(k.R[7]))
-		k.R[7] = int(k.unibus.read16(uint18(vec)))
-		k.PS = psw(k.unibus.read16(uint18(vec + 2)))
-		if k.prevuser {
-			k.PS |= (1 << 13) | (1 << 12)
-		}
-		return
+	fmt.Println("HALT")
+	panic("HALT")
+}
+
+func WAIT(c *cpu, i INST) {
+	if c.curuser {
+		panic(trap{intINVAL, "invalid instruction"})
 	}
-	if (instr & 0177740) == 0240 { // CL?, SE?
-		if instr&020 == 020 {
-			k.PS |= psw(instr) & 017
-		} else {
-			k.PS &= ^(psw(instr) & 017)
-		}
-		return
+	c.wait()
+}
+
+// RTI executes the RTI and RTT instructions.
+func RTI(c *cpu, i INST) {
+	c.R[7] = int(c.pop())
+	val := c.pop()
+	if c.curuser {
+		val &= 047
+		val |= uint16(c.PS) & 0177730
 	}
-	switch instr {
-	case 0000000: // HALT
-		if k.curuser {
-			break
-		}
-		fmt.Println("HALT")
-		panic("HALT")
-		return
-	case 0000001: // WAIT
-		if k.curuser {
-			break
-		}
-		//println("WAIT")
-		waiting = true
-		return
-	case 0000002: // RTI
-		fallthrough
-	case 0000006: // RTT
-		k.R[7] = int(k.pop())
-		val := k.pop()
-		if k.curuser {
-			val &= 047
-			val |= uint16(k.PS) & 0177730
-		}
-		k.unibus.write16(0777776, val)
-		return
-	case 0000005: // RESET
-		if k.curuser {
-			return
-		}
-		k.unibus.cons.clearterminal()
-		k.unibus.rk.rkreset()
-		return
-	case 0170011: // SETD ; not needed by UNIX, but used; therefore ignored
+	c.unibus.write16(0777776, val)
+}
+
+func RESET(c *cpu, i INST) {
+	if c.curuser {
 		return
 	}
-	panic(trap{intINVAL, "invalid instruction"})
+	c.unibus.cons.clearterminal()
+	c.unibus.rk.rkreset()
+	c.unibus.rl.reset()
+	c.unibus.rp.reset()
+	c.unibus.tm.reset()
+	c.unibus.rx.reset()
+	c.unibus.kwp.reset()
+}
+
+// FP executes the floating point instructions, of which only SETD is
+// implemented. It is not needed by UNIX, but used; therefore ignored.
+func FP(c *cpu, i INST) {
+	if i != 0170011 {
+		panic(trap{intINVAL, "invalid instruction"})
+	}
 }
 
 func (c *cpu) interrupt(vec, pri int) {
@@ -545,7 +416,7 @@ func (c *cpu) interrupt(vec, pri int) {
 	if i == len(c.interrupts) {
 		panic("interrupt table full")
 	}
-	for j := i + 1; j < len(c.interrupts); j++ {
+	for j := len(c.interrupts) - 1; j > i; j-- {
 		c.interrupts[j] = c.interrupts[j-1]
 	}
 	c.interrupts[i] = intr{vec, pri}
@@ -564,17 +435,23 @@ func (k *cpu) Reset() {
 	k.curuser = false
 	k.prevuser = false
 	k.mmu.SR0 = 0
-	k.unibus.LKS = 1 << 7
+	k.unibus.clock.reset()
 	k.unibus.Reset()
 	for i := 0; i < 16; i++ {
 		k.mmu.pages[i].par = 0
 		k.mmu.pages[i].pdr = 0
 	}
+	k.mmu.flush()
 	k.unibus.cons.clearterminal()
 	k.unibus.cons.Input = k.Input
 	k.unibus.rk.rkreset()
-	clkcounter = 0
-	waiting = false
+	k.unibus.rl.reset()
+	k.unibus.rp.reset()
+	k.unibus.tm.reset()
+	k.unibus.rx.reset()
+	k.unibus.kwp.reset()
+	k.vtime = 0
+	k.wake()
 }
 
 func MOV(c *cpu, i INST) {
@@ -834,6 +711,7 @@ func ASH(c *cpu, i INST) {
 			c.PS |= flagC
 		}
 	}
+	c.shift = int(val2)
 	c.R[s&7] = int(val)
 	if val == 0 {
 		c.PS |= flagZ
@@ -871,6 +749,7 @@ func ASHC(c *cpu, i INST) {
 			c.PS |= flagC
 		}
 	}
+	c.shift = int(val2)
 	c.R[s&7] = int(val>>16) & 0xFFFF
 	c.R[(s&7)|1] = int(val) & 0xFFFF
 	if val == 0 {
@@ -1334,7 +1213,8 @@ func (c *cpu) printstate() {
 	} else {
 		fmt.Print(" ")
 	}
-	ia := c.mmu.decode(c.pc, false, c.curuser)
-	instr := c.unibus.read16(ia)
-	fmt.Printf("]  instr %06o: %06o   %s\n", c.pc, instr, c.disasm(ia))
+	read := c.VirtualMemory(c.curuser)
+	instr, _ := read(uint32(c.pc))
+	d := Disassembler{Read: read}
+	fmt.Printf("]  instr %06o: %06o   %s\n", c.pc, instr, d.Disasm(uint32(c.pc)).Text)
 }
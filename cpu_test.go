@@ -1,6 +1,11 @@
 package pdp11
 
-import "testing"
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+)
 
 type regs struct {
 	R0, R1, R2, R3, R4, R5, R6, R7 int
@@ -387,6 +392,21 @@ func BenchmarkADD(b *testing.B) {
 	}
 }
 
+func benchInstr(b *testing.B, instr uint16) {
+	cpu := New()
+	cpu.LoadMemory(core{001000: instr})
+	cpu.R[1] = 002000
+	for i := 0; i < b.N; i++ {
+		cpu.R[7] = 001000
+		cpu.step()
+	}
+}
+
+func BenchmarkMOVB(b *testing.B) { benchInstr(b, 0110111) } // MOVB R1, (R1)
+func BenchmarkBNE(b *testing.B)  { benchInstr(b, 0001377) } // BNE .
+func BenchmarkSOB(b *testing.B)  { benchInstr(b, 0077101) } // SOB R1, .
+func BenchmarkCLC(b *testing.B)  { benchInstr(b, 0000241) }
+
 func instrTest(t *testing.T, tt suite) {
 	t.Log(tt.name)
 	cpu := New()
@@ -439,3 +459,323 @@ func checkRegs(t *testing.T, c *cpu, regs regs) {
 		t.Errorf("PS: got %06o, want %06o", c.PS, regs.PS)
 	}
 }
+
+var timingTests = []struct {
+	name   string
+	regs   regs
+	core   core
+	cycles uint64
+}{
+	{"BEQ taken", regs{R7: 001000, PS: flagZ}, core{001000: 001400}, 176},
+	{"BEQ not taken", regs{R7: 001000}, core{001000: 001400}, 140},
+	{"SOB taken", regs{R0: 2, R7: 001000}, core{001000: 077001}, 236},
+	{"SOB not taken", regs{R0: 1, R7: 001000}, core{001000: 077001}, 204},
+	{"ASH R0, R1 by 3", regs{R1: 3, R7: 001000}, core{001000: 072001}, 258 + 3*20},
+	{"ASH R0, R1 by -2", regs{R1: 076, R7: 001000}, core{001000: 072001}, 258 + 2*20},
+	{"XOR R0, R1", regs{R7: 001000}, core{001000: 074001}, 99},
+	{"XOR R0, (R1)", regs{R1: 002000, R7: 001000}, core{001000: 074011}, 78 + 176},
+	{"CLR R1", regs{R7: 001000}, core{001000: 005001}, 99},
+	{"CLRB (R1)", regs{R1: 002000, R7: 001000}, core{001000: 0105011}, 90 + 177},
+}
+
+func TestTiming(t *testing.T) {
+	for _, tt := range timingTests {
+		cpu := New()
+		cpu.LoadMemory(tt.core)
+		loadRegs(&cpu.cpu, tt.regs)
+		cpu.Step()
+		if got := cpu.Cycles(); got != tt.cycles {
+			t.Errorf("%s: got %d cycles, want %d", tt.name, got, tt.cycles)
+		}
+	}
+}
+
+// TestTimingAll checks that every instruction can be timed, as the
+// virtual clock depends on it.
+func TestTimingAll(t *testing.T) {
+	pdp := New()
+	if err := pdp.SetClock(VirtualClock, 60); err != nil {
+		t.Fatal(err)
+	}
+	pdp.LoadMemory(core{001000: 074001, 001002: 074011}) // XOR R0, R1; XOR R0, (R1)
+	pdp.SetPC(001000)
+	pdp.R[1] = 002000
+	pdp.Step()
+	pdp.Step()
+	if got, want := pdp.Cycles(), uint64(99+78+176); got != want {
+		t.Errorf("XOR with the virtual clock: got %d cycles, want %d", got, want)
+	}
+	for ins := range optable {
+		if op := optable[ins]; op != nil {
+			if d := pdp.timing(op, uint16(ins)); d <= 0 {
+				t.Errorf("%06o: time %v", ins, d)
+			}
+		}
+	}
+}
+
+// boot returns a PDP1140 booting UNIX from rk0 with input queued on
+// the console.
+func boot(input string) *PDP1140 {
+	pdp := New()
+	pdp.LoadMemory(BOOTRK05)
+	pdp.SetPC(002002)
+	pdp.Attach(0, "rk0")
+	pdp.cpu.Input = make(chan uint8, len(input))
+	pdp.cons.Input = pdp.cpu.Input
+	for _, c := range input {
+		pdp.cpu.Input <- uint8(c)
+	}
+	return pdp
+}
+
+func benchBoot(b *testing.B, input string, steps int, nocache bool) {
+	for i := 0; i < b.N; i++ {
+		pdp := boot(input)
+		pdp.nocache = nocache
+		for j := 0; j < steps; j++ {
+			pdp.Step()
+		}
+	}
+	b.ReportMetric(float64(b.N*steps)/b.Elapsed().Seconds(), "instrs/s")
+}
+
+// BenchmarkBoot measures booting UNIX from rk0 to the shell prompt.
+func BenchmarkBoot(b *testing.B) { benchBoot(b, "unix\n", 2000000, false) }
+
+const mkconf = "unix\nchdir /usr/sys/conf\ncc mkconf.c\n"
+
+// BenchmarkMkconf measures compiling mkconf.c.
+func BenchmarkMkconf(b *testing.B) { benchBoot(b, mkconf, 3*N, false) }
+
+// BenchmarkMkconfNoCache measures compiling mkconf.c without the block
+// cache.
+func BenchmarkMkconfNoCache(b *testing.B) { benchBoot(b, mkconf, 3*N, true) }
+
+func TestBlockCache(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+	cached, uncached := boot(mkconf), boot(mkconf)
+	uncached.nocache = true
+	for i := 0; i < 3*N; i++ {
+		cached.Step()
+		uncached.Step()
+		if cached.R != uncached.R || cached.PS != uncached.PS {
+			t.Fatalf("step %d: registers differ, cached: %06o %06o, uncached: %06o %06o", i, cached.R, cached.PS, uncached.R, uncached.PS)
+		}
+	}
+	if cached.Memory != uncached.Memory {
+		t.Fatal("memory differs")
+	}
+}
+
+// TestRedStack checks that the PC and PS saved at location 0 by a red
+// stack trap invalidate the block cache.
+func TestRedStack(t *testing.T) {
+	pdp := New()
+	pdp.LoadMemory(core{000000: 000004}) // IOT
+	pdp.SetPC(0)
+	pdp.R[6] = 0177000 // pushing faults
+	func() {
+		defer func() {
+			if r := recover(); r != "fatal" {
+				t.Fatalf("recovered %v, want fatal", r)
+			}
+		}()
+		pdp.Step()
+	}()
+	if pdp.Memory[0] != 2 {
+		t.Errorf("saved PC %06o, want 000002", pdp.Memory[0])
+	}
+	if pdp.blocks[0] != nil {
+		t.Error("block 0 still cached after the red stack trap")
+	}
+}
+
+// mapped returns a machine with kernel page 0 mapped read/write onto the
+// first 8KB of memory, and the MMU enabled.
+func mapped() *PDP1140 {
+	p := New()
+	p.unibus.write16(0772300, 077406) // KISD0: length 127, read/write
+	p.unibus.write16(0772340, 0)      // KISA0
+	p.unibus.write16(0777572, 1)      // SR0: enable
+	return p
+}
+
+func TestKT11(t *testing.T) {
+	p := mapped()
+	m := &p.mmu
+	if a := m.decode(0100, false, false); a != 0100 {
+		t.Fatalf("decode(0100): got %06o, want 000100", a)
+	}
+	if m.pages[0].pdr&(1<<6) != 0 {
+		t.Fatal("W bit set by read")
+	}
+	m.decode(0100, true, false)
+	if m.pages[0].pdr&(1<<6) == 0 {
+		t.Fatal("W bit not set by write")
+	}
+	p.unibus.write16(0772340, 010) // KISA0: 01000
+	if a := m.decode(0100, false, false); a != 01100 {
+		t.Fatalf("decode(0100) after PAR write: got %06o, want 001100", a)
+	}
+	p.unibus.write16(0772300, 002) // KISD0: length 0, read only
+	func() {
+		defer func() {
+			if _, ok := recover().(trap); !ok {
+				t.Fatal("write to read only page did not trap")
+			}
+		}()
+		m.decode(0100, true, false)
+	}()
+	if m.SR0&(1<<13) == 0 {
+		t.Fatalf("SR0: got %06o, want read only abort", m.SR0)
+	}
+	func() {
+		defer func() {
+			if _, ok := recover().(trap); !ok {
+				t.Fatal("access beyond page length did not trap")
+			}
+		}()
+		m.decode(0200, false, false)
+	}()
+	if m.SR0&(1<<14) == 0 {
+		t.Fatalf("SR0: got %06o, want page length abort", m.SR0)
+	}
+}
+
+func BenchmarkDecode(b *testing.B) {
+	m := &mapped().mmu
+	for i := 0; i < b.N; i++ {
+		m.decode(0100, false, false)
+	}
+}
+
+// BenchmarkDecodeSlow measures translation without the cache.
+func BenchmarkDecodeSlow(b *testing.B) {
+	m := &mapped().mmu
+	for i := 0; i < b.N; i++ {
+		m.translate(0100, false, false)
+	}
+}
+
+// disasmsrc exercises every operand form the disassembler prints.
+const disasmsrc = `
+. = 1000
+start:	mov	$1234, r0
+	movb	(r1)+, -(r2)
+	mov	*(r3)+, *-(r4)
+	add	12(r5), -4(sp)
+	sub	*6(r0), *-2(r1)
+	clr	*$177566
+	inc	data
+	tst	*data
+	jsr	pc, sub
+	mul	$3, r2
+	ashc	r1, r4
+	xor	r3, (r5)
+	sob	r0, start
+	bne	start
+	br	done
+	rts	pc
+	emt	12
+	sys	1
+	mark	3
+	sec
+	scc
+	ccc
+	setd
+	fadd	r5
+sub:	rts	pc
+done:	halt
+data:	0
+`
+
+func TestDisasm(t *testing.T) {
+	mem, err := Assemble(disasmsrc)
+	if err != nil {
+		t.Fatal(err)
+	}
+	read := func(a uint32) (uint16, bool) {
+		w, ok := mem[uint18(a)]
+		return w, ok
+	}
+	d := Disassembler{Read: read}
+
+	// disassembling and assembling again must give the same words.
+	var src bytes.Buffer
+	for a := uint32(01000); read1(mem, a); {
+		in := d.Disasm(a)
+		if strings.HasPrefix(in.Text, ".word") && in.Words[0] != 0 {
+			t.Errorf("%06o: %06o not disassembled", a, in.Words[0])
+		}
+		fmt.Fprintf(&src, ". = %o\n%s\n", a, in.Text)
+		a += 2 * uint32(len(in.Words))
+	}
+	again, err := Assemble(src.String())
+	if err != nil {
+		t.Fatalf("%v\n%s", err, src.String())
+	}
+	for a, w := range mem {
+		if again[a] != w {
+			t.Errorf("%06o: got %06o, want %06o", a, again[a], w)
+		}
+	}
+
+	// instructions the 11/40 does not execute, and words which are not
+	// instructions.
+	for _, tt := range []struct {
+		words []uint16
+		want  string
+	}{
+		{[]uint16{0172667, 000010}, "LDF 001014, FR2"},
+		{[]uint16{0174001}, "STF FR0, FR1"},
+		{[]uint16{0170100, 0}, "LDFPS R0"},
+		{[]uint16{0106527, 000004}, "MFPD $4"},
+		{[]uint16{0000243}, "CLVC"},
+		{[]uint16{0007000}, ".word 007000"},
+		{[]uint16{0012700}, ".word 012700"}, // immediate cannot be read
+	} {
+		d := Disassembler{Read: func(a uint32) (uint16, bool) {
+			i := int(a-01000) / 2
+			if i >= len(tt.words) {
+				return 0, false
+			}
+			return tt.words[i], true
+		}}
+		if got := d.Disasm(01000).Text; got != tt.want {
+			t.Errorf("%06o: got %q, want %q", tt.words, got, tt.want)
+		}
+	}
+
+	// symbols name labels and operands.
+	words := []uint16{0012700, 0001234, 0000775}
+	d = Disassembler{
+		Read: func(a uint32) (uint16, bool) {
+			i := int(a-01000) / 2
+			return words[i], true
+		},
+		Symbols: Symtab{
+			{Name: "L1", Type: SymText, Value: 01000},
+			{Name: "start", Type: SymText | SymExt, Value: 01000},
+			{Name: "five", Type: SymAbs, Value: 01004},
+		},
+	}
+	var out bytes.Buffer
+	if err := d.List(&out, 01000, 01006); err != nil {
+		t.Fatal(err)
+	}
+	want := `start:
+001000: 012700 001234         MOV $1234, R0
+001004: 000775                BR start
+`
+	if out.String() != want {
+		t.Errorf("List: got\n%s\nwant\n%s", out.String(), want)
+	}
+}
+
+func read1(mem map[uint18]uint16, a uint32) bool {
+	_, ok := mem[uint18(a)]
+	return ok
+}
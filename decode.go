@@ -0,0 +1,92 @@
+package pdp11
+
+// opcode describes an instruction: how to recognise it, how to
+// disassemble it and how to execute it.
+type opcode struct {
+	mask, match uint16
+	name        string
+	flag        uint // operands, for disassembly
+	b           bool // has a byte form
+	exec        func(*cpu, INST)
+}
+
+// opcodes lists every instruction the processor implements. Where masks
+// overlap, the first match wins.
+var opcodes = []opcode{
+	{0077700, 0005000, "CLR", flagD, true, CLR},
+	{0077700, 0005100, "COM", flagD, true, COM},
+	{0077700, 0005200, "INC", flagD, true, INC},
+	{0077700, 0005300, "DEC", flagD, true, DEC},
+	{0077700, 0005400, "NEG", flagD, true, NEG},
+	{0077700, 0005700, "TST", flagD, true, TST},
+	{0077700, 0006200, "ASR", flagD, true, ASR},
+	{0077700, 0006300, "ASL", flagD, true, ASL},
+	{0077700, 0006000, "ROR", flagD, true, ROR},
+	{0077700, 0006100, "ROL", flagD, true, ROL},
+	{0177700, 0000300, "SWAB", flagD, false, SWAB},
+	{0077700, 0005500, "ADC", flagD, true, ADC},
+	{0077700, 0005600, "SBC", flagD, true, SBC},
+	{0177700, 0006700, "SXT", flagD, false, SXT},
+	{0070000, 0010000, "MOV", flagS | flagD, true, MOV},
+	{0070000, 0020000, "CMP", flagS | flagD, true, CMP},
+	{0170000, 0060000, "ADD", flagS | flagD, false, ADD},
+	{0170000, 0160000, "SUB", flagS | flagD, false, SUB},
+	{0070000, 0030000, "BIT", flagS | flagD, true, BIT},
+	{0070000, 0040000, "BIC", flagS | flagD, true, BIC},
+	{0070000, 0050000, "BIS", flagS | flagD, true, BIS},
+	{0177000, 0070000, "MUL", flagSR, false, MUL},
+	{0177000, 0071000, "DIV", flagSR, false, DIV},
+	{0177000, 0072000, "ASH", flagSR, false, ASH},
+	{0177000, 0073000, "ASHC", flagSR, false, ASHC},
+	{0177000, 0074000, "XOR", flagR | flagD, false, XOR},
+	{0177400, 0000400, "BR", flagO, false, BR},
+	{0177400, 0001000, "BNE", flagO, false, BR},
+	{0177400, 0001400, "BEQ", flagO, false, BR},
+	{0177400, 0100000, "BPL", flagO, false, BR},
+	{0177400, 0100400, "BMI", flagO, false, BR},
+	{0177400, 0101000, "BHI", flagO, false, BR},
+	{0177400, 0101400, "BLOS", flagO, false, BR},
+	{0177400, 0102000, "BVC", flagO, false, BR},
+	{0177400, 0102400, "BVS", flagO, false, BR},
+	{0177400, 0103000, "BCC", flagO, false, BR},
+	{0177400, 0103400, "BCS", flagO, false, BR},
+	{0177400, 0002000, "BGE", flagO, false, BR},
+	{0177400, 0002400, "BLT", flagO, false, BR},
+	{0177400, 0003000, "BGT", flagO, false, BR},
+	{0177400, 0003400, "BLE", flagO, false, BR},
+	{0177700, 0000100, "JMP", flagD, false, JMP},
+	{0177000, 0004000, "JSR", flagR | flagD, false, JSR},
+	{0177770, 0000200, "RTS", flagR, false, RTS},
+	{0177700, 0006400, "MARK", flagMark, false, MARK},
+	{0177000, 0077000, "SOB", flagR | flagO, false, SOB},
+	{0177777, 0000005, "RESET", 0, false, RESET},
+	{0177700, 0006500, "MFPI", flagD, false, MFPI},
+	{0177700, 0006600, "MTPI", flagD, false, MTPI},
+	{0177777, 0000000, "HALT", 0, false, HALT},
+	{0177777, 0000001, "WAIT", 0, false, WAIT},
+	{0177777, 0000002, "RTI", 0, false, RTI},
+	{0177777, 0000006, "RTT", 0, false, RTI},
+	{0177400, 0104000, "EMT", flagNone, false, EMT},
+	{0177400, 0104400, "TRAP", flagNone, false, EMT},
+	{0177777, 0000003, "BPT", 0, false, EMT},
+	{0177777, 0000004, "IOT", 0, false, EMT},
+	{0177777, 0000240, "NOP", 0, false, CCC},
+	{0177760, 0000240, "CL", flagCC, false, CCC},
+	{0177760, 0000260, "SE", flagCC, false, CCC},
+	{0170000, 0170000, "FP", 0, false, FP},
+}
+
+// optable maps every instruction word to its opcode, or nil if the word
+// is not a valid instruction.
+var optable [1 << 16]*opcode
+
+func init() {
+	for i := range optable {
+		for j := range opcodes {
+			if uint16(i)&opcodes[j].mask == opcodes[j].match {
+				optable[i] = &opcodes[j]
+				break
+			}
+		}
+	}
+}
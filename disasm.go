@@ -1,99 +1,146 @@
 package pdp11
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"strings"
+)
 
 var rs = [...]string{"R0", "R1", "R2", "R3", "R4", "R5", "SP", "PC"}
 
+// operands of an opcode, for disassembly
 const (
-	flagD    = 1 << 0
-	flagS    = 1 << 1
-	flagO    = 1 << 2
-	flagR    = 1 << 3
-	flagNone = 1 << 4
+	flagD    = 1 << 0 // destination
+	flagS    = 1 << 1 // source
+	flagO    = 1 << 2 // branch offset
+	flagR    = 1 << 3 // register
+	flagNone = 1 << 4 // 8 bit number, EMT and TRAP
+	flagCC   = 1 << 5 // condition codes
+	flagSR   = 1 << 6 // source and register, MUL
+	flagMark = 1 << 7 // 6 bit number, MARK
 )
 
-var disasmtable = []struct {
-	inst, arg uint16
-	msg       string
-	flag      uint
-	b         bool
-}{
-	{0077700, 0005000, "CLR", flagD, true},
-	{0077700, 0005100, "COM", flagD, true},
-	{0077700, 0005200, "INC", flagD, true},
-	{0077700, 0005300, "DEC", flagD, true},
-	{0077700, 0005400, "NEG", flagD, true},
-	{0077700, 0005700, "TST", flagD, true},
-	{0077700, 0006200, "ASR", flagD, true},
-	{0077700, 0006300, "ASL", flagD, true},
-	{0077700, 0006000, "ROR", flagD, true},
-	{0077700, 0006100, "ROL", flagD, true},
-	{0177700, 0000300, "SWAB", flagD, false},
-	{0077700, 0005500, "ADC", flagD, true},
-	{0077700, 0005600, "SBC", flagD, true},
-	{0177700, 0006700, "SXT", flagD, false},
-	{0070000, 0010000, "MOV", flagS | flagD, true},
-	{0070000, 0020000, "CMP", flagS | flagD, true},
-	{0170000, 0060000, "ADD", flagS | flagD, false},
-	{0170000, 0160000, "SUB", flagS | flagD, false},
-	{0070000, 0030000, "BIT", flagS | flagD, true},
-	{0070000, 0040000, "BIC", flagS | flagD, true},
-	{0070000, 0050000, "BIS", flagS | flagD, true},
-	{0177000, 0070000, "MUL", flagR | flagD, false},
-	{0177000, 0071000, "DIV", flagR | flagD, false},
-	{0177000, 0072000, "ASH", flagR | flagD, false},
-	{0177000, 0073000, "ASHC", flagR | flagD, false},
-	{0177400, 0000400, "BR", flagO, false},
-	{0177400, 0001000, "BNE", flagO, false},
-	{0177400, 0001400, "BEQ", flagO, false},
-	{0177400, 0100000, "BPL", flagO, false},
-	{0177400, 0100400, "BMI", flagO, false},
-	{0177400, 0101000, "BHI", flagO, false},
-	{0177400, 0101400, "BLOS", flagO, false},
-	{0177400, 0102000, "BVC", flagO, false},
-	{0177400, 0102400, "BVS", flagO, false},
-	{0177400, 0103000, "BCC", flagO, false},
-	{0177400, 0103400, "BCS", flagO, false},
-	{0177400, 0002000, "BGE", flagO, false},
-	{0177400, 0002400, "BLT", flagO, false},
-	{0177400, 0003000, "BGT", flagO, false},
-	{0177400, 0003400, "BLE", flagO, false},
-	{0177700, 0000100, "JMP", flagD, false},
-	{0177000, 0004000, "JSR", flagR | flagD, false},
-	{0177770, 0000200, "RTS", flagR, false},
-	{0177777, 0006400, "MARK", 0, false},
-	{0177000, 0077000, "SOB", flagR | flagO, false},
-	{0177777, 0000005, "RESET", 0, false},
-	{0177700, 0006500, "MFPI", flagD, false},
-	{0177700, 0006600, "MTPI", flagD, false},
-	{0177777, 0000001, "WAIT", 0, false},
-	{0177777, 0000002, "RTI", 0, false},
-	{0177777, 0000006, "RTT", 0, false},
-	{0177400, 0104000, "EMT", flagNone, false},
-	{0177400, 0104400, "TRAP", flagNone, false},
-	{0177777, 0000003, "BPT", 0, false},
-	{0177777, 0000004, "IOT", 0, false},
-	{0170000, 0170000, "FP", 0, false},
-}
-
-func (c *cpu) disasmaddr(m uint16, a uint18) string {
-	if (m & 7) == 7 {
-		switch m {
-		case 027:
-			a += 2
-			return fmt.Sprintf("$%06o", c.unibus.read16(a))
-		case 037:
-			a += 2
-			return fmt.Sprintf("*%06o", c.unibus.read16(a))
-		case 067:
-			a += 2
-			return fmt.Sprintf("*%06o", (a+2+uint18(c.unibus.read16(a)))&0xFFFF)
-		case 077:
-			return fmt.Sprintf("**%06o", (a+2+uint18(c.unibus.read16(a)))&0xFFFF)
+// Disassembler disassembles PDP-11 code, in the syntax of UNIX as with
+// upper case mnemonics.
+type Disassembler struct {
+	// Read returns the word at addr, or false if it cannot be read.
+	Read func(addr uint32) (uint16, bool)
+
+	// Symbols, if not nil, name the addresses referred to by
+	// branches, jumps and memory operands, and label the listing.
+	// Text, data and bss symbols are used, external ones in preference.
+	// They must be sorted by value, as by NewSymtab.
+	Symbols Symtab
+}
+
+// Instruction is a disassembled instruction.
+type Instruction struct {
+	Addr  uint32
+	Words []uint16 // the instruction and its operands
+	Text  string
+}
+
+// Disasm disassembles the instruction at addr. Words which are not
+// instructions, and instructions whose operands cannot be read, are
+// disassembled as .word. If the word at addr cannot be read, Words is
+// empty.
+func (d *Disassembler) Disasm(addr uint32) Instruction {
+	ins, ok := d.Read(addr)
+	if !ok {
+		return Instruction{Addr: addr, Text: "?"}
+	}
+	s := &disasm{d: d, in: Instruction{Addr: addr, Words: []uint16{ins}}, ok: true}
+	var text string
+	if op := optable[ins]; op != nil && op.name != "FP" {
+		text = s.opcode(op)
+	} else if e := extop(ins); e != nil {
+		text = s.extended(e)
+	} else {
+		s.ok = false
+	}
+	if !s.ok {
+		return Instruction{Addr: addr, Words: []uint16{ins}, Text: fmt.Sprintf(".word %06o", ins)}
+	}
+	s.in.Text = text
+	return s.in
+}
+
+// disasm holds the state of the disassembly of one instruction.
+type disasm struct {
+	d  *Disassembler
+	in Instruction
+	ok bool // all operand words could be read
+}
+
+func (s *disasm) opcode(op *opcode) string {
+	ins := s.in.Words[0]
+	name := op.name
+	if op.b && ins&0100000 != 0 {
+		name += "B"
+	}
+	r := rs[(ins>>6)&7]
+	switch op.flag {
+	case flagS | flagD:
+		src := s.operand((ins >> 6) & 077)
+		return name + " " + src + ", " + s.operand(ins&077)
+	case flagD:
+		return name + " " + s.operand(ins&077)
+	case flagSR:
+		return name + " " + s.operand(ins&077) + ", " + r
+	case flagR | flagD:
+		return name + " " + r + ", " + s.operand(ins&077)
+	case flagR:
+		return name + " " + rs[ins&7]
+	case flagO:
+		return name + " " + s.d.addr(s.in.Addr+2+uint32(2*int32(int8(ins))))
+	case flagR | flagO:
+		return name + " " + r + ", " + s.d.addr(s.in.Addr+2-uint32(2*(ins&077)))
+	case flagNone:
+		return fmt.Sprintf("%s %o", name, ins&0377)
+	case flagMark:
+		return fmt.Sprintf("%s %o", name, ins&077)
+	case flagCC:
+		if ins&017 == 017 {
+			return map[string]string{"CL": "CCC", "SE": "SCC"}[name]
+		}
+		for _, f := range []struct {
+			bit uint16
+			c   string
+		}{{flagN, "N"}, {flagZ, "Z"}, {flagV, "V"}, {flagC, "C"}} {
+			if ins&f.bit != 0 {
+				name += f.c
+			}
+		}
+	}
+	return name
+}
+
+// operand disassembles the operand with the given mode and register,
+// appending its index or immediate word, if any, to the instruction.
+func (s *disasm) operand(mode uint16) string {
+	r := rs[mode&7]
+	var x uint16
+	if mode&060 == 060 || mode&067 == 027 {
+		w, ok := s.d.Read(s.in.Addr + 2*uint32(len(s.in.Words)))
+		if !ok {
+			s.ok = false
+			return "?"
 		}
+		s.in.Words = append(s.in.Words, w)
+		x = w
+	}
+	next := s.in.Addr + 2*uint32(len(s.in.Words))
+	switch mode {
+	case 027:
+		return fmt.Sprintf("$%o", x)
+	case 037:
+		return "*$" + s.d.addr(uint32(x))
+	case 067:
+		return s.d.addr(next + uint32(x))
+	case 077:
+		return "*" + s.d.addr(next+uint32(x))
 	}
-	r := rs[m&7]
-	switch m & 070 {
+	switch mode & 070 {
 	case 000:
 		return r
 	case 010:
@@ -107,55 +154,182 @@ func (c *cpu) disasmaddr(m uint16, a uint18) string {
 	case 050:
 		return "*-(" + r + ")"
 	case 060:
-		a += 2
-		return fmt.Sprintf("%06o (%s)", c.unibus.read16(a), r)
-	case 070:
-		a += 2
-		return fmt.Sprintf("*%06o (%s)", c.unibus.read16(a), r)
-	}
-	panic(fmt.Sprintf("disasmaddr: unknown addressing mode, register %v, mode %o", r, m&070))
-}
-
-func (c *cpu) disasm(a uint18) string {
-	ins := c.unibus.read16(a)
-	l := disasmtable[0]
-	for i := 0; i < len(disasmtable); i++ {
-		l = disasmtable[i]
-		if (ins & l.inst) == l.arg {
-			goto found
+		return offset(x) + "(" + r + ")"
+	default:
+		return "*" + offset(x) + "(" + r + ")"
+	}
+}
+
+// offset formats an index as a signed octal number.
+func offset(x uint16) string {
+	if int16(x) < 0 {
+		return fmt.Sprintf("-%o", -x)
+	}
+	return fmt.Sprintf("%o", x)
+}
+
+// addr formats an address, symbolically if possible. Addresses wrap at
+// 64KB, as the processor computes them.
+func (d *Disassembler) addr(a uint32) string {
+	v := uint16(a)
+	if sym, ok := d.symbol(v); ok {
+		if sym.Value == v {
+			return sym.Name
+		}
+		return fmt.Sprintf("%s+%o", sym.Name, v-sym.Value)
+	}
+	return fmt.Sprintf("%06o", v)
+}
+
+// symbol returns the text, data or bss symbol at or before addr,
+// preferring external symbols.
+func (d *Disassembler) symbol(addr uint16) (Symbol, bool) {
+	return d.Symbols.Find(addr, SymText|SymExt, SymData|SymExt, SymBss|SymExt, SymText, SymData, SymBss)
+}
+
+// List writes a listing of the memory from start up to end to w, with
+// a label before each address which has a symbol.
+func (d *Disassembler) List(w io.Writer, start, end uint32) error {
+	for a := start; a < end; {
+		if sym, ok := d.symbol(uint16(a)); ok && a < 1<<16 && sym.Value == uint16(a) {
+			if _, err := fmt.Fprintf(w, "%s:\n", sym.Name); err != nil {
+				return err
+			}
+		}
+		in := d.Disasm(a)
+		var words []string
+		for _, w := range in.Words {
+			words = append(words, fmt.Sprintf("%06o", w))
+		}
+		if _, err := fmt.Fprintf(w, "%06o: %-21s %s\n", a, strings.Join(words, " "), in.Text); err != nil {
+			return err
+		}
+		if len(in.Words) == 0 {
+			a += 2
+		}
+		a += 2 * uint32(len(in.Words))
+	}
+	return nil
+}
+
+// extended describes the FP11 floating point, FIS, MFPD and MTPD
+// instructions, which this 11/40 does not execute but which appear in
+// programs for other models.
+type extended struct {
+	mask, match uint16
+	name        string
+	form        int
+}
+
+// forms of extended instructions
+const (
+	fpNone  = iota
+	fpSrc   // LDFPS src, MFPD src
+	fpFdst  // CLRF fdst
+	fpFsrcA // MULF fsrc, ac
+	fpAFdst // STF ac, fdst
+	fpADst  // STEXP ac, dst
+	fpSrcA  // LDEXP src, ac
+	fisR    // FADD r
+)
+
+var extops = []extended{
+	{0177777, 0170000, "CFCC", fpNone},
+	{0177777, 0170001, "SETF", fpNone},
+	{0177777, 0170002, "SETI", fpNone},
+	{0177777, 0170011, "SETD", fpNone},
+	{0177777, 0170012, "SETL", fpNone},
+	{0177700, 0170100, "LDFPS", fpSrc},
+	{0177700, 0170200, "STFPS", fpSrc},
+	{0177700, 0170300, "STST", fpSrc},
+	{0177700, 0170400, "CLRF", fpFdst},
+	{0177700, 0170500, "TSTF", fpFdst},
+	{0177700, 0170600, "ABSF", fpFdst},
+	{0177700, 0170700, "NEGF", fpFdst},
+	{0177400, 0171000, "MULF", fpFsrcA},
+	{0177400, 0171400, "MODF", fpFsrcA},
+	{0177400, 0172000, "ADDF", fpFsrcA},
+	{0177400, 0172400, "LDF", fpFsrcA},
+	{0177400, 0173000, "SUBF", fpFsrcA},
+	{0177400, 0173400, "CMPF", fpFsrcA},
+	{0177400, 0174000, "STF", fpAFdst},
+	{0177400, 0174400, "DIVF", fpFsrcA},
+	{0177400, 0175000, "STEXP", fpADst},
+	{0177400, 0175400, "STCFI", fpADst},
+	{0177400, 0176000, "STCFD", fpAFdst},
+	{0177400, 0176400, "LDEXP", fpSrcA},
+	{0177400, 0177000, "LDCIF", fpSrcA},
+	{0177400, 0177400, "LDCDF", fpFsrcA},
+	{0177700, 0106500, "MFPD", fpSrc},
+	{0177700, 0106600, "MTPD", fpSrc},
+	{0177770, 0075000, "FADD", fisR},
+	{0177770, 0075010, "FSUB", fisR},
+	{0177770, 0075020, "FMUL", fisR},
+	{0177770, 0075030, "FDIV", fisR},
+}
+
+func extop(ins uint16) *extended {
+	for i := range extops {
+		if ins&extops[i].mask == extops[i].match {
+			return &extops[i]
 		}
 	}
-	panic(fmt.Sprintf("disasm: cannot disassemble instruction %06o at %06o", ins, a))
-	return "???"
+	return nil
+}
 
-found:
-	msg := l.msg
-	if l.b && (ins&0100000 == 0100000) {
-		msg += "B"
+func (s *disasm) extended(e *extended) string {
+	ins := s.in.Words[0]
+	ac := fmt.Sprintf("FR%d", (ins>>6)&3)
+	// fp is a floating point operand, mode 0 names an accumulator.
+	fp := func(mode uint16) string {
+		if mode&070 == 0 {
+			return fmt.Sprintf("FR%d", mode&7)
+		}
+		return s.operand(mode)
 	}
-	s := (ins & 07700) >> 6
-	d := ins & 077
-	o := byte(ins & 0377)
-	switch l.flag {
-	case flagS | flagD:
-		msg += " " + c.disasmaddr(s, a) + ","
-		fallthrough
-	case flagD:
-		msg += " " + c.disasmaddr(d, a)
-	case flagR | flagO:
-		msg += " " + rs[(ins&0700)>>6] + ","
-		o &= 077
-		fallthrough
-	case flagO:
-		if o&0x80 == 0x80 {
-			msg += fmt.Sprintf(" -%#o", (2 * ((0xFF ^ o) + 1)))
-		} else {
-			msg += fmt.Sprintf(" +%#o", (2 * o))
+	switch e.form {
+	case fpSrc:
+		return e.name + " " + s.operand(ins&077)
+	case fpFdst:
+		return e.name + " " + fp(ins&077)
+	case fpFsrcA:
+		return e.name + " " + fp(ins&077) + ", " + ac
+	case fpAFdst:
+		return e.name + " " + ac + ", " + fp(ins&077)
+	case fpADst:
+		return e.name + " " + ac + ", " + s.operand(ins&077)
+	case fpSrcA:
+		return e.name + " " + s.operand(ins&077) + ", " + ac
+	case fisR:
+		return e.name + " " + rs[ins&7]
+	}
+	return e.name
+}
+
+// VirtualMemory returns a function which reads kernel or user virtual
+// memory through the current mapping, for a Disassembler. Reading has no
+// side effects, so addresses which are not mapped, or which are not
+// memory, cannot be read.
+func (k *cpu) VirtualMemory(user bool) func(addr uint32) (uint16, bool) {
+	return func(addr uint32) (uint16, bool) {
+		if addr > 0177777 {
+			return 0, false
 		}
-	case flagR | flagD:
-		msg += " " + rs[(ins&0700)>>6] + ", " + c.disasmaddr(d, a)
-	case flagR:
-		msg += " " + rs[ins&7]
+		a, ok := k.mmu.peek(uint16(addr), user)
+		if !ok || a >= MEMSIZE {
+			return 0, false
+		}
+		return k.unibus.Memory[a>>1], true
+	}
+}
+
+// PhysicalMemory returns a function which reads physical memory, for a
+// Disassembler.
+func (k *cpu) PhysicalMemory() func(addr uint32) (uint16, bool) {
+	return func(addr uint32) (uint16, bool) {
+		if addr >= MEMSIZE {
+			return 0, false
+		}
+		return k.unibus.Memory[addr>>1], true
 	}
-	return msg
 }
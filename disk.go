@@ -0,0 +1,208 @@
+package pdp11
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
+
+// geometry is the layout of a disk pack.
+type geometry struct {
+	name                      string
+	cylinders, heads, sectors int
+	sectorSize                int // bytes
+}
+
+// size returns the capacity of the pack, in bytes.
+func (g geometry) size() int { return g.cylinders * g.heads * g.sectors * g.sectorSize }
+
+var (
+	rk05Geometry = geometry{"RK05", 203, 2, 12, 512}
+	rl01Geometry = geometry{"RL01", 256, 2, 40, 256}
+	rl02Geometry = geometry{"RL02", 512, 2, 40, 256}
+	rp04Geometry = geometry{"RP04", 411, 19, 22, 512}
+	rp06Geometry = geometry{"RP06", 815, 19, 22, 512}
+	rx01Geometry = geometry{"RX01", 77, 1, 26, 128}
+)
+
+// footerSize is the size of the footer SIMH 4 appends to the disk images
+// it creates, which begins with the signature "simh".
+const footerSize = 512
+
+// disk is a disk pack image in the format used by SIMH: the sectors in
+// order of cylinder, head and sector, optionally followed by a SIMH
+// footer. An image may be shorter than the pack, as SIMH creates them
+// empty and extends them as they are written; the missing sectors read
+// as zeros.
+//
+// The image is read through r. If r is not also an io.WriterAt, it is
+// copied into memory when it is first written.
+type disk struct {
+	geometry
+	r      io.ReaderAt
+	w      io.WriterAt
+	size   int64  // of the image, without the footer
+	footer []byte // kept as read
+}
+
+// checkDrive returns an error if drive is not one of the n drives a
+// controller has.
+func checkDrive(drive, n int) error {
+	if drive < 0 || drive >= n {
+		return fmt.Errorf("no drive %d, the controller has %d", drive, n)
+	}
+	return nil
+}
+
+// newDisk returns a disk holding the image of size bytes in r, which may
+// be compressed.
+func newDisk(g geometry, r io.ReaderAt, size int64) (*disk, error) {
+	r, size, err := decompress(r, size)
+	if err != nil {
+		return nil, err
+	}
+	d := &disk{geometry: g, r: r, size: size}
+	d.w, _ = r.(io.WriterAt)
+	if n := size - footerSize; n >= 0 {
+		footer := make([]byte, footerSize)
+		if _, err := r.ReadAt(footer, n); err != nil {
+			return nil, err
+		}
+		if string(footer[:4]) == "simh" {
+			d.size, d.footer = n, footer
+		}
+	}
+	if d.size > int64(g.size()) {
+		return nil, fmt.Errorf("%d byte image is too large for an %s of %d bytes", d.size, g.name, g.size())
+	}
+	return d, nil
+}
+
+// decompress returns the image held in r, decompressing it into memory
+// if it is compressed with gzip or zstd.
+func decompress(r io.ReaderAt, size int64) (io.ReaderAt, int64, error) {
+	var magic [4]byte
+	if size < int64(len(magic)) {
+		return r, size, nil
+	}
+	if _, err := r.ReadAt(magic[:], 0); err != nil {
+		return nil, 0, err
+	}
+	switch {
+	case magic[0] == 0x1f && magic[1] == 0x8b:
+		zr, err := gzip.NewReader(io.NewSectionReader(r, 0, size))
+		if err != nil {
+			return nil, 0, err
+		}
+		buf, err := ioutil.ReadAll(zr)
+		if err != nil {
+			return nil, 0, fmt.Errorf("gzip: %v", err)
+		}
+		m := memImage(buf)
+		return &m, int64(len(buf)), nil
+	case string(magic[:]) == "\x28\xb5\x2f\xfd":
+		src := make([]byte, size)
+		if _, err := r.ReadAt(src, 0); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		buf, err := zstdDecode(src)
+		if err != nil {
+			return nil, 0, err
+		}
+		m := memImage(buf)
+		return &m, int64(len(buf)), nil
+	}
+	return r, size, nil
+}
+
+// ReadAt fills b from byte offset off of the pack.
+func (d *disk) ReadAt(b []byte, off int64) (int, error) {
+	n := 0
+	if off < d.size {
+		m := b
+		if int64(len(m)) > d.size-off {
+			m = m[:d.size-off]
+		}
+		var err error
+		if n, err = d.r.ReadAt(m, off); err != nil && !(err == io.EOF && n == len(m)) {
+			return n, err
+		}
+	}
+	for i := range b[n:] {
+		b[n+i] = 0
+	}
+	return len(b), nil
+}
+
+// WriteAt writes b at byte offset off of the pack, extending the image
+// if necessary.
+func (d *disk) WriteAt(b []byte, off int64) (int, error) {
+	if d.w == nil {
+		buf := make([]byte, d.size)
+		if _, err := d.ReadAt(buf, 0); err != nil {
+			return 0, err
+		}
+		m := memImage(buf)
+		d.r, d.w = &m, &m
+	}
+	if gap := off - d.size; gap > 0 && d.footer != nil {
+		// the old footer would read as data.
+		if gap > footerSize {
+			gap = footerSize
+		}
+		if _, err := d.w.WriteAt(make([]byte, gap), d.size); err != nil {
+			return 0, err
+		}
+	}
+	n, err := d.w.WriteAt(b, off)
+	if err != nil {
+		return n, err
+	}
+	if end := off + int64(n); end > d.size {
+		d.size = end
+		if d.footer != nil {
+			// keep the footer at the end of the image.
+			if _, err := d.w.WriteAt(d.footer, end); err != nil {
+				return n, err
+			}
+		}
+	}
+	return n, nil
+}
+
+// WriteTo writes the image, and its footer if it had one, to w.
+func (d *disk) WriteTo(w io.Writer) (int64, error) {
+	n, err := io.Copy(w, io.NewSectionReader(d, 0, d.size))
+	if err != nil || d.footer == nil {
+		return n, err
+	}
+	m, err := w.Write(d.footer)
+	return n + int64(m), err
+}
+
+// memImage is a disk image held in memory, which grows as it is written.
+type memImage []byte
+
+func (m *memImage) ReadAt(b []byte, off int64) (int, error) {
+	if off >= int64(len(*m)) {
+		return 0, io.EOF
+	}
+	n := copy(b, (*m)[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m *memImage) WriteAt(b []byte, off int64) (int, error) {
+	if end := off + int64(len(b)); end > int64(len(*m)) {
+		if end > int64(cap(*m)) {
+			buf := make([]byte, end, 2*end)
+			copy(buf, *m)
+			*m = buf
+		}
+		*m = (*m)[:end]
+	}
+	return copy((*m)[off:], b), nil
+}
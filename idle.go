@@ -0,0 +1,50 @@
+package pdp11
+
+import "time"
+
+// idle blocks while the processor is waiting for an interrupt and
+// nothing can happen until the next line clock tick, KW11-P interrupt
+// or console input, then advances the line clock by the time spent
+// blocked. This stops an idle guest from spinning a host core. With the
+// virtual clock and no speed set there is no wall time to wait for, as
+// the line clock skips ahead to its next tick instead.
+func (p *PDP1140) idle() {
+	if !p.cpu.waiting || p.rk.running || p.rl.running || p.rp.running || p.tm.running || p.cons.TPS&0x80 == 0 {
+		return
+	}
+	if p.clock.Source == VirtualClock && p.speed <= 0 {
+		return
+	}
+	d := p.clock.untilTick()
+	if t, ok := p.kwp.untilInterrupt(); ok && t < d {
+		d = t
+	}
+	scale := 1.0
+	if p.clock.Source == VirtualClock {
+		// simulated time passes speed times faster than wall time.
+		scale = p.speed
+	}
+	wait := time.Duration(float64(d) / scale)
+	if wait <= 0 {
+		return
+	}
+	p.stats.publish()
+
+	var input chan uint8
+	if p.cons.ready {
+		input = p.cons.Input
+	}
+	start := time.Now()
+	t := time.NewTimer(wait)
+	select {
+	case v, ok := <-input:
+		if ok {
+			p.cons.addchar(int(v))
+		} else {
+			<-t.C
+		}
+	case <-t.C:
+	}
+	t.Stop()
+	p.clock.advance(time.Duration(float64(time.Since(start)) * scale))
+}
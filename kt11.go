@@ -8,6 +8,17 @@ type KT11 struct {
 	SR0, SR2 uint16
 	cpu      *cpu
 	pages    [16]page
+	gen      uint64 // incremented whenever the mapping changes
+	tlb      [16]tlbentry
+}
+
+// tlbentry caches the translation of a page which has been accessed
+// without fault. It is flushed whenever a PAR, PDR or SR0 is written.
+type tlbentry struct {
+	valid  bool
+	write  bool   // writable and W bit already set
+	lo, hi uint16 // range of accessible 64 byte blocks
+	base   uint18 // physical address of the page
 }
 
 type page struct {
@@ -38,6 +49,7 @@ func (m *KT11) read16(a uint18) uint16 {
 }
 
 func (m *KT11) write16(a uint18, v uint16) {
+	m.flush()
 	i := ((a & 017) >> 1)
 	if (a >= 0772300) && (a < 0772320) {
 		m.pages[i].pdr = v
@@ -58,15 +70,54 @@ func (m *KT11) write16(a uint18, v uint16) {
 	panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
 }
 
+// writeSR0 writes SR0, which may enable or disable the MMU.
+func (m *KT11) writeSR0(v uint16) {
+	m.SR0 = v
+	m.flush()
+}
+
+// flush discards all cached translations.
+func (m *KT11) flush() {
+	m.gen++
+	for i := range m.tlb {
+		m.tlb[i].valid = false
+	}
+}
+
 func (m *KT11) mmuEnabled() bool  { return m.SR0&1 == 1 }
 func (m *KT11) mmuDisabled() bool { return m.SR0&1 == 0 }
 
+// physical returns the physical address of a with memory management
+// disabled.
+func physical(a uint16) uint18 {
+	aa := uint18(a)
+	if aa >= 0170000 {
+		aa += 0600000
+	}
+	return aa
+}
+
+// peek returns the physical address of a without checking access or
+// setting the W bit, reporting whether a is mapped at all.
+func (m *KT11) peek(a uint16, user bool) (uint18, bool) {
+	if m.mmuDisabled() {
+		return physical(a), true
+	}
+	offset := a >> 13
+	if user {
+		offset += 8
+	}
+	p := &m.pages[offset]
+	block := (a >> 6) & 0177
+	if !p.read() || p.ed() && block < p.len() || !p.ed() && block > p.len() {
+		return 0, false
+	}
+	return ((uint18(block) + uint18(p.addr())) << 6) + uint18(a&077), true
+}
+
 func (m *KT11) decode(a uint16, w, user bool) (addr uint18) {
 	if m.mmuDisabled() {
-		aa := uint18(a)
-		if aa >= 0170000 {
-			aa += 0600000
-		}
+		aa := physical(a)
 		if DEBUG_MMU {
 			fmt.Printf("decode: fast %06o -> %06o\n", a, aa)
 		}
@@ -76,7 +127,21 @@ func (m *KT11) decode(a uint16, w, user bool) (addr uint18) {
 	if user {
 		offset += 8
 	}
-	p := m.pages[offset]
+	e := &m.tlb[offset]
+	if block := (a >> 6) & 0177; e.valid && (e.write || !w) && block >= e.lo && block <= e.hi {
+		return e.base + uint18(a&017777)
+	}
+	return m.translate(a, w, user)
+}
+
+// translate maps virtual address a through the page registers, checking
+// access and length, and caches the translation.
+func (m *KT11) translate(a uint16, w, user bool) (addr uint18) {
+	offset := a >> 13
+	if user {
+		offset += 8
+	}
+	p := &m.pages[offset]
 	if w && !p.write() {
 		m.SR0 = (1 << 13) | 1
 		m.SR0 |= a >> 12 & ^uint16(1)
@@ -84,6 +149,7 @@ func (m *KT11) decode(a uint16, w, user bool) (addr uint18) {
 			m.SR0 |= (1 << 5) | (1 << 6)
 		}
 		m.SR2 = m.cpu.pc
+		m.cpu.stats.faults++
 		panic(trap{intFAULT, fmt.Sprintf("write to read-only page %06o", a)})
 	}
 	if !p.read() {
@@ -93,6 +159,7 @@ func (m *KT11) decode(a uint16, w, user bool) (addr uint18) {
 			m.SR0 |= (1 << 5) | (1 << 6)
 		}
 		m.SR2 = m.cpu.pc
+		m.cpu.stats.faults++
 		panic(trap{intFAULT, fmt.Sprintf("read from no-access page %06o", a)})
 	}
 	block := (a >> 6) & 0177
@@ -105,11 +172,19 @@ func (m *KT11) decode(a uint16, w, user bool) (addr uint18) {
 			m.SR0 |= (1 << 5) | (1 << 6)
 		}
 		m.SR2 = m.cpu.pc
+		m.cpu.stats.faults++
 		panic(trap{intFAULT, fmt.Sprintf("page length exceeded, address %06o (block %03o) is beyond %03o", a, block, p.len())})
 	}
 	if w {
 		p.pdr |= 1 << 6
 	}
+	e := &m.tlb[offset]
+	*e = tlbentry{valid: true, write: p.write() && p.pdr&(1<<6) != 0, base: uint18(p.addr()) << 6}
+	if p.ed() {
+		e.lo, e.hi = p.len(), 0177
+	} else {
+		e.lo, e.hi = 0, p.len()
+	}
 	aa := ((uint18(block) + uint18(p.addr())) << 6) + disp
 	if DEBUG_MMU {
 		fmt.Printf("decode: slow %06o -> %06o\n", a, aa)
@@ -0,0 +1,147 @@
+package pdp11
+
+import "time"
+
+// ClockSource selects the time base which drives the KW11-L line clock.
+type ClockSource int
+
+const (
+	// InstrClock ticks once every 40000 instructions, regardless of
+	// the line frequency. The guest's notion of time depends on the
+	// host speed and the instruction mix.
+	InstrClock ClockSource = iota
+
+	// VirtualClock ticks at the line frequency measured against the
+	// simulated execution time of each instruction, as given by the
+	// 11/40 timing model. Runs are reproducible.
+	VirtualClock
+
+	// WallClock ticks at the line frequency measured against the
+	// host's wall clock.
+	WallClock
+)
+
+func (s ClockSource) String() string {
+	switch s {
+	case InstrClock:
+		return "instr"
+	case VirtualClock:
+		return "virtual"
+	case WallClock:
+		return "wall"
+	default:
+		return "unknown"
+	}
+}
+
+// instrTicks is the number of instructions between ticks of an InstrClock.
+const instrTicks = 40000
+
+// KW11L is the KW11-L line frequency clock.
+type KW11L struct {
+	LKS    uint16
+	Source ClockSource
+	Hz     int // line frequency, 50 or 60
+
+	count int           // instructions since the last tick or poll
+	ticks int64         // ticks since reset
+	next  time.Duration // time of the next tick
+	start time.Time     // wall clock epoch for WallClock
+
+	unibus *unibus
+}
+
+func (k *KW11L) period() time.Duration { return time.Second / time.Duration(k.Hz) }
+
+func (k *KW11L) reset() {
+	k.LKS = 1 << 7
+	k.count = 0
+	k.ticks = 0
+	k.next = k.period()
+	k.start = time.Now()
+}
+
+// Step advances the clock by one instruction, raising a tick if one is due.
+func (k *KW11L) Step() {
+	switch k.Source {
+	case InstrClock:
+		k.count++
+		if k.count < instrTicks {
+			return
+		}
+		k.count = 0
+	case VirtualClock:
+		cpu := k.unibus.cpu
+		if cpu.waiting && cpu.vtime < k.next {
+			// nothing happens until the next tick, skip ahead to it.
+			cpu.vtime = k.next
+		}
+		if cpu.vtime < k.next {
+			return
+		}
+		k.next += k.period()
+	case WallClock:
+		// reading the host clock is expensive, only poll it
+		// every so often unless the processor is idle.
+		k.count++
+		if k.count&0377 != 0 && !k.unibus.cpu.waiting {
+			return
+		}
+		now := time.Since(k.start)
+		if now < k.next {
+			return
+		}
+		k.next += k.period()
+		if now-k.next > time.Second {
+			// we've fallen too far behind, drop the missed ticks.
+			k.next = now + k.period()
+		}
+	}
+	k.tick()
+}
+
+// now returns the time since reset according to the clock source.
+func (k *KW11L) now() time.Duration {
+	switch k.Source {
+	case VirtualClock:
+		return k.unibus.cpu.vtime
+	case WallClock:
+		return time.Since(k.start)
+	default:
+		return time.Duration(k.ticks)*k.period() + time.Duration(k.count)*k.period()/instrTicks
+	}
+}
+
+// untilTick returns the time until the next tick according to the
+// clock source.
+func (k *KW11L) untilTick() time.Duration {
+	if k.Source == InstrClock {
+		return time.Duration(instrTicks-k.count) * k.period() / instrTicks
+	}
+	return k.next - k.now()
+}
+
+// advance moves the clock forward by d, no further than the next tick,
+// while the processor is idle.
+func (k *KW11L) advance(d time.Duration) {
+	if u := k.untilTick(); d >= u {
+		d = u
+	}
+	switch k.Source {
+	case InstrClock:
+		k.count += int(d * instrTicks / k.period())
+		if k.count >= instrTicks {
+			k.count = instrTicks - 1
+		}
+	case VirtualClock:
+		k.unibus.cpu.vtime += d
+	}
+}
+
+func (k *KW11L) tick() {
+	k.ticks++
+	k.LKS |= 1 << 7
+	if k.LKS&(1<<6) != 0 {
+		k.unibus.cpu.interrupt(intCLOCK, 6)
+	}
+}
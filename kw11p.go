@@ -0,0 +1,152 @@
+package pdp11
+
+import (
+	"fmt"
+	"time"
+)
+
+// KW11-P control and status register bits
+const (
+	KWPRUN  = 1 << 0
+	KWPRATE = 3 << 1
+	KWPMODE = 1 << 3 // repeat interrupt
+	KWPUPDN = 1 << 4 // count up
+	KWPFIX  = 1 << 5 // maintenance single count
+	KWPIE   = 1 << 6
+	KWPDONE = 1 << 7
+	KWPERR  = 1 << 15
+)
+
+// KW11P is the KW11-P programmable real time clock.
+type KW11P struct {
+	CSR, CSB, CTR uint16
+
+	last  time.Duration // time of the last count
+	ticks int64         // line clock ticks at the last count
+
+	unibus *unibus
+}
+
+// interval returns the time between counts at the selected rate.
+func (k *KW11P) interval() time.Duration {
+	switch k.CSR & KWPRATE {
+	case 0 << 1:
+		return 10 * time.Microsecond // 100kHz
+	default:
+		return 100 * time.Microsecond // 10kHz
+	}
+}
+
+func (k *KW11P) reset() {
+	k.CSR = 0
+	k.CSB = 0
+	k.CTR = 0
+}
+
+// start synchronises the counter with the time base when the clock
+// is started or its rate changes.
+func (k *KW11P) start() {
+	k.last = k.unibus.clock.now()
+	k.ticks = k.unibus.clock.ticks
+}
+
+// Step advances the counter by however many counts are due at the
+// selected rate.
+func (k *KW11P) Step() {
+	if k.CSR&KWPRUN == 0 {
+		return
+	}
+	var n int64
+	switch k.CSR & KWPRATE {
+	case 0 << 1, 1 << 1:
+		iv := k.interval()
+		n = int64((k.unibus.clock.now() - k.last) / iv)
+		k.last += time.Duration(n) * iv
+	case 2 << 1:
+		// line frequency, count on each KW11-L tick.
+		n = k.unibus.clock.ticks - k.ticks
+		k.ticks = k.unibus.clock.ticks
+	case 3 << 1:
+		// no external input is connected.
+		return
+	}
+	for ; n > 0 && k.CSR&KWPRUN != 0; n-- {
+		k.count()
+	}
+}
+
+// untilInterrupt returns the time until the counter next interrupts,
+// if it is running from its internal rates with interrupts enabled.
+func (k *KW11P) untilInterrupt() (time.Duration, bool) {
+	if k.CSR&KWPRUN == 0 || k.CSR&KWPIE == 0 || k.CSR&KWPRATE > 1<<1 {
+		return 0, false
+	}
+	n := time.Duration(k.CTR)
+	if k.CSR&KWPUPDN != 0 {
+		n = 1<<16 - n
+	}
+	return k.last + n*k.interval() - k.unibus.clock.now(), true
+}
+
+// count increments or decrements the counter once, interrupting when
+// it overflows or reaches zero.
+func (k *KW11P) count() {
+	if k.CSR&KWPUPDN != 0 {
+		k.CTR++
+	} else {
+		k.CTR--
+	}
+	if k.CTR != 0 {
+		return
+	}
+	if k.CSR&KWPDONE != 0 {
+		k.CSR |= KWPERR
+	}
+	k.CSR |= KWPDONE
+	if k.CSR&KWPMODE != 0 {
+		k.CTR = k.CSB
+	} else {
+		k.CSR &^= KWPRUN
+	}
+	if k.CSR&KWPIE != 0 {
+		k.unibus.cpu.interrupt(intKWP, 6)
+	}
+}
+
+func (k *KW11P) read16(a uint18) uint16 {
+	switch a {
+	case 0772540:
+		v := k.CSR
+		k.CSR &^= KWPDONE | KWPERR
+		return v
+	case 0772542:
+		return 0 // count set buffer is write only
+	case 0772544:
+		k.Step()
+		return k.CTR
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+func (k *KW11P) write16(a uint18, v uint16) {
+	switch a {
+	case 0772540:
+		const BITS = KWPRUN | KWPRATE | KWPMODE | KWPUPDN | KWPFIX | KWPIE
+		was := k.CSR
+		k.CSR = (k.CSR &^ BITS) | (v & BITS &^ KWPFIX)
+		if k.CSR&KWPRUN != 0 && (was&KWPRUN == 0 || was&KWPRATE != k.CSR&KWPRATE) {
+			k.start()
+		}
+		if v&KWPFIX != 0 && k.CSR&KWPRUN == 0 {
+			k.count()
+		}
+	case 0772542:
+		k.CSB = v
+		k.CTR = v
+	case 0772544:
+		// counter is read only
+	default:
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+}
@@ -0,0 +1,76 @@
+package pdp11
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+)
+
+// LoadAbsolute loads a paper tape image in the DEC absolute loader
+// format, as used for .LDA and .BIN files, into memory and sets the PC to
+// its transfer address, returning the transfer address. If the transfer
+// address is odd the tape is not self starting and the PC is unchanged.
+//
+// A tape is a sequence of blocks, each preceded by any number of null
+// leader bytes. A block is the bytes 001 000, a word holding the length
+// of the block excluding the checksum, a word holding the load address,
+// the data, and a checksum byte which makes the sum of the bytes of the
+// block zero. A block with no data holds the transfer address, and ends
+// the tape.
+func (p *PDP1140) LoadAbsolute(r io.Reader) (uint16, error) {
+	br := bufio.NewReader(r)
+	for {
+		// skip leader.
+		b, err := br.ReadByte()
+		for err == nil && b == 0 {
+			b, err = br.ReadByte()
+		}
+		if err == io.EOF {
+			return 0, fmt.Errorf("lda: missing transfer block")
+		}
+		if err != nil {
+			return 0, err
+		}
+		var hdr [5]byte
+		if _, err := io.ReadFull(br, hdr[:]); err != nil {
+			return 0, fmt.Errorf("lda: short block header: %v", err)
+		}
+		if b != 1 || hdr[0] != 0 {
+			return 0, fmt.Errorf("lda: bad block header %03o %03o", b, hdr[0])
+		}
+		count := int(hdr[1]) | int(hdr[2])<<8
+		addr := uint16(hdr[3]) | uint16(hdr[4])<<8
+		if count < 6 {
+			return 0, fmt.Errorf("lda: bad block length %d at %06o", count, addr)
+		}
+		data := make([]byte, count-6+1) // including checksum
+		if _, err := io.ReadFull(br, data); err != nil {
+			return 0, fmt.Errorf("lda: short block at %06o: %v", addr, err)
+		}
+		sum := b
+		for _, c := range hdr {
+			sum += c
+		}
+		for _, c := range data {
+			sum += c
+		}
+		if sum != 0 {
+			return 0, fmt.Errorf("lda: checksum error in block at %06o", addr)
+		}
+		data = data[:len(data)-1]
+		if len(data) == 0 {
+			if addr&1 == 0 {
+				p.SetPC(addr)
+			}
+			return addr, nil
+		}
+		for i := range data {
+			if physical(addr+uint16(i)) >= MEMSIZE {
+				return 0, fmt.Errorf("lda: block at %06o extends beyond memory to %06o", addr, addr+uint16(i))
+			}
+		}
+		for i, c := range data {
+			p.unibus.write8(physical(addr+uint16(i)), uint16(c))
+		}
+	}
+}
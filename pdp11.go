@@ -1,6 +1,10 @@
 package pdp11
 
-import "fmt"
+import (
+	"fmt"
+	"io"
+	"sync/atomic"
+)
 
 var BOOTRK05 = map[uint18]uint16{
 	002000: 0042113,         /* "KD" */
@@ -32,6 +36,9 @@ var BOOTRK05 = map[uint18]uint16{
 type PDP1140 struct {
 	unibus
 	cpu
+	throttle
+
+	stopped int32 // set by Stop, read atomically
 }
 
 func (p *PDP1140) Step() {
@@ -52,7 +59,8 @@ func (p *PDP1140) Step() {
 type intr struct{ vec, pri int }
 
 func (p *PDP1140) step() {
-	if p.cpu.interrupts[0].vec > 0 && p.cpu.interrupts[0].pri >= ((int(p.cpu.PS)>>5)&7) {
+	p.stats.countstep()
+	if p.cpu.interrupts[0].vec > 0 && p.cpu.interrupts[0].pri > ((int(p.cpu.PS)>>5)&7) {
 		p.handleinterrupt(p.cpu.interrupts[0].vec)
 		for i := 0; i < len(p.cpu.interrupts)-1; i++ {
 			p.cpu.interrupts[i] = p.cpu.interrupts[i+1]
@@ -61,15 +69,15 @@ func (p *PDP1140) step() {
 		return
 	}
 	p.cpu.step()
-	clkcounter++
-	if clkcounter >= 40000 {
-		clkcounter = 0
-		p.LKS |= (1 << 7)
-		if p.LKS&(1<<6) != 0 {
-			p.cpu.interrupt(intCLOCK, 6)
-		}
+	if p.tod.timeaddr != 0 && p.cpu.curuser {
+		p.synctime()
 	}
+	p.clock.Step()
+	p.kwp.Step()
 	p.rk.Step()
+	p.rl.Step()
+	p.rp.Step()
+	p.tm.Step()
 	p.cons.Step()
 }
 
@@ -90,8 +98,10 @@ func (p *PDP1140) handleinterrupt(vec int) {
 		if p.cpu.prevuser {
 			p.cpu.PS |= (1 << 13) | (1 << 12)
 		}
-		waiting = false
+		p.cpu.wake()
 	}()
+	p.cpu.vtime += intrTime
+	p.cpu.stats.interrupts[vec>>2]++
 	prev := uint16(p.cpu.PS)
 	p.cpu.switchmode(false)
 	p.cpu.push(prev)
@@ -108,8 +118,9 @@ func (p *PDP1140) trapat(vec int, msg string) {
 		switch t := t.(type) {
 		case trap:
 			fmt.Println("red stack trap!")
-			p.Memory[0] = uint16(p.cpu.R[7])
-			p.Memory[1] = prev
+			// through the unibus, so the block cache sees the writes.
+			p.unibus.write16(0, uint16(p.cpu.R[7]))
+			p.unibus.write16(2, prev)
 			vec = 4
 			panic("fatal")
 		case nil:
@@ -122,23 +133,34 @@ func (p *PDP1140) trapat(vec int, msg string) {
 		if p.cpu.prevuser {
 			p.cpu.PS |= (1 << 13) | (1 << 12)
 		}
-		waiting = false
+		p.cpu.wake()
 	}()
 	if vec&1 == 1 {
 		panic("Thou darst calling trapat() with an odd vector number?")
 	}
+	p.cpu.vtime += trapTime
+	p.cpu.stats.traps[vec>>2]++
+	p.cpu.trapvec = vec
 	prev = uint16(p.cpu.PS)
 	p.cpu.switchmode(false)
 	p.cpu.push(prev)
 	p.cpu.push(uint16(p.cpu.R[7]))
 }
 
+// Run runs the machine until Stop is called.
 func (p *PDP1140) Run() {
-	for {
+	for !p.stopping() {
 		p.run()
 	}
 }
 
+// Stop makes Run return after the instruction in progress. Unlike the
+// machine's other methods it may be called while Run is running, from
+// another goroutine.
+func (p *PDP1140) Stop() { atomic.StoreInt32(&p.stopped, 1) }
+
+func (p *PDP1140) stopping() bool { return atomic.LoadInt32(&p.stopped) != 0 }
+
 func (p *PDP1140) run() {
 	defer func() {
 		t := recover()
@@ -151,13 +173,97 @@ func (p *PDP1140) run() {
 			panic(t)
 		}
 	}()
-	for {
+	for !p.stopping() {
 		p.step()
+		if p.cpu.waiting {
+			p.idle()
+		}
+		if p.speed > 0 {
+			p.pace()
+		}
 	}
 }
 
+// SetClock selects the time base and line frequency, in Hz, of the
+// KW11-L line clock. The frequency must be positive.
+func (p *PDP1140) SetClock(src ClockSource, hz int) error {
+	if hz <= 0 {
+		return fmt.Errorf("line clock frequency %d Hz is not positive", hz)
+	}
+	p.clock.Source = src
+	p.clock.Hz = hz
+	p.clock.reset()
+	return nil
+}
+
 func (p *PDP1140) Attach(unit int, name string) { p.unibus.rk.Attach(unit, name) }
 
+// AttachImage attaches the RK05 image of size bytes in img as unit, as
+// described by RK11.AttachImage. It allows images to be embedded in the
+// program, or held in memory, rather than read from a file. The guest's
+// writes go to img only if it is also an io.WriterAt, such as an
+// *os.File; otherwise they go to a copy in memory, which SaveDisk
+// writes out.
+func (p *PDP1140) AttachImage(unit int, img io.ReaderAt, size int64) error {
+	return p.unibus.rk.AttachImage(unit, img, size)
+}
+
+// SaveDisk writes the image of the RK05 pack in unit to w, so that the
+// changes made by the guest can be kept.
+func (p *PDP1140) SaveDisk(unit int, w io.Writer) error { return p.unibus.rk.Save(unit, w) }
+
+// DriveType is a model of disk drive.
+type DriveType int
+
+const (
+	DriveRK05 DriveType = iota
+	DriveRL01
+	DriveRL02
+	DriveRP04
+	DriveRP06
+	DriveRX01
+)
+
+// AttachDrive attaches the image of size bytes in img as unit of the
+// controller for drives of type t. Guest writes are made to img if it
+// is an io.WriterAt, otherwise to a copy in memory.
+func (p *PDP1140) AttachDrive(t DriveType, unit int, img io.ReaderAt, size int64) error {
+	switch t {
+	case DriveRK05:
+		return p.rk.AttachImage(unit, img, size)
+	case DriveRL01, DriveRL02:
+		return p.rl.Attach(unit, img, size, t == DriveRL02)
+	case DriveRP04, DriveRP06:
+		return p.rp.Attach(unit, img, size, t == DriveRP06)
+	case DriveRX01:
+		return p.rx.Attach(unit, img, size)
+	default:
+		return fmt.Errorf("unknown drive type %d", t)
+	}
+}
+
+// SaveDrive writes the image of the pack in unit of the controller for
+// drives of type t to w.
+func (p *PDP1140) SaveDrive(t DriveType, unit int, w io.Writer) error {
+	switch t {
+	case DriveRK05:
+		return p.rk.Save(unit, w)
+	case DriveRL01, DriveRL02:
+		return p.rl.Save(unit, w)
+	case DriveRP04, DriveRP06:
+		return p.rp.Save(unit, w)
+	case DriveRX01:
+		return p.rx.Save(unit, w)
+	default:
+		return fmt.Errorf("unknown drive type %d", t)
+	}
+}
+
+// AttachTape loads tape into unit of the TM11 tape controller. The
+// records written by the guest can be read from the tape's Bytes once
+// the machine is stopped.
+func (p *PDP1140) AttachTape(unit int, tape *Tape) error { return p.unibus.tm.Attach(unit, tape) }
+
 // LoadMemory takes a map of addresses and their values and applies that map to
 // core memory.
 func (p *PDP1140) LoadMemory(code map[uint18]uint16) {
@@ -172,7 +278,14 @@ func New() *PDP1140 {
 	pdp.unibus.cpu = &pdp.cpu
 	pdp.cpu.mmu.cpu = &pdp.cpu
 	pdp.unibus.rk.unibus = &pdp.unibus
+	pdp.unibus.rl.unibus = &pdp.unibus
+	pdp.unibus.rp.unibus = &pdp.unibus
+	pdp.unibus.tm.unibus = &pdp.unibus
+	pdp.unibus.rx.unibus = &pdp.unibus
 	pdp.unibus.cons.unibus = &pdp.unibus
+	pdp.unibus.clock.unibus = &pdp.unibus
+	pdp.unibus.clock.Hz = 60
+	pdp.unibus.kwp.unibus = &pdp.unibus
 	pdp.cpu.Reset()
 	return &pdp
 }
@@ -1,7 +1,23 @@
 package pdp11
 
-import "testing"
-import "time"
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"errors"
+	"io"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/davecheney/pdp11/asm"
+	"github.com/davecheney/pdp11/v6fs"
+)
 
 func TestXOR(t *testing.T) {
 	for _, tt := range []struct {
@@ -77,3 +93,1286 @@ func TestPDP(t *testing.T) {
 		}
 	}
 }
+
+func TestStop(t *testing.T) {
+	pdp := New()
+	pdp.LoadMemory(core{001000: 0000777}) // BR .
+	pdp.SetPC(001000)
+	time.AfterFunc(10*time.Millisecond, pdp.Stop)
+	pdp.Run()
+	if pdp.Cycles() == 0 {
+		t.Error("stopped before running")
+	}
+}
+
+func TestVirtualClock(t *testing.T) {
+	pdp := New()
+	if err := pdp.SetClock(VirtualClock, 0); err == nil {
+		t.Error("SetClock accepted 0 Hz")
+	}
+	pdp.SetClock(VirtualClock, 60)
+	pdp.LoadMemory(core{001000: 0000777}) // BR .
+	pdp.SetPC(001000)
+	pdp.clock.LKS = 0
+
+	// BR takes 1760ns, the first tick is due after 1/60th of a second.
+	const steps = (time.Second / 60) / (1760 * time.Nanosecond)
+	for i := 0; i < int(steps); i++ {
+		pdp.Step()
+	}
+	if pdp.clock.LKS&(1<<7) != 0 {
+		t.Fatalf("clock ticked after %d steps, %v", steps, pdp.vtime)
+	}
+	pdp.Step()
+	if pdp.clock.LKS&(1<<7) == 0 {
+		t.Fatalf("clock did not tick after %d steps, %v", steps+1, pdp.vtime)
+	}
+}
+
+func TestKW11P(t *testing.T) {
+	pdp := New()
+	pdp.SetClock(VirtualClock, 60)
+	pdp.LoadMemory(core{001000: 0000777}) // BR .
+	pdp.SetPC(001000)
+	pdp.unibus.write16(0772542, 5)      // CSB
+	pdp.unibus.write16(0772540, KWPRUN) // 100kHz, single, count down
+
+	// 5 counts at 100kHz take 50us, BR takes 1760ns.
+	for i := 0; i < 28; i++ {
+		pdp.Step()
+	}
+	if csr := pdp.kwp.CSR; csr&KWPDONE != 0 {
+		t.Fatalf("CSR: got %06o, DONE set early", csr)
+	}
+	pdp.Step()
+	if csr := pdp.unibus.read16(0772540); csr&(KWPDONE|KWPRUN) != KWPDONE {
+		t.Fatalf("CSR: got %06o, want DONE set and RUN clear", csr)
+	}
+	if csr := pdp.unibus.read16(0772540); csr&KWPDONE != 0 {
+		t.Fatalf("CSR: got %06o, want DONE cleared by read", csr)
+	}
+	if ctr := pdp.unibus.read16(0772544); ctr != 0 {
+		t.Fatalf("CTR: got %06o, want 0", ctr)
+	}
+}
+
+func TestSyncTime(t *testing.T) {
+	if testing.Short() {
+		t.Skip("skipping in -short mode")
+	}
+	pdp := New()
+	pdp.LoadMemory(BOOTRK05)
+	pdp.SetPC(002002)
+	pdp.Attach(0, "rk0")
+	if err := pdp.SyncTime("unix"); err != nil {
+		t.Fatal(err)
+	}
+	addr := pdp.tod.timeaddr
+	go func() {
+		for _, c := range "unix\n" {
+			pdp.cpu.Input <- uint8(c)
+		}
+	}()
+	for i := 0; i < N && pdp.tod.timeaddr != 0; i++ {
+		pdp.Step()
+	}
+	if pdp.tod.timeaddr != 0 {
+		t.Fatal("kernel did not enter user mode")
+	}
+	a := pdp.mmu.decode(addr, false, false)
+	got := int64(pdp.unibus.read16(a))<<16 | int64(pdp.unibus.read16(a+2))
+	if now := time.Now().Unix(); got < now-60 || got > now {
+		t.Fatalf("time: got %d, want %d", got, now)
+	}
+}
+
+func TestSetSpeed(t *testing.T) {
+	pdp := New()
+	pdp.LoadMemory(core{001000: 0000777}) // BR .
+	pdp.SetPC(001000)
+	pdp.SetSpeed(1)
+
+	// 20000 BR instructions take 35.2ms on a real 11/40.
+	start := time.Now()
+	for i := 0; i < 20000; i++ {
+		pdp.step()
+		pdp.pace()
+	}
+	if d := time.Since(start); d < 30*time.Millisecond {
+		t.Fatalf("20000 instructions took %v, want at least 30ms", d)
+	}
+}
+
+func TestProfile(t *testing.T) {
+	pdp := New()
+	pdp.LoadMemory(core{
+		001000: 012700, 001002: 10, // MOV #10, R0
+		001004: 005201, // loop: INC R1
+		001006: 077002, // SOB R0, loop
+		001010: 000777, // BR .
+	})
+	pdp.SetPC(001000)
+	pdp.StartProfile()
+	for i := 0; i < 1+2*10; i++ {
+		pdp.Step()
+	}
+	prof := pdp.StopProfile()
+	syms := Symtab{
+		{Name: "start", Type: SymText | SymExt, Value: 001000},
+		{Name: "loop", Type: SymText | SymExt, Value: 001004},
+	}
+	var buf bytes.Buffer
+	if err := prof.WriteFlat(&buf, syms, nil); err != nil {
+		t.Fatal(err)
+	}
+	// 10 INCs take 99 cycles each, 9 SOBs 236 taken and one 204 not taken.
+	want := regexp.MustCompile(`\s3318\s.*\s20\s.*kernel loop\n`)
+	if !want.Match(buf.Bytes()) {
+		t.Errorf("flat profile:\n%s\nwant match for %v", buf.String(), want)
+	}
+	buf.Reset()
+	if err := prof.WritePprof(&buf, syms, nil); err != nil {
+		t.Fatal(err)
+	}
+	zr, err := gzip.NewReader(&buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pb, err := ioutil.ReadAll(zr)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// decode the profile.proto message, checking the sample types and
+	// that the samples' locations resolve to the functions named by
+	// the symbol table.
+	msg := protoFields(t, pb)
+	var strtab []string
+	for _, f := range msg[6] {
+		strtab = append(strtab, string(f.b))
+	}
+	str := func(i uint64) string {
+		if i >= uint64(len(strtab)) {
+			t.Fatalf("string %d out of range", i)
+		}
+		return strtab[i]
+	}
+	var types []string
+	for _, f := range msg[1] {
+		vt := protoFields(t, f.b)
+		types = append(types, str(protoInt(vt, 1))+"/"+str(protoInt(vt, 2)))
+	}
+	if want := []string{"instructions/count", "cpu/nanoseconds"}; !reflect.DeepEqual(types, want) {
+		t.Errorf("sample types %q, want %q", types, want)
+	}
+	funcs := make(map[uint64]string)
+	for _, f := range msg[5] {
+		fn := protoFields(t, f.b)
+		funcs[protoInt(fn, 1)] = str(protoInt(fn, 2))
+	}
+	locs := make(map[uint64]string)
+	for _, f := range msg[4] {
+		loc := protoFields(t, f.b)
+		for _, line := range loc[4] {
+			locs[protoInt(loc, 1)] = funcs[protoInt(protoFields(t, line.b), 1)]
+		}
+	}
+	if len(msg[2]) == 0 {
+		t.Fatal("no samples")
+	}
+	instrs := make(map[string]uint64)
+	for _, f := range msg[2] {
+		sample := protoFields(t, f.b)
+		ids, values := protoPacked(t, sample[1]), protoPacked(t, sample[2])
+		if len(ids) != 1 || len(values) != 2 {
+			t.Fatalf("sample has locations %v, values %v", ids, values)
+		}
+		instrs[locs[ids[0]]] += values[0]
+	}
+	if want := map[string]uint64{"start": 1, "loop": 20}; !reflect.DeepEqual(instrs, want) {
+		t.Errorf("instructions by function %v, want %v", instrs, want)
+	}
+}
+
+// protoField is a field of a protocol buffer message, with either a
+// varint value or bytes.
+type protoField struct {
+	v uint64
+	b []byte
+}
+
+// protoFields decodes the fields of a protocol buffer message, by field
+// number.
+func protoFields(t *testing.T, b []byte) map[int][]protoField {
+	t.Helper()
+	fields := make(map[int][]protoField)
+	for len(b) > 0 {
+		tag, n := binary.Uvarint(b)
+		if n <= 0 {
+			t.Fatal("bad protocol buffer tag")
+		}
+		b = b[n:]
+		var f protoField
+		switch tag & 7 {
+		case 0:
+			f.v, n = binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad protocol buffer varint")
+			}
+			b = b[n:]
+		case 2:
+			l, n := binary.Uvarint(b)
+			if n <= 0 || l > uint64(len(b[n:])) {
+				t.Fatal("bad protocol buffer length")
+			}
+			f.b, b = b[n:n+int(l)], b[n+int(l):]
+		default:
+			t.Fatalf("unexpected protocol buffer wire type %d", tag&7)
+		}
+		fields[int(tag>>3)] = append(fields[int(tag>>3)], f)
+	}
+	return fields
+}
+
+// protoInt returns the varint field n, or 0 if it is absent.
+func protoInt(fields map[int][]protoField, n int) uint64 {
+	if f := fields[n]; len(f) > 0 {
+		return f[len(f)-1].v
+	}
+	return 0
+}
+
+// protoPacked decodes packed repeated varint fields.
+func protoPacked(t *testing.T, fields []protoField) []uint64 {
+	t.Helper()
+	var xs []uint64
+	for _, f := range fields {
+		for b := f.b; len(b) > 0; {
+			x, n := binary.Uvarint(b)
+			if n <= 0 {
+				t.Fatal("bad packed varint")
+			}
+			xs, b = append(xs, x), b[n:]
+		}
+	}
+	return xs
+}
+
+func TestStats(t *testing.T) {
+	pdp := boot("unix\n")
+	for i := 0; i < 2000000; i++ {
+		pdp.Step()
+	}
+	s := pdp.Stats()
+	if s.Instructions == 0 || s.Instructions != s.KernelInstructions+s.UserInstructions {
+		t.Errorf("Instructions: %d, kernel %d, user %d", s.Instructions, s.KernelInstructions, s.UserInstructions)
+	}
+	if s.UserInstructions == 0 {
+		t.Error("no user mode instructions executed")
+	}
+	if s.Interrupts[intCLOCK] == 0 || s.Interrupts[intRK] == 0 {
+		t.Errorf("Interrupts: %o, want clock and RK11 interrupts", s.Interrupts)
+	}
+	if s.Traps[034] == 0 {
+		t.Errorf("Traps: %o, want system calls", s.Traps)
+	}
+	if s.SectorsRead == 0 {
+		t.Error("no sectors read")
+	}
+	if s.ConsoleIn != 5 || s.ConsoleOut == 0 {
+		t.Errorf("console: %d in, %d out, want 5 in", s.ConsoleIn, s.ConsoleOut)
+	}
+}
+
+func TestIdle(t *testing.T) {
+	pdp := New()
+	pdp.LoadMemory(core{
+		000100: 001010, 000102: 0, // clock vector
+		001000: 000001, // WAIT
+		001002: 000776, // BR .-2
+		001010: 000002, // RTI
+	})
+	pdp.SetPC(001000)
+	pdp.R[6] = 001000
+	pdp.clock.LKS = 1 << 6
+	pdp.Step()
+	if !pdp.waiting {
+		t.Fatal("not waiting after WAIT")
+	}
+
+	// nothing to do until the next tick, 1/60th of a second away.
+	start := time.Now()
+	pdp.idle()
+	if d := time.Since(start); d < 10*time.Millisecond || d > time.Second {
+		t.Fatalf("idle blocked for %v, want about 16ms", d)
+	}
+	pdp.Step()
+	pdp.Step()
+	if pdp.waiting {
+		t.Fatal("clock tick did not end WAIT")
+	}
+
+	// console input ends idle immediately.
+	pdp.Step() // RTI
+	pdp.Step() // BR
+	pdp.Step() // WAIT
+	if !pdp.waiting {
+		t.Fatal("not waiting after WAIT")
+	}
+	pdp.cons.Input = make(chan uint8, 1)
+	pdp.cons.Input <- 'x'
+	start = time.Now()
+	pdp.idle()
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Fatalf("idle blocked for %v with console input pending", d)
+	}
+	if pdp.cons.TKS&0x80 == 0 {
+		t.Fatal("console input not received")
+	}
+
+	// the virtual clock skips ahead to the tick rather than waiting
+	// for it, unless a speed is set.
+	pdp = New()
+	if err := pdp.SetClock(VirtualClock, 60); err != nil {
+		t.Fatal(err)
+	}
+	pdp.LoadMemory(core{001000: 000001}) // WAIT
+	pdp.SetPC(001000)
+	pdp.clock.LKS = 1 << 6
+	pdp.Step()
+	start = time.Now()
+	pdp.idle()
+	if d := time.Since(start); d > 10*time.Millisecond {
+		t.Fatalf("idle blocked for %v with the virtual clock", d)
+	}
+}
+
+const bootrk05 = `
+; RK05 bootstrap
+RKDA	= 177412
+READ	= 4
+GO	= 1
+	. = 2000
+START:	"KD
+	MOV	#START, SP
+	MOV	#0, R0		; unit number
+	MOV	R0, R3
+	SWAB	R3
+	ASL	R3
+	ASL	R3
+	ASL	R3
+	ASL	R3
+	ASL	R3
+	MOV	#RKDA, R1	; csr
+	MOV	R3, (R1)	; load da
+	CLR	-(R1)		; clear ba
+	MOV	#-256.*2, -(R1)	; load wc
+	MOV	#READ+GO, -(R1)	; read & go
+	CLR	R2
+	CLR	R3
+	MOV	#START+20, R4
+	CLR	R5
+1$:	TSTB	(R1)
+	BPL	1$
+	CLRB	(R1)
+	CLR	PC
+	.END
+`
+
+func TestAssemble(t *testing.T) {
+	core, err := Assemble(bootrk05)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(core) != len(BOOTRK05) {
+		t.Errorf("assembled %d words, want %d", len(core), len(BOOTRK05))
+	}
+	for a, want := range BOOTRK05 {
+		if got := core[a]; got != want {
+			t.Errorf("%06o: got %06o, want %06o", a, got, want)
+		}
+	}
+}
+
+// aout assembles src into an a.out file.
+func aout(t *testing.T, src string) []byte {
+	prog, err := asm.Assemble("test.s", []byte(src))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	if err := prog.WriteAOut(&buf); err != nil {
+		t.Fatal(err)
+	}
+	return buf.Bytes()
+}
+
+func TestLoadAOut(t *testing.T) {
+	// copy standard input to standard output, writing through indir.
+	const cat = `
+start:	clr	r0
+	sys	read
+	buf
+	100
+	mov	r0, 9f+4
+	mov	$1, r0
+	sys	0
+	9f
+	mov	$3, r0
+	sys	exit
+9:	sys	write
+	buf
+	0
+buf:	.blkb	100
+	.end	start
+`
+	pdp := New()
+	if _, err := pdp.LoadAOut(bytes.NewReader(aout(t, cat))); err != nil {
+		t.Fatal(err)
+	}
+	var stdout bytes.Buffer
+	pdp.EmulateSyscalls(&Syscalls{
+		Stdin:  bytes.NewBufferString("hello, world\n"),
+		Stdout: &stdout,
+	})
+	if status, err := pdp.RunUntilExit(); err != nil || status != 3 {
+		t.Errorf("exit status %d, %v, want 3", status, err)
+	}
+	if got := stdout.String(); got != "hello, world\n" {
+		t.Errorf("got %q, want %q", got, "hello, world\n")
+	}
+}
+
+func TestSymtabFind(t *testing.T) {
+	// NewSymtab sorts the symbols for Find.
+	syms := NewSymtab([]Symbol{
+		{Name: "n", Type: SymAbs, Value: 002004},
+		{Name: "_x", Type: SymData | SymExt, Value: 002000},
+		{Name: "L1", Type: SymText, Value: 001010},
+		{Name: "L2", Type: SymData, Value: 002000},
+		{Name: "main", Type: SymText | SymExt, Value: 001000},
+	})
+	for _, tt := range []struct {
+		addr  uint16
+		types []uint16
+		want  string
+	}{
+		{001020, []uint16{SymText | SymExt}, "main"},
+		{001020, []uint16{SymText | SymExt, SymText}, "L1"},
+		{002006, []uint16{SymData, SymData | SymExt}, "L2"},
+		{002006, []uint16{SymData | SymExt, SymData}, "_x"},
+		{000776, []uint16{SymText | SymExt}, ""},
+	} {
+		sym, _ := syms.Find(tt.addr, tt.types...)
+		if sym.Name != tt.want {
+			t.Errorf("Find(%06o, %o): got %q, want %q", tt.addr, tt.types, sym.Name, tt.want)
+		}
+	}
+}
+
+func TestSyscallsOpen(t *testing.T) {
+	const prog = `
+	sys	open
+	name
+	0
+	bcs	1f
+	sys	read
+	buf
+	100
+	mov	r0, 9f
+	mov	$1, r0
+	sys	write
+	buf
+9:	0
+	clr	r0
+	sys	exit
+1:	sys	exit
+name:	.asciz	"/../hello"
+	.even
+buf:	.blkb	100
+`
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	for _, tt := range []struct {
+		dir    string
+		status int
+		out    string
+	}{
+		{dir, 0, "hello\n"},
+		{"", enoent, ""},
+	} {
+		pdp := New()
+		if _, err := pdp.LoadAOut(bytes.NewReader(aout(t, prog))); err != nil {
+			t.Fatal(err)
+		}
+		var stdout bytes.Buffer
+		pdp.EmulateSyscalls(&Syscalls{Stdout: &stdout, Dir: tt.dir})
+		if status, err := pdp.RunUntilExit(); err != nil || status != tt.status {
+			t.Errorf("dir %q: exit status %d, %v, want %d", tt.dir, status, err, tt.status)
+		}
+		if got := stdout.String(); got != tt.out {
+			t.Errorf("dir %q: got %q, want %q", tt.dir, got, tt.out)
+		}
+	}
+}
+
+func TestSyscallsFiles(t *testing.T) {
+	dir := t.TempDir()
+	if err := ioutil.WriteFile(filepath.Join(dir, "hello"), []byte("hello\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	stdout, err := os.Create(filepath.Join(dir, "stdout"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer stdout.Close()
+	for _, tt := range []struct {
+		prog   string
+		status int
+	}{
+		// reading a file opened for writing.
+		{"sys open\nname\n1\nbcs 1f\nsys read\nname\n1\n1: sys exit\nname: .asciz \"hello\"", ebadf},
+		// writing a file opened for reading.
+		{"sys open\nname\n0\nbcs 1f\nsys write\nname\n1\n1: sys exit\nname: .asciz \"hello\"", ebadf},
+		// closing standard output, which belongs to the caller.
+		{"mov $1, r0\nsys close\nclr r0\nsys exit", 0},
+	} {
+		pdp := New()
+		if _, err := pdp.LoadAOut(bytes.NewReader(aout(t, tt.prog))); err != nil {
+			t.Fatal(err)
+		}
+		pdp.EmulateSyscalls(&Syscalls{Stdout: stdout, Dir: dir})
+		if status, err := pdp.RunUntilExit(); err != nil || status != tt.status {
+			t.Errorf("%q: exit status %d, %v, want %d", tt.prog, status, err, tt.status)
+		}
+	}
+	if _, err := stdout.WriteString("still open\n"); err != nil {
+		t.Errorf("standard output was closed: %v", err)
+	}
+}
+
+func TestRunUntilExitErrors(t *testing.T) {
+	for _, tt := range []struct {
+		prog, err string
+	}{
+		{"sys 77", "trap through unset vector 034 at 000000"},
+		{"iot", "trap through unset vector 020 at 000000"},
+	} {
+		pdp := New()
+		if _, err := pdp.LoadAOut(bytes.NewReader(aout(t, tt.prog))); err != nil {
+			t.Fatal(err)
+		}
+		pdp.EmulateSyscalls(&Syscalls{})
+		if _, err := pdp.RunUntilExit(); err == nil || err.Error() != tt.err {
+			t.Errorf("%q: got error %v, want %q", tt.prog, err, tt.err)
+		}
+	}
+}
+
+func TestLoadAOutV6(t *testing.T) {
+	img, err := ioutil.ReadFile("rk0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	fs, err := v6fs.Mount(v6fs.Image(img))
+	if err != nil {
+		t.Fatal(err)
+	}
+	echo, err := fs.ReadFile("bin/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	pdp := New()
+	if _, err := pdp.LoadAOut(bytes.NewReader(echo), "echo", "hello,", "world"); err != nil {
+		t.Fatal(err)
+	}
+	if sp := uint18(pdp.R[6]); pdp.unibus.read16(sp) != 3 || pdp.unibus.read16(sp+8) != 0177777 {
+		t.Errorf("argc %d, argv[3] %06o, want 3 and -1", pdp.unibus.read16(sp), pdp.unibus.read16(sp+8))
+	}
+	var stdout bytes.Buffer
+	pdp.EmulateSyscalls(&Syscalls{Stdout: &stdout})
+	if _, err := pdp.RunUntilExit(); err != nil {
+		t.Fatal(err)
+	}
+	if got := stdout.String(); got != "hello, world\n" {
+		t.Errorf("got %q, want %q", got, "hello, world\n")
+	}
+}
+
+// ldaBlock returns a block of an absolute loader tape, preceded by leader.
+func ldaBlock(addr uint16, data ...byte) []byte {
+	n := len(data) + 6
+	b := append([]byte{0, 0, 0, 1, 0, byte(n), byte(n >> 8), byte(addr), byte(addr >> 8)}, data...)
+	var sum byte
+	for _, c := range b {
+		sum += c
+	}
+	return append(b, -sum)
+}
+
+func TestLoadAbsolute(t *testing.T) {
+	var tape []byte
+	tape = append(tape, ldaBlock(001000, 0301, 0025, 0052, 0000)...) // MOV #52, R1
+	tape = append(tape, ldaBlock(001004, 0000, 0000)...)             // HALT
+	tape = append(tape, ldaBlock(001000)...)
+	pdp := New()
+	start, err := pdp.LoadAbsolute(bytes.NewReader(tape))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if start != 001000 || pdp.R[7] != 001000 {
+		t.Errorf("transfer address %06o, PC %06o, want 001000", start, pdp.R[7])
+	}
+	for a, want := range map[uint18]uint16{001000: 012701, 001002: 052, 001004: 0} {
+		if got := pdp.unibus.read16(a); got != want {
+			t.Errorf("%06o: got %06o, want %06o", a, got, want)
+		}
+	}
+
+	// an odd transfer address does not start the program.
+	pdp = New()
+	if _, err := pdp.LoadAbsolute(bytes.NewReader(ldaBlock(000001))); err != nil {
+		t.Fatal(err)
+	}
+	if pdp.R[7] != 0 {
+		t.Errorf("PC %06o, want unchanged", pdp.R[7])
+	}
+
+	bad := ldaBlock(001000, 1, 2)
+	bad[len(bad)-1]++
+	for _, tt := range []struct {
+		tape []byte
+		err  string
+	}{
+		{bad, "lda: checksum error in block at 001000"},
+		{ldaBlock(001000, 1, 2), "lda: missing transfer block"},
+		{[]byte{1, 0, 8}, "lda: short block header: unexpected EOF"},
+		{[]byte{2, 0, 6, 0, 0, 0, 0}, "lda: bad block header 002 000"},
+		{ldaBlock(0167776, 1, 2, 3), "lda: block at 167776 extends beyond memory to 170000"},
+	} {
+		_, err := New().LoadAbsolute(bytes.NewReader(tt.tape))
+		if err == nil || err.Error() != tt.err {
+			t.Errorf("got error %v, want %q", err, tt.err)
+		}
+	}
+}
+
+// rkTransfer performs an RK11 read or write of n words between the disk
+// address da and memory at 001000.
+func rkTransfer(pdp *PDP1140, write bool, da uint16, n int) {
+	fn := uint16(2 << 1) // read
+	if write {
+		fn = 1 << 1
+	}
+	pdp.unibus.write16(0777412, da)
+	pdp.unibus.write16(0777410, 001000)
+	pdp.unibus.write16(0777406, uint16(-n))
+	pdp.unibus.write16(0777404, fn|1)
+	for pdp.rk.running {
+		pdp.rk.Step()
+	}
+}
+
+func TestSIMHDisk(t *testing.T) {
+	// a whole pack with a SIMH footer, marked in the last sector.
+	img := make([]byte, rk05Geometry.size()+footerSize)
+	copy(img[rk05Geometry.size()-512:], "last")
+	copy(img[rk05Geometry.size():], "simh")
+	dir, err := ioutil.TempDir("", "pdp11")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "rk.dsk")
+	if err := ioutil.WriteFile(path, img, 0644); err != nil {
+		t.Fatal(err)
+	}
+	pdp := New()
+	pdp.Attach(0, path)
+	rkTransfer(pdp, false, 0312<<5|1<<4|013, 256)
+	if got := pdp.unibus.read16(001000); got != 'l'|'a'<<8 {
+		t.Errorf("last sector: got %06o, want %06o", got, 'l'|'a'<<8)
+	}
+
+	// a short image reads as zeros beyond its end, and grows when
+	// written there, keeping its footer.
+	copy(img[1024:], img[rk05Geometry.size():])
+	path = filepath.Join(dir, "short.dsk")
+	if err := ioutil.WriteFile(path, img[:1024+footerSize], 0644); err != nil {
+		t.Fatal(err)
+	}
+	pdp = New()
+	pdp.Attach(0, path)
+	pdp.unibus.write16(001000, 0177777)
+	rkTransfer(pdp, false, 2, 256)
+	if got := pdp.unibus.read16(001000); got != 0 {
+		t.Errorf("beyond the image: got %06o, want 0", got)
+	}
+	pdp.unibus.write16(001000, 012345)
+	rkTransfer(pdp, true, 3, 1)
+	var buf bytes.Buffer
+	if err := pdp.SaveDisk(0, &buf); err != nil {
+		t.Fatal(err)
+	}
+	saved := buf.Bytes()
+	if len(saved) != 3*512+2+footerSize || saved[1024] != 0 || saved[3*512] != 0345 || string(saved[len(saved)-footerSize:][:4]) != "simh" {
+		t.Errorf("saved image of %d bytes, want %d ending in a footer", len(saved), 3*512+2+footerSize)
+	}
+
+	if _, err := newDisk(rk05Geometry, bytes.NewReader(make([]byte, rk05Geometry.size()+512)), int64(rk05Geometry.size()+512)); err == nil {
+		t.Error("attached an image larger than the pack")
+	}
+	if _, err := newDisk(rl02Geometry, bytes.NewReader(make([]byte, 10485760)), 10485760); err != nil {
+		t.Error(err)
+	}
+}
+
+func TestAttachImage(t *testing.T) {
+	img, err := ioutil.ReadFile("rk0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var buf bytes.Buffer
+	zw := gzip.NewWriter(&buf)
+	zw.Write(img)
+	zw.Close()
+	gz := buf.Bytes()
+
+	pdp := New()
+	if err := pdp.AttachImage(0, bytes.NewReader(gz), int64(len(gz))); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := pdp.Namelist("/unix"); err != nil {
+		t.Fatal(err)
+	}
+
+	// a read only image is copied when it is written.
+	pdp = New()
+	if err := pdp.AttachImage(0, bytes.NewReader(img), int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+	pdp.unibus.write16(001000, 012345)
+	rkTransfer(pdp, true, 0, 1)
+	pdp.unibus.write16(001000, 0)
+	rkTransfer(pdp, false, 0, 1)
+	if got := pdp.unibus.read16(001000); got != 012345 || img[0] == 0345 {
+		t.Errorf("got %06o, image begins %03o, want 012345 in a copy", got, img[0])
+	}
+
+	// a 419 byte image compressed by zstd -19, with Huffman coded
+	// literals and a checksum, ending in "RK05".
+	zstd := []byte("\x28\xb5\x2f\xfd\x64\xa3\x00\x3d\x03\x00\x26\x93\x16\x0b\xd0\x65" +
+		"\x39\x3b\xe0\xa3\x42\xb8\x53\xce\x09\x13\x00\x10\x00\x11\x00\x67" +
+		"\xd5\xdf\xcd\xc7\x8f\x76\x8f\x69\xf3\xc7\x4c\xe5\x78\xa7\x71\x46" +
+		"\x8d\x02\x1d\xcf\x37\x65\x39\xe7\x3b\xf7\x49\x9f\xd7\x7a\x74\xac" +
+		"\xbf\x1f\xff\xe8\x6f\xbe\x33\x63\x9f\xf1\xd7\x3f\x19\xfd\x58\xda" +
+		"\xe3\x9b\x02\x02\x10\x82\x41\xec\xb3\xbb\xeb\x31\xbf\xf4\xe6\x31" +
+		"\x8e\x2a\xed\x67\x2a\xd3\x16\x02\x00\xa8\xa3\x60\x09\x2f\xe9\x9a" +
+		"\x02\x92\x87\x08\x24")
+	pdp = New()
+	if err := pdp.AttachImage(0, bytes.NewReader(zstd), int64(len(zstd))); err != nil {
+		t.Fatal(err)
+	}
+	rkTransfer(pdp, false, 0, 256)
+	if first, last := pdp.unibus.read16(001000), pdp.unibus.read16(001000+418); first != 'b'|'c'<<8 || last != '5' {
+		t.Errorf("zstd: image begins %06o, ends %06o", first, last)
+	}
+	zstd[len(zstd)-1]++
+	if err := pdp.AttachImage(0, bytes.NewReader(zstd), int64(len(zstd))); err == nil || !strings.Contains(err.Error(), "checksum") {
+		t.Errorf("zstd: got %v, want a checksum error", err)
+	}
+}
+
+// badImage is a disk image whose second 512 byte block can be neither
+// read nor written.
+type badImage []byte
+
+func (b badImage) ReadAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > 512 && off < 1024 {
+		return 0, errors.New("bad block")
+	}
+	if off >= int64(len(b)) {
+		return 0, io.EOF
+	}
+	return copy(p, b[off:]), nil
+}
+
+func (b badImage) WriteAt(p []byte, off int64) (int, error) {
+	if off+int64(len(p)) > 512 && off < 1024 {
+		return 0, errors.New("bad block")
+	}
+	return copy(b[off:], p), nil
+}
+
+// TestNoDrive checks that attaching or saving a drive the controller
+// does not have is an error.
+func TestNoDrive(t *testing.T) {
+	pdp := New()
+	img := make(memImage, 512)
+	for _, tt := range []struct {
+		t     DriveType
+		drive int
+	}{
+		{DriveRK05, 8}, {DriveRL02, 4}, {DriveRP06, 8}, {DriveRX01, 2}, {DriveRK05, -1},
+	} {
+		if err := pdp.AttachDrive(tt.t, tt.drive, &img, int64(len(img))); err == nil {
+			t.Errorf("AttachDrive(%d, %d): no error", tt.t, tt.drive)
+		}
+		if err := pdp.SaveDrive(tt.t, tt.drive, ioutil.Discard); err == nil {
+			t.Errorf("SaveDrive(%d, %d): no error", tt.t, tt.drive)
+		}
+	}
+	if err := pdp.AttachTape(8, NewTape(nil)); err == nil {
+		t.Errorf("AttachTape(8): no error")
+	}
+}
+
+// TestDiskErrors checks that a failure to read or write an image is
+// reported to the guest as an error of the drive.
+func TestDiskErrors(t *testing.T) {
+	img := make(badImage, 4096)
+	pdp := New()
+	if err := pdp.AttachDrive(DriveRK05, 0, img, int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+	for _, write := range []bool{false, true} {
+		pdp.unibus.write16(0777404, 1) // control reset
+		rkTransfer(pdp, write, 1, 256)
+		if er, cs := pdp.unibus.read16(0777402), pdp.unibus.read16(0777404); er&RKDRE == 0 || cs&(1<<15|1<<7) != 1<<15|1<<7 {
+			t.Errorf("RK05 write %v: rker %06o, rkcs %06o, want a drive error", write, er, cs)
+		}
+	}
+
+	if err := pdp.AttachDrive(DriveRL01, 0, img, int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []uint16{rlRead, rlWrite} {
+		pdp.unibus.write16(0774402, 001000)
+		pdp.unibus.write16(0774406, 0177600)
+		if csr := rlCommand(pdp, fn, 2); csr&(RLERR|RLDE|RLOPI|RLCRDY) != RLERR|RLDE|RLOPI|RLCRDY {
+			t.Errorf("RL01 function %d: csr %06o, want a drive error", fn, csr)
+		}
+	}
+
+	if err := pdp.AttachDrive(DriveRP06, 0, img, int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []uint16{rpRead, rpWrite} {
+		pdp.unibus.write16(0776706, 1)
+		pdp.unibus.write16(0776704, 001000)
+		pdp.unibus.write16(0776702, 0177400)
+		if cs1 := rpCommand(pdp, fn); cs1&(RPTRE|RPRDY) != RPTRE|RPRDY || pdp.unibus.read16(0776714)&rpUNS == 0 {
+			t.Errorf("RP06 function %03o: cs1 %06o, er1 %06o, want a drive error", fn, cs1, pdp.unibus.read16(0776714))
+		}
+		rpCommand(pdp, rpDriveClear)
+	}
+
+	if err := pdp.AttachDrive(DriveRX01, 0, img, int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+	for _, fn := range []uint16{rxRead, rxWrite} {
+		if cs := rxCommand(pdp, fn, 5, 0); cs&(RXDONE|RXERROR) != RXDONE|RXERROR {
+			t.Errorf("RX01 function %d: cs %06o, want an error", fn, cs)
+		}
+		if rxCommand(pdp, rxErrorCode); pdp.unibus.read16(0777172) != rxBadData {
+			t.Errorf("RX01 function %d: error code %04o, want %04o", fn, pdp.unibus.read16(0777172), rxBadData)
+		}
+	}
+}
+
+func TestTape(t *testing.T) {
+	tape := NewTape(nil)
+	tape.WriteRecord([]byte("odd"))
+	tape.WriteRecord([]byte("even"))
+	tape.WriteMark()
+	tape.WriteRecord([]byte("second file"))
+	tape.WriteMark()
+	tape.WriteMark()
+	want := []byte{3, 0, 0, 0, 'o', 'd', 'd', 0, 3, 0, 0, 0, 4, 0, 0, 0, 'e', 'v', 'e', 'n', 4, 0, 0, 0, 0, 0, 0, 0}
+	if got := tape.Bytes(); !bytes.Equal(got[:len(want)], want) {
+		t.Errorf("got % x, want % x", got[:len(want)], want)
+	}
+
+	tape = NewTape(tape.Bytes())
+	for _, want := range []struct {
+		rec string
+		err error
+	}{{"odd", nil}, {"even", nil}, {"", ErrTapeMark}, {"second file", nil}, {"", ErrTapeMark}, {"", ErrTapeMark}, {"", ErrEndOfTape}} {
+		rec, err := tape.ReadRecord()
+		if string(rec) != want.rec || err != want.err {
+			t.Errorf("got %q, %v, want %q, %v", rec, err, want.rec, want.err)
+		}
+	}
+	if n, err := tape.SpaceBack(5); n != 0 || err != ErrTapeMark {
+		t.Errorf("SpaceBack: got %d, %v, want 0, tape mark", n, err)
+	}
+	tape.SpaceBack(1)
+	if n, err := tape.SpaceBack(5); n != 1 || err != ErrTapeMark {
+		t.Errorf("SpaceBack: got %d, %v, want 1, tape mark", n, err)
+	}
+	if rec, _ := tape.ReadRecord(); rec != nil {
+		t.Errorf("after spacing back: got %q, want the tape mark", rec)
+	}
+	tape.Rewind()
+	if n, err := tape.SpaceForward(5); n != 2 || err != ErrTapeMark {
+		t.Errorf("SpaceForward: got %d, %v, want 2, tape mark", n, err)
+	}
+	tape.Rewind()
+	tape.SpaceForward(1)
+	tape.WriteRecord([]byte("new"))
+	if rec, err := tape.ReadRecord(); err != ErrEndOfTape {
+		t.Errorf("after overwriting: got %q, %v, want the end of the tape", rec, err)
+	}
+	if n, err := tape.SpaceBack(3); n != 2 || err != ErrBOT || !tape.BOT() {
+		t.Errorf("SpaceBack: got %d, %v, want 2 and the beginning of the tape", n, err)
+	}
+}
+
+// rlCommand starts RL11 function fn on the selected drive and runs it to
+// completion, returning the CSR.
+func rlCommand(pdp *PDP1140, fn, da uint16) uint16 {
+	pdp.unibus.write16(0774404, da)
+	pdp.unibus.write16(0774400, pdp.rl.CSR&RLDSEL|fn<<1|RLIE)
+	for pdp.rl.running {
+		pdp.rl.Step()
+	}
+	return pdp.unibus.read16(0774400)
+}
+
+func TestRL11(t *testing.T) {
+	const cyl, head, sector = 5, 1, 3
+	img := make([]byte, (cyl*2+head)*40*256+sector*256+256)
+	copy(img[len(img)-256:], "rl02")
+	pdp := New()
+	if err := pdp.AttachDrive(DriveRL02, 0, bytes.NewReader(img), int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+
+	if csr := rlCommand(pdp, rlGetStatus, 013); csr&(RLERR|RLCRDY|RLDRDY) != RLCRDY|RLDRDY {
+		t.Fatalf("get status: csr %06o", csr)
+	}
+	if got := pdp.unibus.read16(0774406); got != rlLockOn|rlBH|rlHO|rlDT {
+		t.Errorf("get status: got %06o, want %06o", got, rlLockOn|rlBH|rlHO|rlDT)
+	}
+	if pdp.cpu.interrupts[0].vec != intRL {
+		t.Errorf("interrupt: got vector %03o, want %03o", pdp.cpu.interrupts[0].vec, intRL)
+	}
+
+	rlCommand(pdp, rlSeek, cyl<<7|head<<4|1<<2|1)
+	rlCommand(pdp, rlReadHeader, 0)
+	hdr := pdp.unibus.read16(0774406)
+	if hdr&^077 != cyl<<7|head<<6 {
+		t.Errorf("read header: got %06o, want cylinder %d head %d", hdr, cyl, head)
+	}
+	if zero, crc := pdp.unibus.read16(0774406), pdp.unibus.read16(0774406); zero != 0 || crc != rlcrc(hdr, 0) {
+		t.Errorf("read header: got %06o %06o, want 0 and the CRC", zero, crc)
+	}
+
+	read := func(da uint16) uint16 {
+		pdp.unibus.write16(0774402, 001000)
+		pdp.unibus.write16(0774406, 0177600)
+		return rlCommand(pdp, rlRead, da)
+	}
+	if csr := read(cyl<<7 | head<<6 | sector); csr&RLERR != 0 {
+		t.Fatalf("read: csr %06o", csr)
+	}
+	if got := pdp.unibus.read16(001000); got != 'r'|'l'<<8 {
+		t.Errorf("read: got %06o, want %06o", got, 'r'|'l'<<8)
+	}
+	if csr := read(0); csr&(RLERR|RLHNF) != RLERR|RLHNF {
+		t.Errorf("read from another cylinder: csr %06o, want header not found", csr)
+	}
+
+	// writes are made to a copy of the read only image.
+	pdp.unibus.write16(001000, 0123456)
+	pdp.unibus.write16(0774402, 001000)
+	pdp.unibus.write16(0774406, 0177777)
+	if csr := rlCommand(pdp, rlWrite, cyl<<7|head<<6|sector); csr&RLERR != 0 {
+		t.Fatalf("write: csr %06o", csr)
+	}
+	var saved bytes.Buffer
+	pdp.SaveDrive(DriveRL02, 0, &saved)
+	if got := saved.Bytes()[len(img)-256:]; got[0] != 0056 || got[1] != 0247 || got[2] != 0 || img[len(img)-256] != 'r' {
+		t.Errorf("write: sector begins % o", got[:4])
+	}
+
+	// the bus address extension reaches beyond the 11/40's memory.
+	pdp.unibus.write16(0774410, 4)
+	pdp.unibus.write16(0774406, 0177600)
+	if csr := rlCommand(pdp, rlReadNoHeader, 0); csr&(RLERR|RLNXM) != RLERR|RLNXM {
+		t.Errorf("read to 22 bit address: csr %06o, want non-existent memory", csr)
+	}
+
+	pdp.unibus.write16(0774400, 1<<8|RLCRDY)
+	if csr := pdp.unibus.read16(0774400); csr&RLDRDY != 0 {
+		t.Errorf("drive 1 is ready: csr %06o", csr)
+	}
+	if csr := rlCommand(pdp, rlRead, 0); csr&(RLERR|RLDE) != RLERR|RLDE {
+		t.Errorf("read from drive 1: csr %06o, want drive error", csr)
+	}
+}
+
+// rpCommand starts RH11 function fn, including the GO bit, on the
+// selected drive and runs it to completion, returning CS1.
+func rpCommand(pdp *PDP1140, fn uint16) uint16 {
+	pdp.unibus.write16(0776700, fn|RPIE)
+	for pdp.rp.running {
+		pdp.rp.Step()
+	}
+	return pdp.unibus.read16(0776700)
+}
+
+func TestRH11(t *testing.T) {
+	img := make([]byte, 2*22*512)
+	copy(img, "boot")
+	copy(img[21*512:], "end of track")
+	copy(img[22*512:], "track 1")
+	pdp := New()
+	if err := pdp.AttachDrive(DriveRP06, 0, bytes.NewReader(img), int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+
+	// boot from the first sector.
+	pdp.LoadMemory(BOOTRP)
+	pdp.SetPC(002002)
+	for i := 0; i < 100 && pdp.R[7] != 0; i++ {
+		pdp.Step()
+	}
+	if pdp.R[7] != 0 || pdp.unibus.read16(0) != 'b'|'o'<<8 {
+		t.Fatalf("boot: PC %06o, memory begins %06o", pdp.R[7], pdp.unibus.read16(0))
+	}
+	if got := pdp.unibus.read16(0776726); got != 020022 {
+		t.Errorf("drive type: got %06o, want RP06", got)
+	}
+
+	// a seek raises attention.
+	pdp.unibus.write16(0776734, 100)
+	if cs1 := rpCommand(pdp, rpSeek); cs1&RPSC == 0 || pdp.unibus.read16(0776716) != 1 || pdp.unibus.read16(0776736) != 100 {
+		t.Errorf("seek: cs1 %06o, attention %06o, cylinder %d", cs1, pdp.unibus.read16(0776716), pdp.unibus.read16(0776736))
+	}
+	pdp.unibus.write16(0776716, 1)
+	if cs1 := pdp.unibus.read16(0776700); cs1&RPSC != 0 {
+		t.Errorf("attention not cleared: cs1 %06o", cs1)
+	}
+
+	// transfers continue onto the next track.
+	pdp.unibus.write16(0776734, 0)
+	pdp.unibus.write16(0776706, 21)
+	pdp.unibus.write16(0776704, 001000)
+	pdp.unibus.write16(0776702, 0177000)
+	if cs1 := rpCommand(pdp, rpRead); cs1&(RPTRE|RPRDY) != RPRDY {
+		t.Fatalf("read: cs1 %06o", cs1)
+	}
+	if got, want := pdp.unibus.read16(001000+2*rpWords), uint16('t'|'r'<<8); pdp.unibus.read16(001000) != 'e'|'n'<<8 || got != want {
+		t.Errorf("read: got %06o, %06o", pdp.unibus.read16(001000), got)
+	}
+	if da := pdp.unibus.read16(0776706); da != 1<<8|1 {
+		t.Errorf("read: disk address %06o, want track 1, sector 1", da)
+	}
+
+	// writes are made to a copy of the read only image.
+	pdp.unibus.write16(0776706, 0)
+	pdp.unibus.write16(0776704, 001000)
+	pdp.unibus.write16(0776702, 0177777)
+	if cs1 := rpCommand(pdp, rpWrite); cs1&RPTRE != 0 {
+		t.Fatalf("write: cs1 %06o", cs1)
+	}
+	var saved bytes.Buffer
+	pdp.SaveDrive(DriveRP06, 0, &saved)
+	if got := saved.Bytes(); got[0] != 'e' || got[2] != 0 || img[0] != 'b' {
+		t.Errorf("write: sector begins %q", got[:4])
+	}
+
+	pdp.unibus.write16(0776706, 23)
+	if cs1 := rpCommand(pdp, rpRead); cs1&RPTRE == 0 || pdp.unibus.read16(0776714) != rpIAE {
+		t.Errorf("read of sector 23: cs1 %06o, er1 %06o, want an invalid address", cs1, pdp.unibus.read16(0776714))
+	}
+	rpCommand(pdp, rpDriveClear)
+	if ds := pdp.unibus.read16(0776712); ds&(rpERR|rpATA) != 0 {
+		t.Errorf("drive clear: ds %06o", ds)
+	}
+
+	// the drive cannot be changed during a transfer.
+	pdp.unibus.write16(0776706, 0)
+	pdp.unibus.write16(0776702, 0177000)
+	pdp.unibus.write16(0776700, rpRead)
+	pdp.unibus.write16(0776710, 1)
+	for pdp.rp.running {
+		pdp.rp.Step()
+	}
+	if cs2 := pdp.unibus.read16(0776710); cs2&RPUNIT != 0 || cs2&RPPGE == 0 {
+		t.Errorf("unit select during read: cs2 %06o, want drive 0 and a program error", cs2)
+	}
+
+	pdp.unibus.write16(0776710, 1)
+	if cs1 := rpCommand(pdp, rpRead); cs1&RPTRE == 0 || cs1&RPGO != 0 || pdp.unibus.read16(0776710)&RPNED == 0 {
+		t.Errorf("read from drive 1: cs1 %06o, cs2 %06o, want a non-existent drive", cs1, pdp.unibus.read16(0776710))
+	}
+}
+
+// tmCommand performs TM11 function fn on drive 0 with the byte record
+// counter set to -n, returning the status register.
+func tmCommand(pdp *PDP1140, fn uint16, n int) uint16 {
+	pdp.unibus.write16(0772526, 001000)
+	pdp.unibus.write16(0772524, uint16(-n))
+	pdp.unibus.write16(0772522, fn<<1|TMGO)
+	for pdp.tm.running {
+		pdp.tm.Step()
+	}
+	return pdp.unibus.read16(0772520)
+}
+
+func TestTM11(t *testing.T) {
+	tape := NewTape(nil)
+	tape.WriteRecord([]byte("first record"))
+	tape.WriteRecord([]byte("second"))
+	tape.WriteMark()
+	tape.WriteRecord([]byte("next file"))
+	tape.Rewind()
+	pdp := New()
+	if err := pdp.AttachTape(0, tape); err != nil {
+		t.Fatal(err)
+	}
+
+	if mts := pdp.unibus.read16(0772520); mts != TMSELR|TMBOT|TMTUR {
+		t.Errorf("status %06o, want on line at BOT", mts)
+	}
+	if mts := tmCommand(pdp, tmRead, 100); mts&TMERRS != 0 || int16(pdp.unibus.read16(0772524)) != -100+12 {
+		t.Errorf("read: status %06o, count %06o", mts, pdp.unibus.read16(0772524))
+	}
+	if got := pdp.unibus.read16(001000); got != 'f'|'i'<<8 {
+		t.Errorf("read: got %06o, want %06o", got, 'f'|'i'<<8)
+	}
+	pdp.unibus.write16(0772522, TMIE)
+	if pdp.cpu.interrupts[0].vec != intTM {
+		t.Errorf("interrupt: got vector %03o, want %03o", pdp.cpu.interrupts[0].vec, intTM)
+	}
+	pdp.unibus.write16(0772522, 0)
+	if mts := tmCommand(pdp, tmRead, 3); mts&TMRLE == 0 || pdp.unibus.read16(0772522)&TMERR == 0 {
+		t.Errorf("short read: status %06o, want a record length error", mts)
+	}
+	if mts := tmCommand(pdp, tmRead, 100); mts&TMEOF == 0 {
+		t.Errorf("read of tape mark: status %06o", mts)
+	}
+	if mts := tmCommand(pdp, tmSpaceReverse, 5); mts&TMEOF == 0 || int16(pdp.unibus.read16(0772524)) != -5 {
+		t.Errorf("space reverse: status %06o, count %06o", mts, pdp.unibus.read16(0772524))
+	}
+	if mts := tmCommand(pdp, tmSpaceReverse, 5); mts&TMBOT == 0 || int16(pdp.unibus.read16(0772524)) != -5+2 {
+		t.Errorf("space reverse to BOT: status %06o, count %06o", mts, pdp.unibus.read16(0772524))
+	}
+	if mts := tmCommand(pdp, tmSpaceForward, 1); mts&TMERRS != 0 {
+		t.Errorf("space forward: status %06o", mts)
+	}
+
+	// writing replaces the rest of the tape.
+	copy(pdp.Memory[001000>>1:], []uint16{'a' | 'b'<<8, 'c'})
+	tmCommand(pdp, tmWrite, 3)
+	tmCommand(pdp, tmWriteEOF, 0)
+	if mts := tmCommand(pdp, tmRewind, 0); mts&TMBOT == 0 {
+		t.Errorf("rewind: status %06o", mts)
+	}
+	want := NewTape(nil)
+	want.WriteRecord([]byte("first record"))
+	want.WriteRecord([]byte("abc"))
+	want.WriteMark()
+	if !bytes.Equal(tape.Bytes(), want.Bytes()) {
+		t.Errorf("got tape % x, want % x", tape.Bytes(), want.Bytes())
+	}
+
+	// a record which does not fit before the end of the tape is not
+	// written.
+	tape.Limit = 6
+	if mts := tmCommand(pdp, tmWrite, 3); mts&TMBTE == 0 {
+		t.Errorf("write beyond the end of the tape: status %06o", mts)
+	}
+	if !bytes.Equal(tape.Bytes(), want.Bytes()) {
+		t.Errorf("after writing beyond the end got tape % x, want % x", tape.Bytes(), want.Bytes())
+	}
+	if err := tape.WriteMark(); err != nil {
+		t.Errorf("WriteMark within the tape: %v", err)
+	}
+	if err := tape.WriteMark(); err != ErrEndOfTape {
+		t.Errorf("WriteMark at the end of the tape: got %v, want %v", err, ErrEndOfTape)
+	}
+
+	tmCommand(pdp, tmOffLine, 0)
+	if mts := tmCommand(pdp, tmRead, 100); mts&(TMILC|TMSELR) != TMILC {
+		t.Errorf("read while off line: status %06o", mts)
+	}
+}
+
+// rxCommand starts RX11 function fn on drive 0 and writes args to RXDB
+// as the controller requests them, returning RXCS.
+func rxCommand(pdp *PDP1140, fn uint16, args ...uint16) uint16 {
+	pdp.unibus.write16(0777170, fn<<1|RXGO)
+	for _, v := range args {
+		if pdp.unibus.read16(0777170)&RXTR == 0 {
+			break
+		}
+		pdp.unibus.write16(0777172, v)
+	}
+	return pdp.unibus.read16(0777170)
+}
+
+// rxEmptyBuffer returns the contents of the RX11 sector buffer.
+func rxEmptyBuffer(pdp *PDP1140) []byte {
+	var buf []byte
+	for rxCommand(pdp, rxEmpty); pdp.unibus.read16(0777170)&RXTR != 0; {
+		buf = append(buf, byte(pdp.unibus.read16(0777172)))
+	}
+	return buf
+}
+
+func TestRX11(t *testing.T) {
+	img := make([]byte, rx01Geometry.size())
+	copy(img[26*rxBytes:], "track 1")
+	pdp := New()
+	if err := pdp.AttachDrive(DriveRX01, 0, bytes.NewReader(img), int64(len(img))); err != nil {
+		t.Fatal(err)
+	}
+
+	// initialize reads track 1, sector 1 into the buffer.
+	pdp.unibus.write16(0777170, RXINIT)
+	if cs, es := pdp.unibus.read16(0777170), pdp.unibus.read16(0777172); cs != RXDONE || es != rxID|rxDRY {
+		t.Errorf("initialize: cs %06o, es %06o", cs, es)
+	}
+	if buf := rxEmptyBuffer(pdp); len(buf) != rxBytes || string(buf[:7]) != "track 1" {
+		t.Errorf("initialize: buffer holds %q", buf)
+	}
+
+	// fill the buffer, write it to the last sector and read it back.
+	const last = 3 * (rxBytes - 1) & 0377
+	var data []uint16
+	for i := 0; i < rxBytes; i++ {
+		data = append(data, uint16(i*3))
+	}
+	if cs := rxCommand(pdp, rxFill, data...); cs&(RXDONE|RXERROR) != RXDONE {
+		t.Fatalf("fill: cs %06o", cs)
+	}
+	if cs := rxCommand(pdp, rxWrite, 26, 76); cs&(RXDONE|RXERROR) != RXDONE {
+		t.Fatalf("write: cs %06o", cs)
+	}
+	rxCommand(pdp, rxRead, 1, 1)
+	rxCommand(pdp, rxRead, 26, 76)
+	if buf := rxEmptyBuffer(pdp); buf[1] != 3 || buf[rxBytes-1] != last {
+		t.Errorf("read back % x", buf)
+	}
+	var saved bytes.Buffer
+	pdp.SaveDrive(DriveRX01, 0, &saved)
+	if got := saved.Bytes(); got[len(got)-1] != last || img[len(img)-1] != 0 {
+		t.Errorf("saved image ends %03o", got[len(got)-4:])
+	}
+
+	if cs := rxCommand(pdp, rxRead, 27, 0); cs&RXERROR == 0 {
+		t.Errorf("read of sector 27: cs %06o", cs)
+	}
+	if rxCommand(pdp, rxErrorCode); pdp.unibus.read16(0777172) != rxNoSector {
+		t.Errorf("error code %04o, want %04o", pdp.unibus.read16(0777172), rxNoSector)
+	}
+	pdp.unibus.write16(0777170, RXUNIT|rxStatus<<1|RXGO)
+	if es := pdp.unibus.read16(0777172); es&rxDRY != 0 {
+		t.Errorf("drive 1: es %06o, want not ready", es)
+	}
+}
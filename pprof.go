@@ -0,0 +1,169 @@
+package pdp11
+
+import (
+	"compress/gzip"
+	"fmt"
+	"io"
+	"sort"
+)
+
+// WritePprof writes the profile to w as a gzipped protocol buffer in the
+// format read by go tool pprof. Each location is a guest PC, its function
+// the symbol found in kernel, for kernel mode, or user, for user mode. The
+// samples are labelled with their mode and process.
+func (p *Profile) WritePprof(w io.Writer, kernel, user Symtab) error {
+	kernel, user = NewSymtab(kernel), NewSymtab(user)
+	counts := p.snapshot()
+	var keys []profileKey
+	for k := range counts {
+		keys = append(keys, k)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := keys[i], keys[j]
+		switch {
+		case a.user != b.user:
+			return b.user
+		case a.pc != b.pc:
+			return a.pc < b.pc
+		default:
+			return a.proc < b.proc
+		}
+	})
+
+	strs := map[string]int64{"": 0}
+	strtab := []string{""}
+	str := func(s string) int64 {
+		if i, ok := strs[s]; ok {
+			return i
+		}
+		strs[s] = int64(len(strtab))
+		strtab = append(strtab, s)
+		return strs[s]
+	}
+
+	type location struct {
+		pc   uint16
+		user bool
+	}
+	var prof, buf protobuf
+	valueType := func(typ, unit string) []byte {
+		buf.reset()
+		buf.int64(1, str(typ))
+		buf.int64(2, str(unit))
+		return buf.clone()
+	}
+	prof.bytes(1, valueType("instructions", "count"))
+	prof.bytes(1, valueType("cpu", "nanoseconds"))
+
+	locs := make(map[location]uint64)
+	funcs := make(map[funcKey]uint64)
+	var locorder []location
+	var funcorder []funcKey
+	for _, k := range keys {
+		c := counts[k]
+		l := location{k.pc, k.user}
+		id, ok := locs[l]
+		if !ok {
+			id = uint64(len(locs) + 1)
+			locs[l] = id
+			locorder = append(locorder, l)
+		}
+		buf.reset()
+		buf.packed(1, []uint64{id})
+		buf.packed(2, []uint64{c.instrs, c.cycles * uint64(CycleTime)})
+		buf.bytes(3, label(str("mode"), str(modeName(k.user)), 0))
+		buf.bytes(3, label(str("process"), str(fmt.Sprintf("%08x", k.proc)), 0))
+		prof.bytes(2, buf.clone())
+	}
+
+	for i, l := range locorder {
+		fk := funcKey{l.user, symbolize(l.pc, l.user, kernel, user)}
+		fid, ok := funcs[fk]
+		if !ok {
+			fid = uint64(len(funcs) + 1)
+			funcs[fk] = fid
+			funcorder = append(funcorder, fk)
+		}
+		var line protobuf
+		line.uint64(1, fid)
+		buf.reset()
+		buf.uint64(1, uint64(i+1))
+		buf.uint64(3, uint64(l.pc))
+		buf.bytes(4, line.clone())
+		prof.bytes(4, buf.clone())
+	}
+
+	for i, fk := range funcorder {
+		buf.reset()
+		buf.uint64(1, uint64(i+1))
+		buf.int64(2, str(fk.symbol))
+		buf.int64(3, str(fk.symbol))
+		buf.int64(4, str(modeName(fk.user)))
+		prof.bytes(5, buf.clone())
+	}
+
+	prof.int64(9, p.start.UnixNano())
+	if !p.end.IsZero() {
+		prof.int64(10, p.end.Sub(p.start).Nanoseconds())
+	}
+	prof.bytes(11, valueType("cpu", "nanoseconds"))
+	prof.int64(12, int64(CycleTime))
+	for _, s := range strtab {
+		prof.bytes(6, []byte(s))
+	}
+
+	zw := gzip.NewWriter(w)
+	if _, err := zw.Write(prof.b); err != nil {
+		return err
+	}
+	return zw.Close()
+}
+
+// label returns an encoded pprof Label message.
+func label(key, str, num int64) []byte {
+	var b protobuf
+	b.int64(1, key)
+	b.int64(2, str)
+	b.int64(3, num)
+	return b.b
+}
+
+// protobuf is a minimal protocol buffer encoder.
+type protobuf struct{ b []byte }
+
+func (p *protobuf) reset()        { p.b = p.b[:0] }
+func (p *protobuf) clone() []byte { return append([]byte(nil), p.b...) }
+
+func (p *protobuf) varint(x uint64) {
+	for x >= 0x80 {
+		p.b = append(p.b, byte(x)|0x80)
+		x >>= 7
+	}
+	p.b = append(p.b, byte(x))
+}
+
+func (p *protobuf) tag(field, wiretype int) { p.varint(uint64(field)<<3 | uint64(wiretype)) }
+
+func (p *protobuf) uint64(field int, x uint64) {
+	if x == 0 {
+		return
+	}
+	p.tag(field, 0)
+	p.varint(x)
+}
+
+func (p *protobuf) int64(field int, x int64) { p.uint64(field, uint64(x)) }
+
+func (p *protobuf) bytes(field int, b []byte) {
+	p.tag(field, 2)
+	p.varint(uint64(len(b)))
+	p.b = append(p.b, b...)
+}
+
+func (p *protobuf) packed(field int, xs []uint64) {
+	var b protobuf
+	for _, x := range xs {
+		b.varint(x)
+	}
+	p.bytes(field, b.b)
+}
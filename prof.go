@@ -0,0 +1,207 @@
+package pdp11
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"text/tabwriter"
+	"time"
+)
+
+// Profile is an exact profile of guest code, counting the instructions
+// executed and the cycles they took at each PC, in each mode, for each
+// process. Processes are identified by their user mode PAR set.
+type Profile struct {
+	mu     sync.Mutex
+	counts map[profileKey]*profileCount // guarded by mu
+	start  time.Time
+	end    time.Time
+
+	// local holds the counts recorded by the processor since they were
+	// last merged into counts. Only the processor uses it, so it needs
+	// no lock.
+	local   map[profileKey]*profileCount
+	pending int // instructions recorded in local
+}
+
+// profileFlush is the number of instructions recorded between merges of
+// the processor's counts into the profile.
+const profileFlush = 1 << 16
+
+type profileKey struct {
+	pc   uint16
+	user bool
+	proc uint32 // hash of the user mode PARs
+}
+
+type profileCount struct {
+	instrs, cycles uint64
+}
+
+// StartProfile starts profiling the guest. Profiling continues until
+// StopProfile is called.
+func (p *PDP1140) StartProfile() *Profile {
+	p.prof = &Profile{
+		counts: make(map[profileKey]*profileCount),
+		start:  time.Now(),
+		local:  make(map[profileKey]*profileCount),
+	}
+	return p.prof
+}
+
+// StopProfile stops profiling the guest and returns the profile.
+func (p *PDP1140) StopProfile() *Profile {
+	prof := p.prof
+	if prof != nil {
+		prof.flush()
+		prof.mu.Lock()
+		prof.end = time.Now()
+		prof.mu.Unlock()
+	}
+	p.prof = nil
+	return prof
+}
+
+// record counts the instruction just executed by k, which took d.
+func (p *Profile) record(k *cpu, d time.Duration) {
+	key := profileKey{pc: k.pc, user: k.curuser, proc: k.mmu.userpars()}
+	c := p.local[key]
+	if c == nil {
+		c = new(profileCount)
+		p.local[key] = c
+	}
+	c.instrs++
+	c.cycles += uint64(d / CycleTime)
+	if p.pending++; p.pending >= profileFlush {
+		p.flush()
+	}
+}
+
+// flush merges the counts the processor has recorded into the profile.
+func (p *Profile) flush() {
+	p.mu.Lock()
+	for k, l := range p.local {
+		c := p.counts[k]
+		if c == nil {
+			c = new(profileCount)
+			p.counts[k] = c
+		}
+		c.instrs += l.instrs
+		c.cycles += l.cycles
+	}
+	p.mu.Unlock()
+	p.local = make(map[profileKey]*profileCount)
+	p.pending = 0
+}
+
+// userpars returns a hash of the user mode PARs, identifying the current
+// process.
+func (m *KT11) userpars() uint32 {
+	// FNV-1a
+	h := uint32(2166136261)
+	for _, p := range m.pages[8:] {
+		h = (h ^ uint32(p.par&0xff)) * 16777619
+		h = (h ^ uint32(p.par>>8)) * 16777619
+	}
+	return h
+}
+
+// funcKey identifies a function in the kernel or user program.
+type funcKey struct {
+	user   bool
+	symbol string
+}
+
+func modeName(user bool) string {
+	if user {
+		return "user"
+	}
+	return "kernel"
+}
+
+// symbolize returns the name of the function containing pc in the given
+// mode, using the kernel or user symbol table.
+func symbolize(pc uint16, user bool, kernel, syms Symtab) string {
+	if !user {
+		syms = kernel
+	}
+	// external symbols are preferred to local ones, such as compiler
+	// generated labels.
+	sym, ok := syms.Find(pc, SymText|SymExt)
+	if !ok {
+		sym, ok = syms.Find(pc, SymText)
+	}
+	if !ok {
+		return fmt.Sprintf("%06o", pc)
+	}
+	return sym.Name
+}
+
+// snapshot returns a copy of the profile's counts, as of the last flush.
+func (p *Profile) snapshot() map[profileKey]profileCount {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	m := make(map[profileKey]profileCount, len(p.counts))
+	for k, c := range p.counts {
+		m[k] = *c
+	}
+	return m
+}
+
+// WriteFlat writes a flat profile to w, listing the functions which took
+// the most cycles first. Kernel mode addresses are symbolized with kernel,
+// the kernel's namelist, and user mode addresses with user.
+func (p *Profile) WriteFlat(w io.Writer, kernel, user Symtab) error {
+	kernel, user = NewSymtab(kernel), NewSymtab(user)
+	counts := p.snapshot()
+	var total profileCount
+	funcs := make(map[funcKey]profileCount)
+	procs := make(map[uint32]profileCount)
+	for k, c := range counts {
+		total.instrs += c.instrs
+		total.cycles += c.cycles
+		fk := funcKey{user: k.user, symbol: symbolize(k.pc, k.user, kernel, user)}
+		f := funcs[fk]
+		f.instrs += c.instrs
+		f.cycles += c.cycles
+		funcs[fk] = f
+		pc := procs[k.proc]
+		pc.instrs += c.instrs
+		pc.cycles += c.cycles
+		procs[k.proc] = pc
+	}
+	var keys []funcKey
+	for fk := range funcs {
+		keys = append(keys, fk)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if ci, cj := funcs[keys[i]].cycles, funcs[keys[j]].cycles; ci != cj {
+			return ci > cj
+		}
+		return keys[i].symbol < keys[j].symbol
+	})
+	percent := func(n, total uint64) float64 {
+		if total == 0 {
+			return 0
+		}
+		return 100 * float64(n) / float64(total)
+	}
+	tw := tabwriter.NewWriter(w, 0, 8, 2, ' ', tabwriter.AlignRight)
+	fmt.Fprintf(tw, "cycles\t%%\tinstrs\t%%\tmode\t symbol\n")
+	for _, fk := range keys {
+		c := funcs[fk]
+		fmt.Fprintf(tw, "%d\t%.2f%%\t%d\t%.2f%%\t%s\t %s\n", c.cycles, percent(c.cycles, total.cycles), c.instrs, percent(c.instrs, total.instrs), modeName(fk.user), fk.symbol)
+	}
+	fmt.Fprintf(tw, "\ncycles\t%%\tinstrs\t%%\t process\n")
+	var ids []uint32
+	for id := range procs {
+		ids = append(ids, id)
+	}
+	sort.Slice(ids, func(i, j int) bool { return procs[ids[i]].cycles > procs[ids[j]].cycles })
+	for _, id := range ids {
+		c := procs[id]
+		fmt.Fprintf(tw, "%d\t%.2f%%\t%d\t%.2f%%\t %08x\n", c.cycles, percent(c.cycles, total.cycles), c.instrs, percent(c.instrs, total.instrs), id)
+	}
+	return tw.Flush()
+}
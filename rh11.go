@@ -0,0 +1,491 @@
+package pdp11
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RH11 control and status register 1 bits
+const (
+	RPGO   = 1 << 0
+	RPFUNC = 037 << 1
+	RPIE   = 1 << 6
+	RPRDY  = 1 << 7
+	RPA16  = 3 << 8  // bus address bits 16 and 17
+	RPDVA  = 1 << 11 // drive available
+	RPTRE  = 1 << 14 // transfer error
+	RPSC   = 1 << 15 // special condition
+)
+
+// RH11 control and status register 2 bits
+const (
+	RPUNIT = 7 << 0
+	RPBAI  = 1 << 3  // bus address increment inhibit
+	RPCLR  = 1 << 5  // controller clear
+	RPIR   = 1 << 6  // input ready
+	RPOR   = 1 << 7  // output ready
+	RPPGE  = 1 << 10 // program error
+	RPNEM  = 1 << 11 // non-existent memory
+	RPNED  = 1 << 12 // non-existent drive
+	RPWCE  = 1 << 14 // write check error
+	RPCS2E = RPPGE | RPNEM | RPNED | RPWCE
+)
+
+// RP04 and RP06 drive status bits
+const (
+	rpVV  = 1 << 6  // volume valid
+	rpDRY = 1 << 7  // drive ready
+	rpDPR = 1 << 8  // drive present
+	rpMOL = 1 << 12 // medium on line
+	rpERR = 1 << 14
+	rpATA = 1 << 15 // attention active
+)
+
+// RP04 and RP06 error register 1 bits
+const (
+	rpILF = 1 << 0  // illegal function
+	rpAOE = 1 << 9  // address overflow
+	rpIAE = 1 << 10 // invalid address
+	rpUNS = 1 << 14 // drive unsafe
+)
+
+// RP04 and RP06 functions, as written to CS1 with the GO bit.
+const (
+	rpNOP         = 001
+	rpUnload      = 003
+	rpSeek        = 005
+	rpRecal       = 007
+	rpDriveClear  = 011
+	rpRelease     = 013
+	rpOffset      = 015
+	rpCentre      = 017
+	rpPreset      = 021
+	rpPackAck     = 023
+	rpSearch      = 031
+	rpWriteCheck  = 051
+	rpWriteCheckH = 053
+	rpWrite       = 061
+	rpWriteH      = 063
+	rpRead        = 071
+	rpReadH       = 073
+)
+
+// rpWords is the number of words in an RP04 or RP06 sector.
+const rpWords = 256
+
+// BOOTRP reads the first two sectors of RP04 or RP06 drive 0 into
+// memory and starts them, as BOOTRK05 does for the RK05.
+var BOOTRP = map[uint18]uint16{
+	002000: 0042102,                /* "BD" */
+	002002: 0012706, 002004: 02000, /* MOV #boot_start, SP */
+	002006: 0012700, 002010: 0000000, /* MOV #unit, R0 */
+	002012: 0012701, 002014: 0176700, /* MOV #RPCS1, R1 */
+	002016: 0012761, 002020: 0000040, 002022: 0000010, /* MOV #CLR, 10(R1)     ; controller clear */
+	002024: 0010061, 002026: 0000010, /* MOV R0, 10(R1)       ; select unit */
+	002030: 0012711, 002032: 0000021, /* MOV #PRESET+GO, (R1) ; read-in preset */
+	002034: 0012761, 002036: 0177000, 002040: 0000002, /* MOV #-256.*2, 2(R1)  ; load wc */
+	002042: 0005061, 002044: 0000004, /* CLR 4(R1)            ; clear ba */
+	002046: 0005061, 002050: 0000006, /* CLR 6(R1)            ; clear da */
+	002052: 0005061, 002054: 0000034, /* CLR 34(R1)           ; clear cylinder */
+	002056: 0012711, 002060: 0000071, /* MOV #READ+GO, (R1)   ; read & go */
+	002062: 0105711,                /* TSTB (R1) */
+	002064: 0100376,                /* BPL .-2 */
+	002066: 0005002,                /* CLR R2 */
+	002070: 0005003,                /* CLR R3 */
+	002072: 0012704, 002074: 02020, /* MOV #START+20, R4 */
+	002076: 0005005, /* CLR R5 */
+	002100: 0105011, /* CLRB (R1) */
+	002102: 0005007, /* CLR PC */
+}
+
+// RH11 is the RH11 Massbus controller for up to eight RP04 or RP06 disk
+// drives.
+type RH11 struct {
+	CS1, CS2, WC uint16
+	ba           uint18
+
+	running bool // a data transfer is in progress
+	unit    [8]*RP0x
+	unibus  *unibus
+}
+
+// RP0x is an RP04 or RP06 drive.
+type RP0x struct {
+	disk     *disk
+	rp06     bool
+	DA, DC   uint16 // desired track and sector, desired cylinder
+	DS, ER1  uint16
+	OF       uint16
+	cc       uint16 // current cylinder
+	sector   int    // the sector passing under the heads
+	serialNo uint16
+}
+
+func (r *RH11) reset() {
+	r.CS1 = RPRDY
+	r.CS2 = 0
+	r.WC = 0
+	r.ba = 0
+	r.running = false
+	for _, u := range r.unit {
+		if u != nil {
+			u.clear()
+		}
+	}
+}
+
+// clear clears the drive's errors and attention.
+func (u *RP0x) clear() {
+	u.DS &^= rpATA | rpERR
+	u.ER1 = 0
+}
+
+// drive returns the selected drive, or nil if there is none.
+func (r *RH11) drive() *RP0x { return r.unit[r.CS2&RPUNIT] }
+
+// attention reports whether any drive is requesting attention.
+func (r *RH11) attention() bool {
+	for _, u := range r.unit {
+		if u != nil && u.DS&rpATA != 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// interrupt interrupts the processor if interrupts are enabled.
+func (r *RH11) interrupt() {
+	if r.CS1&RPIE != 0 {
+		r.unibus.cpu.interrupt(intRP, 5)
+	}
+}
+
+// done completes the data transfer in progress.
+func (r *RH11) done() {
+	r.running = false
+	r.CS1 &^= RPGO
+	if r.CS2&RPCS2E != 0 || r.drive().DS&rpERR != 0 {
+		r.CS1 |= RPTRE
+	}
+	r.CS1 |= RPRDY
+	r.interrupt()
+}
+
+// error sets the error bits err in the drive's error register,
+// raising attention.
+func (u *RP0x) error(err uint16) {
+	u.ER1 |= err
+	u.DS |= rpERR | rpATA
+}
+
+// valid reports whether the desired address is on the pack.
+func (u *RP0x) valid() bool {
+	return int(u.DC) < u.disk.cylinders && int(u.DA>>8&037) < u.disk.heads && int(u.DA&037) < u.disk.sectors
+}
+
+// start begins the function written to CS1.
+func (r *RH11) start() {
+	u := r.drive()
+	if u == nil {
+		r.CS2 |= RPNED
+		r.CS1 = r.CS1&^RPGO | RPTRE
+		r.interrupt()
+		return
+	}
+	fn := r.CS1 & (RPFUNC | RPGO)
+	if fn >= rpWriteCheck {
+		if r.running {
+			r.CS2 |= RPPGE
+			return
+		}
+		r.CS1 &^= RPTRE
+		r.CS2 &^= RPCS2E
+	}
+	switch fn {
+	case rpNOP, rpRelease:
+	case rpDriveClear:
+		u.clear()
+	case rpPackAck:
+		u.DS |= rpVV
+	case rpPreset:
+		u.DS |= rpVV
+		u.DA, u.DC, u.OF = 0, 0, 0
+		u.cc = 0
+	case rpUnload, rpRecal, rpSeek, rpOffset, rpCentre, rpSearch:
+		if fn == rpRecal {
+			u.DC = 0
+		}
+		if !u.valid() {
+			u.error(rpIAE)
+			break
+		}
+		u.cc = u.DC
+		u.DS |= rpATA
+	case rpWriteCheck, rpWrite, rpRead:
+		if !u.valid() {
+			u.error(rpIAE)
+			r.done()
+			return
+		}
+		u.cc = u.DC
+		r.CS1 &^= RPRDY
+		r.running = true
+		return
+	default:
+		// the header functions, used for formatting, are not
+		// implemented.
+		u.error(rpILF)
+		if fn >= rpWriteCheck {
+			r.done()
+			return
+		}
+	}
+	r.CS1 &^= RPGO
+	if u.DS&rpATA != 0 {
+		r.interrupt()
+	}
+}
+
+// Step transfers one sector of the data transfer in progress.
+func (r *RH11) Step() {
+	if !r.running {
+		return
+	}
+	u := r.drive()
+	fn := r.CS1 & (RPFUNC | RPGO)
+	cyl, track, sector := int(u.DC), int(u.DA>>8&037), int(u.DA&037)
+	if cyl >= u.disk.cylinders {
+		u.error(rpAOE)
+		r.done()
+		return
+	}
+	pos := int64((cyl*u.disk.heads+track)*u.disk.sectors+sector) * int64(u.disk.sectorSize)
+	var buf [rpWords * 2]byte
+	if fn != rpWrite {
+		if _, err := u.disk.ReadAt(buf[:], pos); err != nil {
+			u.error(rpUNS)
+			r.done()
+			return
+		}
+	}
+	n := 0
+	for ; n < len(buf) && r.WC != 0; n += 2 {
+		if r.ba >= MEMSIZE {
+			r.CS2 |= RPNEM
+			r.done()
+			return
+		}
+		switch fn {
+		case rpWrite:
+			val := r.unibus.read16(r.ba)
+			buf[n] = byte(val)
+			buf[n+1] = byte(val >> 8)
+		case rpWriteCheck:
+			if r.unibus.read16(r.ba) != uint16(buf[n])|uint16(buf[n+1])<<8 {
+				r.CS2 |= RPWCE
+			}
+		default:
+			r.unibus.write16(r.ba, uint16(buf[n])|uint16(buf[n+1])<<8)
+		}
+		r.unibus.cpu.vtime += dmaTime
+		if r.CS2&RPBAI == 0 {
+			r.ba = (r.ba + 2) & 0777777
+		}
+		r.WC++
+	}
+	if fn == rpWrite {
+		// the rest of a partly written sector is filled with zeros.
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		if _, err := u.disk.WriteAt(buf[:], pos); err != nil {
+			u.error(rpUNS)
+			r.done()
+			return
+		}
+	}
+	// advance to the next sector, track and cylinder.
+	u.sector = sector
+	if sector++; sector == u.disk.sectors {
+		sector = 0
+		if track++; track == u.disk.heads {
+			track = 0
+			u.DC++
+			u.cc = u.DC
+		}
+	}
+	u.DA = uint16(track<<8 | sector)
+	if r.WC == 0 || r.CS2&RPWCE != 0 {
+		r.done()
+	}
+}
+
+func (r *RH11) read16(a uint18) uint16 {
+	switch a {
+	case 0776700:
+		v := r.CS1&^RPA16 | uint16(r.ba>>8)&RPA16 | RPDVA
+		if r.CS1&RPTRE != 0 || r.attention() {
+			v |= RPSC
+		}
+		return v
+	case 0776702:
+		return r.WC
+	case 0776704:
+		return uint16(r.ba)
+	case 0776710:
+		return r.CS2 | RPOR | RPIR
+	case 0776716:
+		var as uint16
+		for i, u := range r.unit {
+			if u != nil && u.DS&rpATA != 0 {
+				as |= 1 << uint(i)
+			}
+		}
+		return as
+	case 0776722, 0776724:
+		return 0 // data buffer, maintenance
+	}
+	u := r.drive()
+	if u == nil {
+		switch a {
+		case 0776706, 0776712, 0776714, 0776720, 0776726, 0776730, 0776732, 0776734, 0776736, 0776740, 0776742, 0776744, 0776746:
+			r.CS2 |= RPNED
+			return 0
+		}
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+	switch a {
+	case 0776706:
+		return u.DA
+	case 0776712:
+		return u.DS | rpMOL | rpDPR | rpDRY
+	case 0776714:
+		return u.ER1
+	case 0776720:
+		// look ahead: the sector coming under the heads.
+		u.sector = (u.sector + 1) % u.disk.sectors
+		return uint16(u.sector) << 6
+	case 0776726:
+		if u.rp06 {
+			return 020022
+		}
+		return 020020
+	case 0776730:
+		return u.serialNo
+	case 0776732:
+		return u.OF
+	case 0776734:
+		return u.DC
+	case 0776736:
+		return u.cc
+	case 0776740, 0776742, 0776744, 0776746:
+		return 0 // error registers 2 and 3, ECC position and pattern
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+func (r *RH11) write16(a uint18, v uint16) {
+	switch a {
+	case 0776700:
+		if v&RPTRE != 0 {
+			r.CS1 &^= RPTRE
+			r.CS2 &^= RPCS2E
+		}
+		const BITS = RPIE | RPFUNC | RPGO
+		ie := r.CS1 & RPIE
+		r.CS1 = r.CS1&^(BITS&^RPGO) | v&(BITS&^RPGO)
+		r.ba = r.ba&0177777 | uint18(v&RPA16)<<8
+		switch {
+		case v&RPGO != 0:
+			r.CS1 |= RPGO
+			r.start()
+		case ie == 0 && v&RPIE != 0 && r.CS1&RPRDY != 0:
+			r.interrupt()
+		}
+		return
+	case 0776702:
+		r.WC = v
+		return
+	case 0776704:
+		r.ba = r.ba&^0177777 | uint18(v&^1)
+		return
+	case 0776710:
+		if v&RPCLR != 0 {
+			r.reset()
+			return
+		}
+		if r.running {
+			// the drive of a transfer cannot be changed.
+			r.CS2 |= RPPGE
+			return
+		}
+		r.CS2 = r.CS2&^(RPUNIT|RPBAI) | v&(RPUNIT|RPBAI)
+		return
+	case 0776716:
+		for i, u := range r.unit {
+			if u != nil && v&(1<<uint(i)) != 0 {
+				u.DS &^= rpATA
+			}
+		}
+		return
+	case 0776722, 0776724:
+		return // data buffer, maintenance
+	}
+	u := r.drive()
+	if u == nil {
+		switch a {
+		case 0776706, 0776712, 0776714, 0776720, 0776726, 0776730, 0776732, 0776734, 0776736, 0776740, 0776742, 0776744, 0776746:
+			r.CS2 |= RPNED
+			return
+		}
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+	switch a {
+	case 0776706:
+		u.DA = v & 017437
+	case 0776714:
+		u.ER1 = v
+	case 0776732:
+		u.OF = v
+	case 0776734:
+		u.DC = v & 01777
+	case 0776712, 0776720, 0776726, 0776730, 0776736, 0776740, 0776742, 0776744, 0776746:
+		// read only
+	default:
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+}
+
+// Attach makes the RP04 or RP06 image of size bytes in img available as
+// drive. Guest writes are made to img if it is an io.WriterAt, otherwise
+// to a copy in memory, as for RK11.AttachImage.
+func (r *RH11) Attach(drive int, img io.ReaderAt, size int64, rp06 bool) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	g := rp04Geometry
+	if rp06 {
+		g = rp06Geometry
+	}
+	d, err := newDisk(g, img, size)
+	if err != nil {
+		return err
+	}
+	r.unit[drive] = &RP0x{disk: d, rp06: rp06, serialNo: uint16(drive + 1)}
+	return nil
+}
+
+// Save writes the image of the pack in drive to w.
+func (r *RH11) Save(drive int, w io.Writer) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	unit := r.unit[drive]
+	if unit == nil {
+		return errors.New("drive not attached")
+	}
+	_, err := unit.disk.WriteTo(w)
+	return err
+}
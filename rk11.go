@@ -1,11 +1,14 @@
 package pdp11
 
-import "fmt"
-import "io/ioutil"
-
-const imglen = 2077696
+import (
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+)
 
 const (
+	RKDRE = (1 << 15)
 	RKOVR = (1 << 14)
 	RKNXD = (1 << 7)
 	RKNXC = (1 << 6)
@@ -21,7 +24,7 @@ type RK11 struct {
 }
 
 type RK05 struct {
-	rkdisk []byte // rk0 disk image
+	disk   *disk
 	locked bool
 }
 
@@ -76,6 +79,18 @@ func (r *RK11) rkerror(code int) {
 	panic(msg)
 }
 
+// rkdriveerror ends the operation in progress with a drive error, as
+// when the image cannot be read or written.
+func (r *RK11) rkdriveerror() {
+	r.running = false
+	r.ready()
+	r.RKER |= RKDRE
+	r.RKCS |= (1 << 15) | (1 << 14)
+	if r.RKCS&(1<<6) != 0 {
+		r.unibus.cpu.interrupt(intRK, 5)
+	}
+}
+
 func (r *RK11) Step() {
 	if !r.running {
 		return
@@ -105,22 +120,36 @@ func (r *RK11) Step() {
 	if r.sector > 013 {
 		r.rkerror(RKNXS)
 	}
-	pos := (r.cylinder*24 + r.surface*12 + r.sector) * 512
-	if pos >= len(unit.rkdisk) {
-		panic(fmt.Sprintf("pos outside rkdisk length, pos: %v, len %v", pos, len(unit.rkdisk)))
+	pos := int64(r.cylinder*24+r.surface*12+r.sector) * 512
+	var buf [512]byte
+	if !w {
+		if _, err := unit.disk.ReadAt(buf[:], pos); err != nil {
+			r.rkdriveerror()
+			return
+		}
 	}
-	for i := 0; i < 256 && r.RKWC != 0; i++ {
+	n := 0
+	for ; n < len(buf) && r.RKWC != 0; n += 2 {
 		if w {
 			val := r.unibus.read16(uint18(r.RKBA))
-			unit.rkdisk[pos] = byte(val & 0xFF)
-			unit.rkdisk[pos+1] = byte((val >> 8) & 0xFF)
+			buf[n] = byte(val & 0xFF)
+			buf[n+1] = byte((val >> 8) & 0xFF)
 		} else {
-			r.unibus.write16(uint18(r.RKBA), uint16(unit.rkdisk[pos])|uint16(unit.rkdisk[pos+1])<<8)
+			r.unibus.write16(uint18(r.RKBA), uint16(buf[n])|uint16(buf[n+1])<<8)
 		}
+		r.unibus.cpu.vtime += dmaTime
 		r.RKBA += 2
-		pos += 2
 		r.RKWC = (r.RKWC + 1) & 0xFFFF
 	}
+	if w {
+		if _, err := unit.disk.WriteAt(buf[:n], pos); err != nil {
+			r.rkdriveerror()
+			return
+		}
+		r.unibus.cpu.stats.sectors[1]++
+	} else {
+		r.unibus.cpu.stats.sectors[0]++
+	}
 	r.sector++
 	if r.sector > 013 {
 		r.sector = 0
@@ -128,7 +157,7 @@ func (r *RK11) Step() {
 		if r.surface > 1 {
 			r.surface = 0
 			r.cylinder++
-			if r.cylinder > 0312 {
+			if r.cylinder > 0312 && r.RKWC != 0 {
 				r.rkerror(RKOVR)
 			}
 		}
@@ -195,14 +224,49 @@ func (r *RK11) rkreset() {
 }
 
 // Attach reads the contents of file into memory and
-// makes them available as an RK11 drive unit.
+// makes them available as an RK11 drive unit. The file is a raw or SIMH
+// format RK05 image, which may be shorter than a whole pack, and may be
+// compressed with gzip or zstd.
 func (r *RK11) Attach(drive int, file string) {
 	buf, err := ioutil.ReadFile(file)
 	if err != nil {
 		panic(err)
 	}
-	unit := &RK05{
-		rkdisk: buf,
+	m := memImage(buf)
+	if err := r.AttachImage(drive, &m, int64(len(buf))); err != nil {
+		panic(fmt.Sprintf("%s: %v", file, err))
+	}
+}
+
+// AttachImage makes the RK05 image of size bytes in img available as an
+// RK11 drive unit, reading it as the guest does. If img is also an
+// io.WriterAt the guest's writes are made to it, otherwise img is copied
+// into memory when the guest first writes to the pack. A compressed image
+// is decompressed into memory when attached.
+func (r *RK11) AttachImage(drive int, img io.ReaderAt, size int64) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	d, err := newDisk(rk05Geometry, img, size)
+	if err != nil {
+		return err
+	}
+	r.unit[drive] = &RK05{
+		disk: d,
+	}
+	return nil
+}
+
+// Save writes the image of the pack in drive to w, including any SIMH
+// footer it was attached with.
+func (r *RK11) Save(drive int, w io.Writer) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	unit := r.unit[drive]
+	if unit == nil {
+		return errors.New("drive not attached")
 	}
-	r.unit[drive] = unit
+	_, err := unit.disk.WriteTo(w)
+	return err
 }
@@ -0,0 +1,348 @@
+package pdp11
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RL11 control and status register bits
+const (
+	RLDRDY = 1 << 0 // drive ready
+	RLFUNC = 7 << 1
+	RLBA16 = 3 << 4 // bus address bits 16 and 17
+	RLIE   = 1 << 6
+	RLCRDY = 1 << 7  // controller ready, cleared to start a function
+	RLDSEL = 3 << 8  // drive select
+	RLOPI  = 1 << 10 // operation incomplete
+	RLCRC  = 1 << 11 // data or header CRC, or write check error
+	RLHNF  = 1 << 12 // header not found, or data late
+	RLNXM  = 1 << 13
+	RLDE   = 1 << 14 // drive error
+	RLERR  = 1 << 15 // composite error
+	RLERRS = RLOPI | RLCRC | RLHNF | RLNXM | RLDE
+)
+
+// RL11 functions
+const (
+	rlNOP = iota
+	rlWriteCheck
+	rlGetStatus
+	rlSeek
+	rlReadHeader
+	rlWrite
+	rlRead
+	rlReadNoHeader
+)
+
+// RL01 and RL02 drive status bits, returned by get status.
+const (
+	rlLockOn = 5      // state: heads locked on a cylinder
+	rlBH     = 1 << 3 // brushes home
+	rlHO     = 1 << 4 // heads out
+	rlCO     = 1 << 5 // cover open
+	rlHS     = 1 << 6 // head selected
+	rlDT     = 1 << 7 // drive type, set for an RL02
+	rlVC     = 1 << 9 // volume check
+)
+
+// rlWords is the number of words in an RL01 or RL02 sector.
+const rlWords = 128
+
+// RL11 is the RL11 controller for up to four RL01 or RL02 cartridge
+// disk drives. It also has the bus address extension register of the
+// RLV12, which supplies bits 16 to 21 of the bus address; as the 11/40
+// has 18 bit addressing, transfers above the top of memory fail with a
+// non-existent memory error.
+type RL11 struct {
+	CSR, DA, MP uint16
+	ba          uint32 // bus address, 22 bits
+
+	header  []uint16 // words to be read from MP after it, by read header
+	running bool     // a read, write or write check is in progress
+	unit    [4]*RL0x
+	unibus  *unibus
+}
+
+// RL0x is an RL01 or RL02 drive.
+type RL0x struct {
+	disk   *disk
+	rl02   bool
+	cyl    int
+	head   int
+	sector int // the sector passing under the heads
+	vc     bool
+}
+
+func (r *RL11) reset() {
+	r.CSR = RLCRDY
+	r.DA = 0
+	r.MP = 0
+	r.ba = 0
+	r.header = nil
+	r.running = false
+	r.drdy()
+}
+
+// drdy sets the drive ready bit for the selected drive.
+func (r *RL11) drdy() {
+	r.CSR &^= RLDRDY
+	if r.unit[r.CSR&RLDSEL>>8] != nil {
+		r.CSR |= RLDRDY
+	}
+}
+
+// done completes the current function, interrupting if enabled.
+func (r *RL11) done() {
+	r.running = false
+	r.drdy()
+	if r.CSR&RLERRS != 0 {
+		r.CSR |= RLERR
+	}
+	r.CSR |= RLCRDY
+	if r.CSR&RLIE != 0 {
+		r.unibus.cpu.interrupt(intRL, 5)
+	}
+}
+
+// error completes the current function with the error bits err.
+func (r *RL11) error(err uint16) {
+	r.CSR |= err
+	r.done()
+}
+
+// status returns the drive status word of u.
+func (u *RL0x) status() uint16 {
+	if u == nil {
+		return rlCO
+	}
+	s := uint16(rlLockOn | rlBH | rlHO)
+	if u.head != 0 {
+		s |= rlHS
+	}
+	if u.rl02 {
+		s |= rlDT
+	}
+	if u.vc {
+		s |= rlVC
+	}
+	return s
+}
+
+// start begins the function in the CSR.
+func (r *RL11) start() {
+	r.CSR &^= RLERR | RLERRS | RLCRDY
+	r.header = nil
+	u := r.unit[r.CSR&RLDSEL>>8]
+	fn := r.CSR & RLFUNC >> 1
+	if u == nil && fn != rlNOP && fn != rlGetStatus {
+		r.error(RLOPI | RLDE)
+		return
+	}
+	switch fn {
+	case rlNOP:
+		r.done()
+	case rlGetStatus:
+		if r.DA&3 != 3 {
+			r.error(RLOPI)
+			return
+		}
+		if r.DA&(1<<3) != 0 && u != nil {
+			u.vc = false // reset
+		}
+		r.MP = u.status()
+		r.done()
+	case rlSeek:
+		if r.DA&3 != 1 {
+			r.error(RLOPI)
+			return
+		}
+		diff := int(r.DA >> 7)
+		if r.DA&(1<<2) == 0 {
+			diff = -diff
+		}
+		u.cyl += diff
+		switch {
+		case u.cyl < 0:
+			u.cyl = 0
+		case u.cyl >= u.disk.cylinders:
+			u.cyl = u.disk.cylinders - 1
+		}
+		u.head = int(r.DA>>4) & 1
+		r.done()
+	case rlReadHeader:
+		u.sector = (u.sector + 1) % u.disk.sectors
+		r.MP = uint16(u.cyl<<7 | u.head<<6 | u.sector)
+		r.header = []uint16{0, rlcrc(r.MP, 0)}
+		r.done()
+	default:
+		r.running = true
+	}
+}
+
+// rlcrc returns the CRC of words, as computed by the drive.
+func rlcrc(words ...uint16) uint16 {
+	var crc uint16
+	for _, w := range words {
+		for i := 0; i < 16; i++ {
+			crc ^= w & 1
+			w >>= 1
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0120001
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+	return crc
+}
+
+// Step transfers one sector of the read, write or write check in
+// progress.
+func (r *RL11) Step() {
+	if !r.running {
+		return
+	}
+	u := r.unit[r.CSR&RLDSEL>>8]
+	fn := r.CSR & RLFUNC >> 1
+	cyl, head, sector := int(r.DA>>7), int(r.DA>>6)&1, int(r.DA&077)
+	if (fn != rlReadNoHeader && (cyl != u.cyl || head != u.head)) || sector >= u.disk.sectors {
+		r.error(RLHNF)
+		return
+	}
+	u.sector = sector
+	pos := int64((cyl*2+head)*u.disk.sectors+sector) * int64(u.disk.sectorSize)
+	var buf [rlWords * 2]byte
+	if fn != rlWrite {
+		if _, err := u.disk.ReadAt(buf[:], pos); err != nil {
+			r.error(RLDE | RLOPI)
+			return
+		}
+	}
+	n := 0
+	for ; n < len(buf) && r.MP != 0; n += 2 {
+		if r.ba >= MEMSIZE {
+			r.error(RLNXM)
+			return
+		}
+		a := uint18(r.ba)
+		switch fn {
+		case rlWrite:
+			val := r.unibus.read16(a)
+			buf[n] = byte(val)
+			buf[n+1] = byte(val >> 8)
+		case rlWriteCheck:
+			if r.unibus.read16(a) != uint16(buf[n])|uint16(buf[n+1])<<8 {
+				r.CSR |= RLCRC
+			}
+		default:
+			r.unibus.write16(a, uint16(buf[n])|uint16(buf[n+1])<<8)
+		}
+		r.unibus.cpu.vtime += dmaTime
+		r.ba = (r.ba + 2) & 017777777
+		r.MP++
+	}
+	if fn == rlWrite {
+		// the rest of a partly written sector is filled with zeros.
+		for i := n; i < len(buf); i++ {
+			buf[i] = 0
+		}
+		if _, err := u.disk.WriteAt(buf[:], pos); err != nil {
+			r.error(RLDE | RLOPI)
+			return
+		}
+	}
+	r.DA++
+	if r.MP == 0 || r.CSR&RLCRC != 0 {
+		r.done()
+		return
+	}
+	if sector+1 == u.disk.sectors {
+		// transfers do not continue onto the next track.
+		r.error(RLHNF)
+	}
+}
+
+func (r *RL11) read16(a uint18) uint16 {
+	switch a {
+	case 0774400:
+		return r.CSR&^RLBA16 | uint16(r.ba>>12)&RLBA16
+	case 0774402:
+		return uint16(r.ba)
+	case 0774404:
+		return r.DA
+	case 0774406:
+		v := r.MP
+		if len(r.header) > 0 {
+			r.MP, r.header = r.header[0], r.header[1:]
+		}
+		return v
+	case 0774410:
+		return uint16(r.ba>>16) & 077
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+func (r *RL11) write16(a uint18, v uint16) {
+	switch a {
+	case 0774400:
+		if r.CSR&RLCRDY == 0 {
+			return // busy
+		}
+		const BITS = RLFUNC | RLIE | RLCRDY | RLDSEL
+		ie := r.CSR & RLIE
+		r.CSR = r.CSR&^BITS | v&BITS
+		r.ba = r.ba&^(3<<16) | uint32(v&RLBA16)<<12
+		r.drdy()
+		if v&RLCRDY == 0 {
+			r.start()
+		} else if ie == 0 && v&RLIE != 0 {
+			r.unibus.cpu.interrupt(intRL, 5)
+		}
+	case 0774402:
+		r.ba = r.ba&^0177777 | uint32(v&^1)
+	case 0774404:
+		r.DA = v
+	case 0774406:
+		r.MP = v
+		r.header = nil
+	case 0774410:
+		r.ba = r.ba&0177777 | uint32(v&077)<<16
+	default:
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+}
+
+// Attach makes the RL01 or RL02 image of size bytes in img available as
+// drive. Guest writes are made to img if it is an io.WriterAt, otherwise
+// to a copy in memory, as for RK11.AttachImage.
+func (r *RL11) Attach(drive int, img io.ReaderAt, size int64, rl02 bool) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	g := rl01Geometry
+	if rl02 {
+		g = rl02Geometry
+	}
+	d, err := newDisk(g, img, size)
+	if err != nil {
+		return err
+	}
+	r.unit[drive] = &RL0x{disk: d, rl02: rl02, vc: true}
+	r.drdy()
+	return nil
+}
+
+// Save writes the image of the pack in drive to w.
+func (r *RL11) Save(drive int, w io.Writer) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	unit := r.unit[drive]
+	if unit == nil {
+		return errors.New("drive not attached")
+	}
+	_, err := unit.disk.WriteTo(w)
+	return err
+}
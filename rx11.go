@@ -0,0 +1,254 @@
+package pdp11
+
+import (
+	"errors"
+	"fmt"
+	"io"
+)
+
+// RX11 command and status register bits
+const (
+	RXGO    = 1 << 0
+	RXFUNC  = 7 << 1
+	RXUNIT  = 1 << 4
+	RXDONE  = 1 << 5
+	RXIE    = 1 << 6
+	RXTR    = 1 << 7 // transfer request
+	RXINIT  = 1 << 14
+	RXERROR = 1 << 15
+)
+
+// RX11 functions
+const (
+	rxFill = iota
+	rxEmpty
+	rxWrite
+	rxRead
+	rxUnused
+	rxStatus
+	rxWriteDeleted
+	rxErrorCode
+)
+
+// RX01 error and status register bits
+const (
+	rxID  = 1 << 2 // initialize done
+	rxDRY = 1 << 7 // drive ready
+)
+
+// RX11 error codes, read by the read error code function.
+const (
+	rxNoTrack  = 0040 // track number out of range
+	rxNoSector = 0070 // sector not found
+	rxNotReady = 0110 // no diskette in the drive
+	rxBadData  = 0200 // CRC error in the data of the sector
+)
+
+// The states of the RX11 between the registers being read and written.
+const (
+	rxIdle       = iota
+	rxFilling    // the buffer is being written through RXDB
+	rxEmptying   // the buffer is being read through RXDB
+	rxWantSector // the sector of a read or write is wanted
+	rxWantTrack  // the track of a read or write is wanted
+)
+
+// rxBytes is the number of bytes in an RX01 sector.
+const rxBytes = 128
+
+// RX11 is the RX11 controller for two RX01 single density floppy disk
+// drives. Data is moved between the processor and the controller's
+// sector buffer a byte at a time through the data buffer register, and
+// between the buffer and the diskette by the read and write functions.
+type RX11 struct {
+	CS, DB uint16
+	es     uint16 // error and status
+	ecode  uint16 // error code of the last function
+
+	buf    [rxBytes]byte
+	state  int
+	bptr   int // the next byte of buf to fill or empty
+	sector uint16
+	unit   [2]*RX01
+	unibus *unibus
+}
+
+// RX01 is an RX01 floppy disk drive.
+type RX01 struct {
+	disk *disk
+}
+
+// reset initializes the controller, then reads sector 1 of track 1 of
+// drive 0 into the buffer, as the RX11 does on power up.
+func (r *RX11) reset() {
+	r.CS = 0
+	r.es = 0
+	r.ecode = 0
+	r.state = rxIdle
+	r.sector = 1
+	r.transfer(rxRead, 1)
+	r.es |= rxID
+	r.done(0)
+}
+
+// drive returns the selected drive, or nil if it is empty.
+func (r *RX11) drive() *RX01 { return r.unit[r.CS&RXUNIT>>4] }
+
+// done completes the current function, with the error code ecode, and
+// leaves the error and status register in RXDB.
+func (r *RX11) done(ecode uint16) {
+	r.state = rxIdle
+	r.CS = r.CS&^(RXTR|RXGO) | RXDONE
+	r.es &^= rxDRY
+	if r.drive() != nil {
+		r.es |= rxDRY
+	}
+	if ecode != 0 {
+		r.ecode = ecode
+		r.CS |= RXERROR
+	}
+	r.DB = r.es
+	if r.CS&RXIE != 0 {
+		r.unibus.cpu.interrupt(intRX, 5)
+	}
+}
+
+// transfer reads or writes the buffer at the desired sector on track,
+// returning an error code. A sector of the image which cannot be read or
+// written has bad data.
+func (r *RX11) transfer(fn, track uint16) uint16 {
+	u := r.drive()
+	switch {
+	case u == nil:
+		return rxNotReady
+	case int(track) >= u.disk.cylinders:
+		return rxNoTrack
+	case r.sector < 1 || int(r.sector) > u.disk.sectors:
+		return rxNoSector
+	}
+	pos := int64(int(track)*u.disk.sectors+int(r.sector)-1) * rxBytes
+	var err error
+	if fn == rxRead {
+		_, err = u.disk.ReadAt(r.buf[:], pos)
+	} else {
+		_, err = u.disk.WriteAt(r.buf[:], pos)
+	}
+	if err != nil {
+		return rxBadData
+	}
+	return 0
+}
+
+// start begins the function written to RXCS.
+func (r *RX11) start() {
+	r.CS &^= RXDONE | RXERROR
+	r.es &^= rxID
+	switch r.CS & RXFUNC >> 1 {
+	case rxFill:
+		r.state, r.bptr = rxFilling, 0
+		r.CS |= RXTR
+	case rxEmpty:
+		r.state, r.bptr = rxEmptying, 0
+		r.DB = uint16(r.buf[0])
+		r.CS |= RXTR
+	case rxWrite, rxRead, rxWriteDeleted:
+		r.state = rxWantSector
+		r.CS |= RXTR
+	case rxStatus:
+		r.done(0)
+	case rxErrorCode:
+		r.done(0)
+		r.DB = r.ecode
+	default:
+		r.done(0)
+	}
+}
+
+func (r *RX11) read16(a uint18) uint16 {
+	switch a {
+	case 0777170:
+		return r.CS
+	case 0777172:
+		v := r.DB
+		if r.state == rxEmptying && r.CS&RXTR != 0 {
+			if r.bptr++; r.bptr == rxBytes {
+				r.done(0)
+			} else {
+				r.DB = uint16(r.buf[r.bptr])
+			}
+		}
+		return v
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+func (r *RX11) write16(a uint18, v uint16) {
+	switch a {
+	case 0777170:
+		if v&RXINIT != 0 {
+			r.reset()
+			return
+		}
+		if r.state != rxIdle {
+			return // busy
+		}
+		const BITS = RXFUNC | RXUNIT | RXIE
+		ie := r.CS & RXIE
+		r.CS = r.CS&^BITS | v&BITS
+		switch {
+		case v&RXGO != 0:
+			r.start()
+		case ie == 0 && v&RXIE != 0 && r.CS&RXDONE != 0:
+			r.unibus.cpu.interrupt(intRX, 5)
+		}
+	case 0777172:
+		r.DB = v
+		if r.CS&RXTR == 0 {
+			return
+		}
+		switch r.state {
+		case rxFilling:
+			r.buf[r.bptr] = byte(v)
+			if r.bptr++; r.bptr == rxBytes {
+				r.done(0)
+			}
+		case rxWantSector:
+			r.sector = v & 037
+			r.state = rxWantTrack
+		case rxWantTrack:
+			fn := r.CS & RXFUNC >> 1
+			r.done(r.transfer(fn, v&0177))
+		}
+	default:
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+}
+
+// Attach makes the RX01 image of size bytes in img available as drive.
+// Guest writes are made to img if it is an io.WriterAt, otherwise to a
+// copy in memory, as for RK11.AttachImage.
+func (r *RX11) Attach(drive int, img io.ReaderAt, size int64) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	d, err := newDisk(rx01Geometry, img, size)
+	if err != nil {
+		return err
+	}
+	r.unit[drive] = &RX01{disk: d}
+	return nil
+}
+
+// Save writes the image of the diskette in drive to w.
+func (r *RX11) Save(drive int, w io.Writer) error {
+	if err := checkDrive(drive, len(r.unit)); err != nil {
+		return err
+	}
+	unit := r.unit[drive]
+	if unit == nil {
+		return errors.New("drive not attached")
+	}
+	_, err := unit.disk.WriteTo(w)
+	return err
+}
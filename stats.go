@@ -0,0 +1,117 @@
+package pdp11
+
+import (
+	"sync"
+	"time"
+)
+
+// Stats holds counters describing the work done by the machine since it
+// was created.
+type Stats struct {
+	Instructions       uint64 // instructions executed
+	KernelInstructions uint64 // instructions executed in kernel mode
+	UserInstructions   uint64 // instructions executed in user mode
+
+	Interrupts map[int]uint64 // interrupts taken, by vector
+	Traps      map[int]uint64 // traps taken, by vector, including EMT, TRAP, BPT and IOT
+	MMUFaults  uint64         // KT11 aborts
+
+	SectorsRead    uint64 // RK11 sectors read
+	SectorsWritten uint64 // RK11 sectors written
+
+	ConsoleIn  uint64 // characters received by the console
+	ConsoleOut uint64 // characters printed by the console
+
+	Idle time.Duration // wall clock time spent waiting for an interrupt
+}
+
+// publishSteps is the number of steps between updates of the statistics
+// returned by Stats.
+const publishSteps = 4096
+
+// counters are updated by the processor and devices as they run. They
+// are published to Stats every publishSteps steps so that Stats may be
+// called while the machine is running without slowing every step.
+type counters struct {
+	instrs     [2]uint64 // kernel, user
+	interrupts [0400]uint64
+	traps      [0400]uint64
+	faults     uint64
+	sectors    [2]uint64 // read, written
+	cons       [2]uint64 // in, out
+	idle       time.Duration
+	waitstart  time.Time
+}
+
+type stats struct {
+	counters
+	steps int
+
+	mu        sync.Mutex
+	published counters
+}
+
+// countstep publishes the counters if enough steps have passed.
+func (s *stats) countstep() {
+	s.steps++
+	if s.steps < publishSteps {
+		return
+	}
+	s.steps = 0
+	s.publish()
+}
+
+func (s *stats) publish() {
+	s.mu.Lock()
+	s.published = s.counters
+	s.mu.Unlock()
+}
+
+// Stats returns the machine's statistics. It may be called while the
+// machine is running, in which case the statistics may be up to a few
+// thousand instructions old.
+func (p *PDP1140) Stats() Stats {
+	p.stats.mu.Lock()
+	c := p.stats.published
+	p.stats.mu.Unlock()
+	s := Stats{
+		Instructions:       c.instrs[0] + c.instrs[1],
+		KernelInstructions: c.instrs[0],
+		UserInstructions:   c.instrs[1],
+		Interrupts:         make(map[int]uint64),
+		Traps:              make(map[int]uint64),
+		MMUFaults:          c.faults,
+		SectorsRead:        c.sectors[0],
+		SectorsWritten:     c.sectors[1],
+		ConsoleIn:          c.cons[0],
+		ConsoleOut:         c.cons[1],
+		Idle:               c.idle,
+	}
+	for i, n := range c.interrupts {
+		if n > 0 {
+			s.Interrupts[i<<2] = n
+		}
+	}
+	for i, n := range c.traps {
+		if n > 0 {
+			s.Traps[i<<2] = n
+		}
+	}
+	return s
+}
+
+// wait puts the processor into the WAIT state.
+func (k *cpu) wait() {
+	if !k.waiting {
+		k.waiting = true
+		k.stats.waitstart = time.Now()
+	}
+}
+
+// wake takes the processor out of the WAIT state.
+func (k *cpu) wake() {
+	if k.waiting {
+		k.waiting = false
+		k.stats.idle += time.Since(k.stats.waitstart)
+	}
+}
@@ -0,0 +1,233 @@
+package pdp11
+
+import (
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+)
+
+// System call numbers
+const (
+	sysIndir = 0
+	sysExit  = 1
+	sysRead  = 3
+	sysWrite = 4
+	sysOpen  = 5
+	sysClose = 6
+)
+
+// error numbers
+const (
+	enoent = 2
+	eio    = 5
+	ebadf  = 9
+	einval = 22
+	emfile = 24
+)
+
+// nofile is the number of open files a process may have.
+const nofile = 15
+
+// Syscalls emulates the exit, read, write, open and close system calls
+// of UNIX V6 and V7, and indir, for programs loaded by LoadAOut. Other
+// system calls trap through vector 034 as usual.
+type Syscalls struct {
+	Stdin  io.Reader
+	Stdout io.Writer
+	Stderr io.Writer
+
+	// Dir is the host directory in which open finds files. If it
+	// is empty, open fails.
+	Dir string
+
+	Exited bool // the program has called exit
+	Status int  // the exit status
+
+	files  [nofile]interface{}
+	opened [nofile]bool   // the file was opened by open, and is closed by close
+	mode   [nofile]uint16 // the mode open opened the file with
+}
+
+// EmulateSyscalls arranges for the system calls made by the program to
+// be handled by s.
+func (p *PDP1140) EmulateSyscalls(s *Syscalls) {
+	s.files[0] = s.Stdin
+	s.files[1] = s.Stdout
+	s.files[2] = s.Stderr
+	p.cpu.sys = s
+}
+
+// RunUntilExit runs the program until it calls exit, returning its exit
+// status. It stops with an error if the processor halts, or traps
+// through a vector which has not been set. EmulateSyscalls must have
+// been called.
+func (p *PDP1140) RunUntilExit() (int, error) {
+	for !p.cpu.sys.Exited {
+		p.cpu.trapvec = -1
+		if p.halted() {
+			return 0, fmt.Errorf("halted at %06o", p.cpu.pc)
+		}
+		if v := p.cpu.trapvec; v >= 0 && p.unibus.read16(uint18(v)) == 0 {
+			return 0, fmt.Errorf("trap through unset vector %03o at %06o", v, p.cpu.pc)
+		}
+	}
+	return p.cpu.sys.Status, nil
+}
+
+// halted executes an instruction, reporting whether it was HALT.
+func (p *PDP1140) halted() (halt bool) {
+	defer func() {
+		if t := recover(); t == "HALT" {
+			halt = true
+		} else if t != nil {
+			panic(t)
+		}
+	}()
+	p.Step()
+	return false
+}
+
+// nargs is the number of arguments following each system call.
+var nargs = map[uint16]uint16{
+	sysIndir: 1,
+	sysExit:  0,
+	sysRead:  2,
+	sysWrite: 2,
+	sysOpen:  2,
+	sysClose: 0,
+}
+
+// call emulates the system call n, made by the TRAP instruction just
+// executed, reporting whether it was handled.
+func (s *Syscalls) call(k *cpu, n uint16) bool {
+	argp := uint16(k.R[7])
+	indir := n == sysIndir
+	if indir {
+		// the system call is elsewhere, followed by its arguments.
+		addr := k.read16(argp)
+		ins := k.read16(addr)
+		if ins&0177400 != 0104400 {
+			return false
+		}
+		n, argp = ins&0377, addr+2
+	}
+	na, ok := nargs[n]
+	if !ok || n == sysIndir {
+		return false
+	}
+	var args [2]uint16
+	for i := uint16(0); i < na; i++ {
+		args[i] = k.read16(argp + 2*i)
+	}
+	if indir {
+		k.R[7] += 2
+	} else {
+		k.R[7] += int(2 * na)
+	}
+
+	r0, errno := s.syscall(k, n, uint16(k.R[0]), args)
+	if errno != 0 {
+		k.PS |= flagC
+		k.R[0] = errno
+		return true
+	}
+	k.PS &^= flagC
+	k.R[0] = int(r0)
+	return true
+}
+
+func (s *Syscalls) syscall(k *cpu, n, r0 uint16, args [2]uint16) (uint16, int) {
+	switch n {
+	case sysExit:
+		s.Exited = true
+		s.Status = int(r0 & 0377)
+		return 0, 0
+	case sysRead:
+		r, ok := s.file(r0).(io.Reader)
+		if !ok || s.opened[r0] && s.mode[r0] == 1 {
+			return 0, ebadf
+		}
+		buf := make([]byte, args[1])
+		n, err := r.Read(buf)
+		if err != nil && err != io.EOF {
+			return 0, eio
+		}
+		for i := 0; i < n; i++ {
+			k.write8(args[0]+uint16(i), uint16(buf[i]))
+		}
+		return uint16(n), 0
+	case sysWrite:
+		w, ok := s.file(r0).(io.Writer)
+		if !ok || s.opened[r0] && s.mode[r0] == 0 {
+			return 0, ebadf
+		}
+		buf := make([]byte, args[1])
+		for i := range buf {
+			buf[i] = byte(k.read8(args[0] + uint16(i)))
+		}
+		n, err := w.Write(buf)
+		if err != nil {
+			return 0, eio
+		}
+		return uint16(n), 0
+	case sysOpen:
+		return s.open(k, args[0], args[1])
+	case sysClose:
+		f := s.file(r0)
+		if f == nil {
+			return 0, ebadf
+		}
+		if c, ok := f.(io.Closer); ok && s.opened[r0] {
+			c.Close()
+		}
+		s.files[r0] = nil
+		s.opened[r0] = false
+		return 0, 0
+	}
+	return 0, einval
+}
+
+func (s *Syscalls) file(fd uint16) interface{} {
+	if fd >= nofile {
+		return nil
+	}
+	return s.files[fd]
+}
+
+// open opens the file named by the string at name in the guest, for
+// reading if mode is 0, writing if 1, or both if 2.
+func (s *Syscalls) open(k *cpu, name, mode uint16) (uint16, int) {
+	var path []byte
+	for c := k.read8(name); c != 0 && len(path) < 512; c = k.read8(name) {
+		path = append(path, byte(c))
+		name++
+	}
+	if s.Dir == "" {
+		return 0, enoent
+	}
+	flag := map[uint16]int{0: os.O_RDONLY, 1: os.O_WRONLY, 2: os.O_RDWR}
+	fl, ok := flag[mode]
+	if !ok {
+		return 0, einval
+	}
+	fd := -1
+	for i := range s.files {
+		if s.files[i] == nil {
+			fd = i
+			break
+		}
+	}
+	if fd < 0 {
+		return 0, emfile
+	}
+	// paths are confined to Dir.
+	f, err := os.OpenFile(filepath.Join(s.Dir, filepath.Clean("/"+string(path))), fl, 0)
+	if err != nil {
+		return 0, enoent
+	}
+	s.files[fd] = f
+	s.opened[fd] = true
+	s.mode[fd] = mode
+	return uint16(fd), 0
+}
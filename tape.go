@@ -0,0 +1,194 @@
+package pdp11
+
+import (
+	"encoding/binary"
+	"errors"
+)
+
+// Errors reported when moving a Tape.
+var (
+	ErrTapeMark  = errors.New("tape mark")
+	ErrEndOfTape = errors.New("end of tape")
+	ErrBadRecord = errors.New("record has a parity error")
+	ErrBOT       = errors.New("beginning of tape")
+)
+
+// SIMH .tap record headers with special meanings.
+const (
+	tapMark = 0x00000000 // tape mark
+	tapGap  = 0xfffffffe // erase gap
+	tapEOM  = 0xffffffff // end of medium
+)
+
+// Tape is a magnetic tape in the SIMH .tap format, held in memory. Each
+// record is stored as its length in a little endian 32 bit word, the
+// data, padded to an even length, and the length again. A length of 0 is
+// a tape mark. The top four bits of the length give the class of the
+// record, 0 for good data and 8 for data with an error.
+type Tape struct {
+	// Limit is the size of .tap image the tape can hold, beyond which
+	// writes fail with ErrEndOfTape. If it is 0, TapeSize is used.
+	Limit int
+
+	data []byte
+	pos  int
+}
+
+// TapeSize is the default capacity of a Tape, about that of a 2400 foot
+// reel written at 1600 bpi.
+const TapeSize = 40 << 20
+
+// NewTape returns a tape holding the .tap image in buf, positioned at
+// the beginning.
+func NewTape(buf []byte) *Tape { return &Tape{data: buf} }
+
+// Bytes returns the .tap image of the tape.
+func (t *Tape) Bytes() []byte { return t.data }
+
+// Rewind moves the tape to the beginning.
+func (t *Tape) Rewind() { t.pos = 0 }
+
+// BOT reports whether the tape is at the beginning.
+func (t *Tape) BOT() bool { return t.pos == 0 }
+
+func (t *Tape) word(pos int) uint32 { return binary.LittleEndian.Uint32(t.data[pos:]) }
+
+// recordSize returns the space taken by a record of n bytes, including
+// its length words.
+func recordSize(n int) int { return 4 + n + n&1 + 4 }
+
+// ReadRecord reads the next record. At a tape mark it returns
+// ErrTapeMark, having moved past it. At the end of the recorded part of
+// the tape it returns ErrEndOfTape, without moving. A record with an
+// error is returned along with ErrBadRecord.
+func (t *Tape) ReadRecord() ([]byte, error) {
+	for {
+		if t.pos+4 > len(t.data) {
+			return nil, ErrEndOfTape
+		}
+		h := t.word(t.pos)
+		switch h {
+		case tapMark:
+			t.pos += 4
+			return nil, ErrTapeMark
+		case tapGap:
+			t.pos += 4
+			continue
+		case tapEOM:
+			return nil, ErrEndOfTape
+		}
+		class, n := h>>28, int(h&0x0fffffff)
+		if t.pos+recordSize(n) > len(t.data) {
+			return nil, ErrEndOfTape
+		}
+		rec := t.data[t.pos+4 : t.pos+4+n]
+		t.pos += recordSize(n)
+		switch class {
+		case 0:
+			return rec, nil
+		case 8:
+			return rec, ErrBadRecord
+		}
+		// private and reserved records are skipped.
+	}
+}
+
+// SpaceForward moves forward over n records, stopping after a tape mark
+// or at the end of the tape. It returns the number of records passed,
+// not counting a tape mark.
+func (t *Tape) SpaceForward(n int) (int, error) {
+	for i := 0; i < n; i++ {
+		if _, err := t.ReadRecord(); err != nil && err != ErrBadRecord {
+			return i, err
+		}
+	}
+	return n, nil
+}
+
+// SpaceBack moves back over n records, stopping before a tape mark, once
+// it has passed it, or at the beginning of the tape, returning ErrBOT. It
+// returns the number of records passed, not counting a tape mark.
+func (t *Tape) SpaceBack(n int) (int, error) {
+	for i := 0; i < n; {
+		if t.pos < 4 {
+			t.pos = 0
+			return i, ErrBOT
+		}
+		trl := t.word(t.pos - 4)
+		switch trl {
+		case tapMark:
+			t.pos -= 4
+			return i, ErrTapeMark
+		case tapGap:
+			t.pos -= 4
+			continue
+		}
+		size := recordSize(int(trl & 0x0fffffff))
+		if size > t.pos {
+			return i, errors.New("tap: bad record length")
+		}
+		t.pos -= size
+		if trl>>28 == 0 || trl>>28 == 8 {
+			i++
+		}
+	}
+	return n, nil
+}
+
+// truncate discards the tape after the current position, as writing
+// does.
+func (t *Tape) truncate() {
+	if t.pos > len(t.data) {
+		t.pos = len(t.data)
+	}
+	t.data = t.data[:t.pos]
+}
+
+// room returns ErrEndOfTape if n more bytes would not fit on the tape
+// after the current position.
+func (t *Tape) room(n int) error {
+	limit := t.Limit
+	if limit == 0 {
+		limit = TapeSize
+	}
+	pos := t.pos
+	if pos > len(t.data) {
+		pos = len(t.data)
+	}
+	if pos+n > limit {
+		return ErrEndOfTape
+	}
+	return nil
+}
+
+// WriteRecord writes rec at the current position, erasing the rest of
+// the tape. If the record would go beyond the end of the tape nothing is
+// written and it returns ErrEndOfTape.
+func (t *Tape) WriteRecord(rec []byte) error {
+	if err := t.room(recordSize(len(rec))); err != nil {
+		return err
+	}
+	t.truncate()
+	var w [4]byte
+	binary.LittleEndian.PutUint32(w[:], uint32(len(rec)))
+	t.data = append(t.data, w[:]...)
+	t.data = append(t.data, rec...)
+	if len(rec)&1 == 1 {
+		t.data = append(t.data, 0)
+	}
+	t.data = append(t.data, w[:]...)
+	t.pos = len(t.data)
+	return nil
+}
+
+// WriteMark writes a tape mark at the current position, erasing the rest
+// of the tape. At the end of the tape it returns ErrEndOfTape.
+func (t *Tape) WriteMark() error {
+	if err := t.room(4); err != nil {
+		return err
+	}
+	t.truncate()
+	t.data = append(t.data, 0, 0, 0, 0)
+	t.pos = len(t.data)
+	return nil
+}
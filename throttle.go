@@ -0,0 +1,40 @@
+package pdp11
+
+import "time"
+
+// throttle paces execution so the wall clock time taken matches the
+// simulated execution time of a real 11/40.
+type throttle struct {
+	speed float64 // multiple of real 11/40 speed, or zero for unthrottled
+
+	count int           // steps since the last check
+	epoch time.Time     // wall clock time when pacing started
+	base  time.Duration // simulated time when pacing started
+}
+
+// SetSpeed paces Run to speed times the speed of a real 11/40, according
+// to the instruction timings of the 11/40 processor handbook. A speed of
+// zero runs as fast as possible.
+func (p *PDP1140) SetSpeed(speed float64) {
+	p.speed = speed
+	p.epoch = time.Now()
+	p.base = p.vtime
+}
+
+// pace sleeps until the wall clock catches up with simulated time.
+func (p *PDP1140) pace() {
+	p.count++
+	if p.count < 1000 {
+		return
+	}
+	p.count = 0
+	want := time.Duration(float64(p.vtime-p.base) / p.speed)
+	switch d := want - time.Since(p.epoch); {
+	case d > time.Millisecond:
+		time.Sleep(d)
+	case d < -time.Second:
+		// we can't keep up, don't try to make up the difference later.
+		p.epoch = time.Now()
+		p.base = p.vtime
+	}
+}
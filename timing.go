@@ -5,20 +5,35 @@ import (
 	"time"
 )
 
-func (c *cpu) timing(a uint18) time.Duration {
-	ins := c.unibus.read16(a)
-	l := disasmtable[0]
-	for i := 0; i < len(disasmtable); i++ {
-		l = disasmtable[i]
-		if (ins & l.inst) == l.arg {
-			goto found
-		}
-	}
-	c.printstate()
-	panic(fmt.Sprintf("timing: cannot decode instruction %06o at %06o", ins, a))
+// CycleTime is the unit of the 11/40 timing model; every instruction, trap
+// and DMA timing in the processor handbook is a multiple of 10ns.
+const CycleTime = 10 * time.Nanosecond
+
+const (
+	// time to service an interrupt, from the end of the current
+	// instruction to the fetch of the first instruction of the handler.
+	intrTime = 5300 * time.Nanosecond
 
-found:
-	msg := l.msg
+	// time to service a trap caused by an aborted instruction.
+	trapTime = 5800 * time.Nanosecond
+
+	// time stolen from the processor by each word an NPR device transfers
+	// to or from memory.
+	dmaTime = 980 * time.Nanosecond
+
+	// additional time taken by each position ASH or ASHC shifts.
+	shiftTime = 200 * time.Nanosecond
+)
+
+// Cycles returns the number of CycleTime units the processor has spent
+// executing instructions, servicing traps and interrupts and stalled by
+// DMA since reset.
+func (c *cpu) Cycles() uint64 { return uint64(c.vtime / CycleTime) }
+
+// timing returns the execution time of ins, an instance of l which has
+// just been executed.
+func (c *cpu) timing(l *opcode, ins uint16) time.Duration {
+	msg := l.name
 	var b bool
 	if l.b && (ins&0100000 == 0100000) {
 		b = true
@@ -47,15 +62,11 @@ found:
 			return srcTime(sm) + dstTime(dm, b) + 1900*time.Nanosecond
 		}
 	case "XOR":
-		switch dm {
-		case 0:
-			if sm == 0 {
-				return srcTime(sm) + dstTime(dm, b) + 990*time.Nanosecond
-			}
-			panic("XOR timing")
-		default:
-			return srcTime(sm) + dstTime(dm, b) + 1760*time.Nanosecond
+		// the source is always a register.
+		if dm == 0 {
+			return 990 * time.Nanosecond
 		}
+		return dstTime(dm, false) + 1760*time.Nanosecond
 	case "MOV":
 		switch dm {
 		case 0:
@@ -93,10 +104,16 @@ found:
 			return dstTime(dm, b) + 900*time.Nanosecond
 		}
 		return dstTime(dm, b) + 1770*time.Nanosecond
-	case "BR", "BNE", "BEQ", "BPL", "BMI", "BVC", "BVS", "BCC", "BCS", "BGE", "BLT", "BGT", "BLE", "BHI", "BLOS", "BHIS", "BLO":
-		return ((1760 + 1400) / 2) * time.Nanosecond // assume equal branch percentages
+	case "BR", "BNE", "BEQ", "BPL", "BMI", "BVC", "BVS", "BCC", "BCS", "BGE", "BLT", "BGT", "BLE", "BHI", "BLOS":
+		if c.taken(ins) {
+			return 1760 * time.Nanosecond
+		}
+		return 1400 * time.Nanosecond
 	case "SOB":
-		return ((2360 + 2040) / 2) * time.Nanosecond // assume equal branch percentages
+		if c.R[(ins>>6)&7] != 0 {
+			return 2360 * time.Nanosecond
+		}
+		return 2040 * time.Nanosecond
 	case "MFPI":
 		return 3740 * time.Nanosecond
 	case "MTPI":
@@ -150,18 +167,61 @@ found:
 	case "IOT", "EMT", "TRAP", "BPT":
 		return 5800 * time.Nanosecond
 	case "MUL":
-		return 8880 * time.Nanosecond
+		return srcTime(dm) + 8880*time.Nanosecond
 	case "DIV":
-		return 11300 * time.Nanosecond
+		return srcTime(dm) + 11300*time.Nanosecond
 	case "ASH":
-		return 2580 * time.Nanosecond // TODO(dfc) add shifts
+		return srcTime(dm) + 2580*time.Nanosecond + time.Duration(c.shift)*shiftTime
 	case "ASHC":
-		return 3260 * time.Nanosecond // TODO(dfc) add shifts
+		return srcTime(dm) + 3260*time.Nanosecond + time.Duration(c.shift)*shiftTime
+	case "CL", "SE", "NOP":
+		return 1500 * time.Nanosecond
 	case "FP":
-		return 0 // ignore
+		// the only floating point instruction implemented is SETD,
+		// which is treated as a no-op.
+		return 1500 * time.Nanosecond
+	}
+	// the model has no time for ins, such as a JMP or JSR to a
+	// register, which traps before it is timed. Take it to be as
+	// quick as the simplest instructions rather than stop the clock.
+	return 1500 * time.Nanosecond
+}
+
+// taken reports whether the branch instruction ins was taken.
+func (c *cpu) taken(ins uint16) bool {
+	switch ins & 0177400 {
+	case 0000400:
+		return true
+	case 0001000:
+		return !c.PS.Z()
+	case 0001400:
+		return c.PS.Z()
+	case 0002000:
+		return !xor(c.PS.N(), c.PS.V())
+	case 0002400:
+		return xor(c.PS.N(), c.PS.V())
+	case 0003000:
+		return !xor(c.PS.N(), c.PS.V()) && !c.PS.Z()
+	case 0003400:
+		return xor(c.PS.N(), c.PS.V()) || c.PS.Z()
+	case 0100000:
+		return !c.PS.N()
+	case 0100400:
+		return c.PS.N()
+	case 0101000:
+		return !c.PS.C() && !c.PS.Z()
+	case 0101400:
+		return c.PS.C() || c.PS.Z()
+	case 0102000:
+		return !c.PS.V()
+	case 0102400:
+		return c.PS.V()
+	case 0103000:
+		return !c.PS.C()
+	case 0103400:
+		return c.PS.C()
 	}
-	c.printstate()
-	panic(fmt.Sprintf("timing: cannot time instruction %06o at %06o", ins, a))
+	return false
 }
 
 func srcTime(mode uint16) time.Duration {
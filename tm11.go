@@ -0,0 +1,262 @@
+package pdp11
+
+import "fmt"
+
+// TM11 status register bits
+const (
+	TMTUR  = 1 << 0 // tape unit ready
+	TMRWS  = 1 << 1 // rewinding
+	TMWRL  = 1 << 2 // write lock
+	TMBOT  = 1 << 5
+	TMSELR = 1 << 6 // on line
+	TMNXM  = 1 << 7
+	TMBTE  = 1 << 8 // bad tape
+	TMRLE  = 1 << 9 // record length
+	TMEOT  = 1 << 10
+	TMCRE  = 1 << 13 // CRC error
+	TMEOF  = 1 << 14 // tape mark
+	TMILC  = 1 << 15 // illegal command
+	TMERRS = TMNXM | TMBTE | TMRLE | TMEOT | TMCRE | TMEOF | TMILC
+)
+
+// TM11 command register bits
+const (
+	TMGO   = 1 << 0
+	TMFUNC = 7 << 1
+	TMEMA  = 3 << 4 // bus address bits 16 and 17
+	TMIE   = 1 << 6
+	TMCUR  = 1 << 7 // controller ready
+	TMUNIT = 7 << 8
+	TMPCLR = 1 << 12 // power clear
+	TMDEN  = 3 << 13
+	TMERR  = 1 << 15
+)
+
+// TM11 functions
+const (
+	tmOffLine = iota
+	tmRead
+	tmWrite
+	tmWriteEOF
+	tmSpaceForward
+	tmSpaceReverse
+	tmWriteGap // write with extended inter-record gap
+	tmRewind
+)
+
+// TM11 is the TM11 controller for up to eight TU10 magnetic tape
+// drives, each holding a Tape.
+type TM11 struct {
+	MTS, MTC, MTBRC uint16
+	cma             uint18 // current memory address
+
+	running bool
+	unit    [8]*TU10
+	unibus  *unibus
+}
+
+// TU10 is a TU10 tape drive.
+type TU10 struct {
+	tape    *Tape
+	offline bool // unloaded by the off-line function
+}
+
+func (t *TM11) reset() {
+	t.MTS = 0
+	t.MTC = TMCUR
+	t.MTBRC = 0
+	t.cma = 0
+	t.running = false
+}
+
+// drive returns the selected drive, or nil if no tape is loaded in it.
+func (t *TM11) drive() *TU10 {
+	u := t.unit[t.MTC&TMUNIT>>8]
+	if u == nil || u.offline {
+		return nil
+	}
+	return u
+}
+
+// status returns the status register, whose low bits describe the
+// selected drive.
+func (t *TM11) status() uint16 {
+	s := t.MTS & TMERRS
+	if u := t.drive(); u != nil {
+		s |= TMSELR | TMTUR
+		if u.tape.BOT() {
+			s |= TMBOT
+		}
+	}
+	return s
+}
+
+// count returns the number of bytes or records given by the byte record
+// counter, in which 0 stands for 65536.
+func (t *TM11) count() int { return 0200000 - int(t.MTBRC) }
+
+// Step performs the function in progress.
+func (t *TM11) Step() {
+	if !t.running {
+		return
+	}
+	u := t.drive()
+	if u == nil {
+		t.done(TMILC)
+		return
+	}
+	tape := u.tape
+	switch t.MTC & TMFUNC >> 1 {
+	case tmOffLine:
+		tape.Rewind()
+		u.offline = true
+	case tmRead:
+		rec, err := tape.ReadRecord()
+		switch err {
+		case ErrTapeMark:
+			t.done(TMEOF)
+			return
+		case ErrEndOfTape:
+			t.done(TMBTE)
+			return
+		case ErrBadRecord:
+			t.MTS |= TMCRE
+		}
+		n := len(rec)
+		if n > t.count() {
+			t.MTS |= TMRLE
+			n = t.count()
+		}
+		for i := 0; i < n; i++ {
+			if t.cma >= MEMSIZE {
+				t.done(TMNXM)
+				return
+			}
+			t.unibus.write8(t.cma, uint16(rec[i]))
+			if i&1 == 1 {
+				t.unibus.cpu.vtime += dmaTime
+			}
+			t.cma = (t.cma + 1) & 0777777
+			t.MTBRC++
+		}
+	case tmWrite, tmWriteGap:
+		rec := make([]byte, t.count())
+		for i := range rec {
+			if t.cma >= MEMSIZE {
+				t.done(TMNXM)
+				return
+			}
+			rec[i] = byte(t.unibus.read8(t.cma))
+			if i&1 == 1 {
+				t.unibus.cpu.vtime += dmaTime
+			}
+			t.cma = (t.cma + 1) & 0777777
+			t.MTBRC++
+		}
+		if err := tape.WriteRecord(rec); err != nil {
+			t.done(TMBTE | TMEOT)
+			return
+		}
+	case tmWriteEOF:
+		if err := tape.WriteMark(); err != nil {
+			t.done(TMBTE | TMEOT)
+			return
+		}
+	case tmSpaceForward, tmSpaceReverse:
+		var n int
+		var err error
+		if t.MTC&TMFUNC>>1 == tmSpaceForward {
+			n, err = tape.SpaceForward(t.count())
+		} else {
+			n, err = tape.SpaceBack(t.count())
+		}
+		t.MTBRC += uint16(n)
+		switch err {
+		case nil, ErrBOT:
+		case ErrTapeMark:
+			t.done(TMEOF)
+			return
+		default:
+			t.done(TMBTE)
+			return
+		}
+	case tmRewind:
+		tape.Rewind()
+	}
+	t.done(0)
+}
+
+// done completes the function in progress with the errors err.
+func (t *TM11) done(err uint16) {
+	t.running = false
+	t.MTS |= err
+	t.MTC &^= TMGO
+	if t.MTS&TMERRS != 0 {
+		t.MTC |= TMERR
+	}
+	t.MTC |= TMCUR
+	if t.MTC&TMIE != 0 {
+		t.unibus.cpu.interrupt(intTM, 5)
+	}
+}
+
+func (t *TM11) read16(a uint18) uint16 {
+	switch a {
+	case 0772520:
+		return t.status()
+	case 0772522:
+		return t.MTC&^TMEMA | uint16(t.cma>>12)&TMEMA
+	case 0772524:
+		return t.MTBRC
+	case 0772526:
+		return uint16(t.cma)
+	case 0772530, 0772532:
+		return 0 // data buffer, read lines
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+func (t *TM11) write16(a uint18, v uint16) {
+	switch a {
+	case 0772520:
+		// status is read only
+	case 0772522:
+		if v&TMPCLR != 0 {
+			t.reset()
+			return
+		}
+		if t.running {
+			return
+		}
+		const BITS = TMFUNC | TMIE | TMUNIT | TMDEN
+		ie := t.MTC & TMIE
+		t.MTC = t.MTC&^BITS | v&BITS
+		t.cma = t.cma&0177777 | uint18(v&TMEMA)<<12
+		switch {
+		case v&TMGO != 0:
+			t.MTS = 0
+			t.MTC = t.MTC&^(TMERR|TMCUR) | TMGO
+			t.running = true
+		case ie == 0 && v&TMIE != 0:
+			t.unibus.cpu.interrupt(intTM, 5)
+		}
+	case 0772524:
+		t.MTBRC = v
+	case 0772526:
+		t.cma = t.cma&^0177777 | uint18(v)
+	case 0772530, 0772532:
+		// data buffer, read lines
+	default:
+		panic(trap{intBUS, fmt.Sprintf("write to invalid address %06o", a)})
+	}
+}
+
+// Attach loads tape into drive, on line and ready.
+func (t *TM11) Attach(drive int, tape *Tape) error {
+	if err := checkDrive(drive, len(t.unit)); err != nil {
+		return err
+	}
+	t.unit[drive] = &TU10{tape: tape}
+	return nil
+}
@@ -0,0 +1,65 @@
+package pdp11
+
+import (
+	"fmt"
+	"time"
+)
+
+// The time of day register is a read only pair of words which return the
+// host time as seconds since 00:00 1 Jan 1970 GMT, the same representation
+// as the V6 kernel's time variable. Reading the high word latches the low
+// word so the pair is consistent.
+const (
+	TODHI = 0760770
+	TODLO = 0760772
+)
+
+type tod struct {
+	register bool   // answer at TODHI and TODLO
+	latch    uint16 // low word latched by reading TODHI
+
+	timeaddr uint16 // kernel virtual address of time, or zero
+}
+
+func (t *tod) read16(a uint18) uint16 {
+	switch a {
+	case TODHI:
+		now := uint32(time.Now().Unix())
+		t.latch = uint16(now)
+		return uint16(now >> 16)
+	case TODLO:
+		return t.latch
+	default:
+		panic(trap{intBUS, fmt.Sprintf("read from invalid address %06o", a)})
+	}
+}
+
+// EnableTimeRegister makes the host time of day available to the guest at
+// TODHI and TODLO.
+func (p *PDP1140) EnableTimeRegister() { p.tod.register = true }
+
+// SyncTime arranges for the kernel's time variable to be set to the host
+// time of day once the kernel has started and first enters user mode. The
+// variable is located through the namelist of kernel, the path of the
+// kernel on the V6 filesystem attached as drive 0.
+func (p *PDP1140) SyncTime(kernel string) error {
+	syms, err := p.Namelist(kernel)
+	if err != nil {
+		return fmt.Errorf("synctime: %v", err)
+	}
+	sym, ok := syms.Lookup("_time")
+	if !ok {
+		return fmt.Errorf("synctime: %s: _time not found in namelist", kernel)
+	}
+	p.tod.timeaddr = sym.Value
+	return nil
+}
+
+// synctime writes the host time of day into the kernel's time variable.
+func (p *PDP1140) synctime() {
+	now := uint32(time.Now().Unix())
+	a := p.tod.timeaddr
+	p.tod.timeaddr = 0
+	p.unibus.write16(p.mmu.decode(a, false, false), uint16(now>>16))
+	p.unibus.write16(p.mmu.decode(a+2, false, false), uint16(now))
+}
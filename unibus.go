@@ -6,16 +6,25 @@ const MEMSIZE = 0760000
 
 type unibus struct {
 	Memory [MEMSIZE >> 1]uint16
-	LKS    uint16
 	cpu    *cpu
 	rk     RK11 // drive 0
+	rl     RL11
+	rp     RH11
+	tm     TM11
+	rx     RX11
 	cons   Console
+	clock  KW11L
+	kwp    KW11P
+	tod    tod
+
+	blocks [MEMSIZE >> 6]*block // decoded instructions, see blockcache.go
 }
 
 // uint18 represents a unibus 18 bit physical address
 type uint18 uint32
 
 func (u *unibus) Reset() {
+	u.flush()
 	for i := uint18(0); int(i) < len(u.Memory); i++ {
 		u.write16(i, 0)
 	}
@@ -28,7 +37,7 @@ func (u *unibus) read16(a uint18) uint16 {
 	case a < MEMSIZE:
 		return u.Memory[a>>1]
 	case a == 0777546:
-		return u.LKS
+		return u.clock.LKS
 	case a == 0777570:
 		return 0173030
 	case a == 0777572:
@@ -41,6 +50,18 @@ func (u *unibus) read16(a uint18) uint16 {
 		return uint16(u.cons.consread16(a))
 	case a&0777760 == 0777400:
 		return u.rk.read16(a)
+	case a&0777760 == 0774400:
+		return u.rl.read16(a)
+	case a&0777700 == 0776700:
+		return u.rp.read16(a)
+	case a&0777760 == 0772520:
+		return u.tm.read16(a)
+	case a&0777774 == 0777170:
+		return u.rx.read16(a)
+	case a&0777770 == 0772540:
+		return u.kwp.read16(a)
+	case u.tod.register && (a == TODHI || a == TODLO):
+		return u.tod.read16(a)
 	case a&0777600 == 0772200 || (a&0777600) == 0777600:
 		return u.cpu.mmu.read16(a)
 	case a == 0776000:
@@ -60,6 +81,9 @@ func (u *unibus) read8(a uint18) uint16 {
 
 func (u *unibus) write8(a uint18, v uint16) {
 	if a < MEMSIZE {
+		if u.blocks[a>>6] != nil {
+			u.invalidate(a)
+		}
 		if a&1 == 1 {
 			u.Memory[a>>1] &= 0xFF
 			u.Memory[a>>1] |= v & 0xFF << 8
@@ -81,6 +105,9 @@ func (u *unibus) write16(a uint18, v uint16) {
 		panic(trap{intBUS, fmt.Sprintf("write to odd address %06o", a)})
 	}
 	if a < MEMSIZE {
+		if u.blocks[a>>6] != nil {
+			u.invalidate(a)
+		}
 		u.Memory[a>>1] = v
 	} else if a == 0777776 {
 		switch v >> 14 {
@@ -105,13 +132,25 @@ func (u *unibus) write16(a uint18, v uint16) {
 		}
 		u.cpu.PS = psw(v)
 	} else if a == 0777546 {
-		u.LKS = v
+		u.clock.LKS = v
 	} else if a == 0777572 {
-		u.cpu.mmu.SR0 = v
+		u.cpu.mmu.writeSR0(v)
 	} else if (a & 0777770) == 0777560 {
 		u.cons.conswrite16(a, int(v))
 	} else if (a & 0777700) == 0777400 {
 		u.rk.write16(a, v)
+	} else if (a & 0777760) == 0774400 {
+		u.rl.write16(a, v)
+	} else if (a & 0777700) == 0776700 {
+		u.rp.write16(a, v)
+	} else if (a & 0777760) == 0772520 {
+		u.tm.write16(a, v)
+	} else if (a & 0777774) == 0777170 {
+		u.rx.write16(a, v)
+	} else if (a & 0777770) == 0772540 {
+		u.kwp.write16(a, v)
+	} else if u.tod.register && (a == TODHI || a == TODLO) {
+		// read only
 	} else if (a&0777600) == 0772200 || (a&0777600) == 0777600 {
 		u.cpu.mmu.write16(a, v)
 	} else {
@@ -1,17 +1,137 @@
 package main
 
 import (
+	"bytes"
+	"flag"
+	"fmt"
 	"go/build"
+	"io/ioutil"
 	"log"
 	"os"
 	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/davecheney/pdp11"
 )
 
-func stdin(c chan uint8) {
-	for _, v := range []byte("unix\n") {
-		c <- v
+var (
+	clock = flag.String("clock", "instr", "line clock source: instr, virtual or wall")
+	hz    = flag.Int("hz", 60, "line clock frequency")
+
+	synctime = flag.Bool("synctime", false, "set the kernel's time of day from the host at boot")
+	kernel   = flag.String("kernel", "unix", "path of the kernel on drive 0, for -synctime and -profile")
+	speed    = flag.Float64("speed", 0, "run at this multiple of the speed of a real 11/40, 0 is unthrottled")
+	timereg  = flag.Bool("timereg", false, "answer the time of day register with the host time")
+
+	profile = flag.String("profile", "", "profile the guest, writing a flat profile to stderr and a pprof profile to this file on exit")
+	program = flag.String("program", "", "path of the a.out on drive 0 used to symbolize user mode addresses in the profile")
+
+	metrics = flag.String("metrics", "", "serve statistics in the Prometheus format at /metrics on this address")
+
+	lda  = flag.String("lda", "", "run this paper tape image in absolute loader format instead of booting UNIX")
+	boot = flag.String("boot", "rk", "boot from drive 0 of the rk or rp controller")
+
+	attach drives
+	tape   = flag.String("tape", "", "load this SIMH .tap image into TM11 drive 0; records written by the guest are not saved")
+)
+
+func init() {
+	flag.Var(&attach, "attach", "attach the disk image `type:unit=path`, such as rl02:0=v7.dsk, in addition to rk0; may be repeated")
+}
+
+// drives are the images given with -attach.
+type drives []string
+
+func (d *drives) String() string { return strings.Join(*d, " ") }
+
+func (d *drives) Set(s string) error {
+	*d = append(*d, s)
+	return nil
+}
+
+var driveTypes = map[string]pdp11.DriveType{
+	"rk05": pdp11.DriveRK05,
+	"rl01": pdp11.DriveRL01,
+	"rl02": pdp11.DriveRL02,
+	"rp04": pdp11.DriveRP04,
+	"rp06": pdp11.DriveRP06,
+	"rx01": pdp11.DriveRX01,
+}
+
+// attachDrive attaches the image described by spec, of the form
+// type:unit=path. The image is read into memory, so the guest's writes
+// do not change the file.
+func attachDrive(pdp *pdp11.PDP1140, spec string) error {
+	drive, path := spec, ""
+	if i := strings.Index(spec, "="); i >= 0 {
+		drive, path = spec[:i], spec[i+1:]
+	}
+	var typ, unit string
+	if i := strings.Index(drive, ":"); i >= 0 {
+		typ, unit = drive[:i], drive[i+1:]
+	}
+	t, ok := driveTypes[typ]
+	n, err := strconv.Atoi(unit)
+	if !ok || err != nil || path == "" {
+		return fmt.Errorf("bad -attach %q, want type:unit=path", spec)
+	}
+	buf, err := ioutil.ReadFile(path)
+	if err != nil {
+		return err
+	}
+	if err := pdp.AttachDrive(t, n, bytes.NewReader(buf), int64(len(buf))); err != nil {
+		return fmt.Errorf("%s: %v", path, err)
+	}
+	return nil
+}
+
+// profiler writes the guest profile on exit.
+type profiler struct {
+	kernel, user pdp11.Symtab
+}
+
+func (p *profiler) start(pdp *pdp11.PDP1140) {
+	var err error
+	if p.kernel, err = pdp.Namelist(*kernel); err != nil {
+		log.Fatal(err)
+	}
+	if *program != "" {
+		if p.user, err = pdp.Namelist(*program); err != nil {
+			log.Fatal(err)
+		}
+	}
+	pdp.StartProfile()
+}
+
+func (p *profiler) stop(pdp *pdp11.PDP1140) {
+	prof := pdp.StopProfile()
+	if err := prof.WriteFlat(os.Stderr, p.kernel, p.user); err != nil {
+		log.Fatal(err)
+	}
+	f, err := os.Create(*profile)
+	if err != nil {
+		log.Fatal(err)
+	}
+	if err := prof.WritePprof(f, p.kernel, p.user); err != nil {
+		log.Fatal(err)
+	}
+	if err := f.Close(); err != nil {
+		log.Fatal(err)
+	}
+}
+
+var prof profiler
+
+// stdin sends standard input to the console. When it cannot be read the
+// machine is stopped, and the error sent to errc.
+func stdin(pdp *pdp11.PDP1140, errc chan<- error) {
+	c := pdp.Input
+	if *lda == "" {
+		for _, v := range []byte("unix\n") {
+			c <- v
+		}
 	}
 	var b [1]byte
 	for {
@@ -20,17 +140,94 @@ func stdin(c chan uint8) {
 			c <- b[0]
 		}
 		if err != nil {
-			log.Println("total cpu time:", pdp11.Runtime)
-			log.Fatal(err)
+			errc <- err
+			pdp.Stop()
+			return
 		}
 	}
 }
 
+// loadTape loads the absolute loader tape image in path.
+func loadTape(pdp *pdp11.PDP1140, path string) {
+	f, err := os.Open(path)
+	if err != nil {
+		log.Fatal(err)
+	}
+	defer f.Close()
+	start, err := pdp.LoadAbsolute(f)
+	if err != nil {
+		log.Fatalf("%s: %v", path, err)
+	}
+	if start&1 != 0 {
+		log.Fatalf("%s: tape is not self starting", path)
+	}
+}
+
+var clocks = map[string]pdp11.ClockSource{
+	"instr":   pdp11.InstrClock,
+	"virtual": pdp11.VirtualClock,
+	"wall":    pdp11.WallClock,
+}
+
 func main() {
+	flag.Parse()
+	src, ok := clocks[*clock]
+	if !ok {
+		log.Fatalf("unknown clock source %q", *clock)
+	}
 	pdp := pdp11.New()
-	pdp.LoadMemory(pdp11.BOOTRK05)
-	pdp.SetPC(002002)
+	if err := pdp.SetClock(src, *hz); err != nil {
+		log.Fatal(err)
+	}
+	pdp.SetSpeed(*speed)
+	if *lda != "" {
+		loadTape(pdp, *lda)
+	} else {
+		switch *boot {
+		case "rk":
+			pdp.LoadMemory(pdp11.BOOTRK05)
+		case "rp":
+			pdp.LoadMemory(pdp11.BOOTRP)
+		default:
+			log.Fatalf("unknown boot device %q", *boot)
+		}
+		pdp.SetPC(002002)
+	}
 	pdp.Attach(0, filepath.Join(build.Default.GOPATH, "src/github.com/davecheney/pdp11/rk0"))
-	go stdin(pdp.Input)
+	for _, spec := range attach {
+		if err := attachDrive(pdp, spec); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *tape != "" {
+		buf, err := ioutil.ReadFile(*tape)
+		if err != nil {
+			log.Fatal(err)
+		}
+		if err := pdp.AttachTape(0, pdp11.NewTape(buf)); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *timereg {
+		pdp.EnableTimeRegister()
+	}
+	if *synctime {
+		if err := pdp.SyncTime(*kernel); err != nil {
+			log.Fatal(err)
+		}
+	}
+	if *profile != "" {
+		prof.start(pdp)
+	}
+	if *metrics != "" {
+		go serveMetrics(*metrics, pdp)
+	}
+	errc := make(chan error, 1)
+	go stdin(pdp, errc)
 	pdp.Run()
+	log.Println("total cpu time:", time.Duration(pdp.Cycles())*pdp11.CycleTime)
+	if *profile != "" {
+		prof.stop(pdp)
+	}
+	log.Fatal(<-errc)
 }
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"log"
+	"net/http"
+	"sort"
+
+	"github.com/davecheney/pdp11"
+)
+
+// serveMetrics serves the machine's statistics in the Prometheus text
+// exposition format at /metrics on addr.
+func serveMetrics(addr string, pdp *pdp11.PDP1140) {
+	http.HandleFunc("/metrics", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+		writeMetrics(w, pdp.Stats())
+	})
+	log.Fatal(http.ListenAndServe(addr, nil))
+}
+
+func writeMetrics(w io.Writer, s pdp11.Stats) {
+	counter := func(name, help string) {
+		fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s counter\n", name, help, name)
+	}
+	counter("pdp11_instructions_total", "Instructions executed.")
+	fmt.Fprintf(w, "pdp11_instructions_total{mode=\"kernel\"} %d\n", s.KernelInstructions)
+	fmt.Fprintf(w, "pdp11_instructions_total{mode=\"user\"} %d\n", s.UserInstructions)
+
+	vectors := func(name, help string, counts map[int]uint64) {
+		counter(name, help)
+		var vecs []int
+		for v := range counts {
+			vecs = append(vecs, v)
+		}
+		sort.Ints(vecs)
+		for _, v := range vecs {
+			fmt.Fprintf(w, "%s{vector=\"%03o\"} %d\n", name, v, counts[v])
+		}
+	}
+	vectors("pdp11_interrupts_total", "Interrupts taken, by vector.", s.Interrupts)
+	vectors("pdp11_traps_total", "Traps taken, by vector.", s.Traps)
+
+	counter("pdp11_mmu_faults_total", "KT11 memory management aborts.")
+	fmt.Fprintf(w, "pdp11_mmu_faults_total %d\n", s.MMUFaults)
+
+	counter("pdp11_rk_sectors_total", "RK11 sectors transferred.")
+	fmt.Fprintf(w, "pdp11_rk_sectors_total{op=\"read\"} %d\n", s.SectorsRead)
+	fmt.Fprintf(w, "pdp11_rk_sectors_total{op=\"write\"} %d\n", s.SectorsWritten)
+
+	counter("pdp11_console_characters_total", "Characters transferred by the console.")
+	fmt.Fprintf(w, "pdp11_console_characters_total{direction=\"in\"} %d\n", s.ConsoleIn)
+	fmt.Fprintf(w, "pdp11_console_characters_total{direction=\"out\"} %d\n", s.ConsoleOut)
+
+	counter("pdp11_idle_seconds_total", "Wall clock time spent waiting for an interrupt.")
+	fmt.Fprintf(w, "pdp11_idle_seconds_total %g\n", s.Idle.Seconds())
+}
@@ -0,0 +1,326 @@
+package v6fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"path"
+)
+
+// Report is the result of Check.
+type Report struct {
+	Problems []string // inconsistencies found, in the order found
+
+	Files, Dirs int // allocated inodes
+	FreeInodes  int
+	Used        int // data blocks in use, including indirect blocks
+	Free        int // data blocks on the free list
+	Missing     int // data blocks neither in use nor free
+
+	Repaired bool // the problems have been repaired
+}
+
+// OK reports whether the filesystem is consistent.
+func (r *Report) OK() bool { return len(r.Problems) == 0 }
+
+func (r *Report) problem(format string, args ...interface{}) {
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+// checker holds the state of Check.
+type checker struct {
+	*FS
+	r      *Report
+	inodes []*Inode // allocated inodes, by number
+	clear  []bool   // inodes to clear on repair
+	owner  []int    // the inode using each block, or -1 if free
+	refs   []int    // directory entries referring to each inode
+	names  []string // the first path found for each inode
+	fix    []fixup
+}
+
+// fixup is an entry at offset off in directory dir which refers to an
+// inode which is not allocated, and which is removed on repair.
+type fixup struct {
+	dir *Inode
+	off int
+}
+
+// Check checks the consistency of the filesystem, as fsck does: that
+// every block is either in use by exactly one file or on the free list,
+// that every directory entry refers to an allocated inode, that link
+// counts match the directory entries, and that every file can be
+// reached from the root. It returns an error only if the disk cannot be
+// read.
+//
+// If repair is set, problems are fixed by removing directory entries for
+// unallocated inodes, clearing inodes which use bad block numbers or
+// which cannot be reached, correcting link counts, and rebuilding the
+// free list from the blocks which are not in use. Blocks used by two
+// files are reported but not repaired.
+func (f *FS) Check(repair bool) (*Report, error) {
+	n := int(f.sb.Isize) * 16
+	c := &checker{
+		FS:     f,
+		r:      &Report{},
+		inodes: make([]*Inode, n+1),
+		clear:  make([]bool, n+1),
+		owner:  make([]int, f.sb.Fsize),
+		refs:   make([]int, n+1),
+		names:  make([]string, n+1),
+	}
+	if err := c.checkInodes(); err != nil {
+		return nil, err
+	}
+	if err := c.checkFree(); err != nil {
+		return nil, err
+	}
+	if err := c.checkDirs(); err != nil {
+		return nil, err
+	}
+	c.checkLinks()
+	if repair && !c.r.OK() {
+		if err := c.repair(); err != nil {
+			return c.r, err
+		}
+		c.r.Repaired = true
+	}
+	return c.r, nil
+}
+
+// name returns the path of inode ino, for messages.
+func (c *checker) name(ino int) string {
+	if c.names[ino] != "" {
+		return c.names[ino]
+	}
+	return fmt.Sprintf("inode %d", ino)
+}
+
+// checkInodes records the blocks used by each allocated inode.
+func (c *checker) checkInodes() error {
+	r := c.r
+	for ino := 1; ino < len(c.inodes); ino++ {
+		ip, err := c.inode(ino)
+		if err != nil {
+			return err
+		}
+		if ip.Mode&IALLOC == 0 {
+			r.FreeInodes++
+			continue
+		}
+		c.inodes[ino] = ip
+		if ip.IsDir() {
+			r.Dirs++
+		} else {
+			r.Files++
+		}
+		if !ip.isRegular() && !ip.IsDir() {
+			continue // devices have no blocks
+		}
+		data, ind, err := c.blocks(ip)
+		if err != nil {
+			r.problem("inode %d: %v", ino, err)
+			c.clear[ino] = true
+			continue
+		}
+		for _, b := range append(data, ind...) {
+			switch {
+			case b == 0:
+			case !c.isData(b):
+				r.problem("inode %d: bad block %d", ino, b)
+				c.clear[ino] = true
+			case c.owner[b] != 0:
+				r.problem("block %d: used by inodes %d and %d", b, c.owner[b], ino)
+			default:
+				c.owner[b] = ino
+				r.Used++
+			}
+		}
+	}
+	return nil
+}
+
+// checkFree follows the free list.
+func (c *checker) checkFree() error {
+	r := c.r
+	nfree, free := int(c.sb.Nfree), c.sb.Free
+	for i := 0; nfree > 0; i++ {
+		if i > int(c.sb.Fsize) {
+			r.problem("free list: too long")
+			break
+		}
+		nfree--
+		b := free[nfree]
+		if b == 0 {
+			break
+		}
+		switch {
+		case !c.isData(b):
+			r.problem("free list: bad block %d", b)
+			nfree = 0
+			continue
+		case c.owner[b] > 0:
+			r.problem("block %d: used by inode %d and free", b, c.owner[b])
+		case c.owner[b] < 0:
+			r.problem("block %d: free twice", b)
+		default:
+			c.owner[b] = -1
+			r.Free++
+		}
+		if nfree == 0 {
+			buf, err := c.readBlock(b)
+			if err != nil {
+				return err
+			}
+			if nfree = int(int16(binary.LittleEndian.Uint16(buf))); nfree < 0 || nfree > 100 {
+				r.problem("free list: bad count %d in block %d", nfree, b)
+				break
+			}
+			for j := range free {
+				free[j] = binary.LittleEndian.Uint16(buf[2+2*j:])
+			}
+		}
+	}
+	for b := int(c.sb.Isize) + 2; b < len(c.owner); b++ {
+		if c.owner[b] == 0 {
+			r.Missing++
+		}
+	}
+	if r.Missing > 0 {
+		r.problem("%d blocks missing", r.Missing)
+	}
+	for _, ino := range c.sb.Inode[:c.sb.Ninode] {
+		if int(ino) < 1 || int(ino) >= len(c.inodes) {
+			r.problem("free inode list: bad inode %d", ino)
+		}
+	}
+	return nil
+}
+
+// checkDirs walks the tree from the root, counting the references to
+// each inode.
+func (c *checker) checkDirs() error {
+	r := c.r
+	if root := c.inodes[rootIno]; root == nil || !root.IsDir() {
+		r.problem("root is not a directory")
+		return nil
+	}
+	c.names[rootIno] = "/"
+	type visit struct{ ino, parent int }
+	queue := []visit{{rootIno, rootIno}}
+	seen := map[int]bool{rootIno: true}
+	for len(queue) > 0 {
+		v := queue[0]
+		queue = queue[1:]
+		dp := c.inodes[v.ino]
+		if c.clear[v.ino] {
+			continue
+		}
+		buf, err := c.read(dp)
+		if err != nil {
+			return err
+		}
+		for off := 0; off+direntSize <= len(buf); off += direntSize {
+			ino := int(binary.LittleEndian.Uint16(buf[off:]))
+			if ino == 0 {
+				continue
+			}
+			name := cstring(buf[off+2 : off+direntSize])
+			p := path.Join(c.names[v.ino], name)
+			if ino >= len(c.inodes) || c.inodes[ino] == nil || c.clear[ino] {
+				r.problem("%s: entry for unallocated or bad inode %d", p, ino)
+				c.fix = append(c.fix, fixup{dp, off})
+				continue
+			}
+			c.refs[ino]++
+			switch {
+			case name == ".":
+				if ino != v.ino {
+					r.problem("%s: refers to inode %d, not %d", p, ino, v.ino)
+				}
+			case name == "..":
+				if ino != v.parent {
+					r.problem("%s: refers to inode %d, not %d", p, ino, v.parent)
+				}
+			default:
+				if c.names[ino] == "" {
+					c.names[ino] = p
+				}
+				if c.inodes[ino].IsDir() && !seen[ino] {
+					seen[ino] = true
+					queue = append(queue, visit{ino, v.ino})
+				}
+			}
+		}
+	}
+	return nil
+}
+
+// cstring returns the NUL terminated string in b.
+func cstring(b []byte) string {
+	for i, c := range b {
+		if c == 0 {
+			return string(b[:i])
+		}
+	}
+	return string(b)
+}
+
+// checkLinks compares the link counts with the references found.
+func (c *checker) checkLinks() {
+	for ino, ip := range c.inodes {
+		switch {
+		case ip == nil || c.clear[ino]:
+		case c.refs[ino] == 0:
+			c.r.problem("inode %d: not in any directory", ino)
+			c.clear[ino] = true
+		case c.refs[ino] != int(ip.Nlink):
+			c.r.problem("%s: link count %d, should be %d", c.name(ino), ip.Nlink, c.refs[ino])
+		}
+	}
+}
+
+// repair fixes the problems found.
+func (c *checker) repair() error {
+	for _, fx := range c.fix {
+		data, _, err := c.blocks(fx.dir)
+		if err != nil {
+			return err
+		}
+		b := data[fx.off/BlockSize]
+		buf, err := c.data(b)
+		if err != nil {
+			return err
+		}
+		binary.LittleEndian.PutUint16(buf[fx.off%BlockSize:], 0)
+		if err := c.writeBlock(b, buf); err != nil {
+			return err
+		}
+	}
+	for ino, ip := range c.inodes {
+		switch {
+		case ip == nil:
+		case c.clear[ino]:
+			if err := c.writeInode(&Inode{Ino: ino}); err != nil {
+				return err
+			}
+		case c.refs[ino] != int(ip.Nlink):
+			ip.Nlink = uint8(c.refs[ino])
+			if err := c.writeInode(ip); err != nil {
+				return err
+			}
+		}
+	}
+	// rebuild the free list from the blocks not used by the inodes
+	// which remain, freeing the lowest last so it is allocated first.
+	c.sb.Nfree = 0
+	for b := len(c.owner) - 1; b >= int(c.sb.Isize)+2; b-- {
+		if o := c.owner[b]; o > 0 && !c.clear[o] {
+			continue
+		}
+		if err := c.free(uint16(b)); err != nil {
+			return err
+		}
+	}
+	c.sb.Ninode = 0
+	return c.sync()
+}
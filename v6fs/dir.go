@@ -0,0 +1,303 @@
+package v6fs
+
+import (
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"path"
+	"strings"
+	"time"
+)
+
+// rootIno is the inode of the root directory.
+const rootIno = 1
+
+// A directory is a file of 16 byte entries, each an inode number, 0 if
+// the entry is unused, and a name of up to 14 bytes padded with NULs.
+const (
+	direntSize = 16
+	nameLen    = 14
+)
+
+var (
+	errNotDir   = errors.New("not a directory")
+	errIsDir    = errors.New("is a directory")
+	errNotEmpty = errors.New("directory not empty")
+)
+
+type dirent struct {
+	ino  int
+	name string
+}
+
+// entries returns the entries of directory dp in use.
+func (f *FS) entries(dp *Inode) ([]dirent, error) {
+	buf, err := f.read(dp)
+	if err != nil {
+		return nil, err
+	}
+	var des []dirent
+	for i := 0; i+direntSize <= len(buf); i += direntSize {
+		if ino := int(binary.LittleEndian.Uint16(buf[i:])); ino != 0 {
+			des = append(des, dirent{ino, strings.TrimRight(string(buf[i+2:i+direntSize]), "\x00")})
+		}
+	}
+	return des, nil
+}
+
+// lookup returns the inode called name in directory dp.
+func (f *FS) lookup(dp *Inode, name string) (*Inode, error) {
+	if !dp.IsDir() {
+		return nil, errNotDir
+	}
+	des, err := f.entries(dp)
+	if err != nil {
+		return nil, err
+	}
+	for _, de := range des {
+		if de.name == name {
+			return f.inode(de.ino)
+		}
+	}
+	return nil, fs.ErrNotExist
+}
+
+// namei returns the inode called name, for the operation op.
+func (f *FS) namei(op, name string) (*Inode, error) {
+	if !fs.ValidPath(name) {
+		return nil, &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	ip, err := f.inode(rootIno)
+	if err != nil {
+		return nil, &fs.PathError{Op: op, Path: name, Err: err}
+	}
+	if name == "." {
+		return ip, nil
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if ip, err = f.lookup(ip, elem); err != nil {
+			return nil, &fs.PathError{Op: op, Path: name, Err: err}
+		}
+	}
+	return ip, nil
+}
+
+// parent returns the directory which holds, or is to hold, name, and the
+// last element of name.
+func (f *FS) parent(op, name string) (*Inode, string, error) {
+	if !fs.ValidPath(name) || name == "." {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: fs.ErrInvalid}
+	}
+	dir, base := path.Split(name)
+	if dir == "" {
+		dir = "."
+	}
+	dp, err := f.namei(op, strings.TrimSuffix(dir, "/"))
+	if err != nil {
+		return nil, "", err
+	}
+	if !dp.IsDir() {
+		return nil, "", &fs.PathError{Op: op, Path: name, Err: errNotDir}
+	}
+	return dp, base, nil
+}
+
+// link adds an entry for inode ino called name to directory dp.
+func (f *FS) link(dp *Inode, name string, ino int) error {
+	buf, err := f.read(dp)
+	if err != nil {
+		return err
+	}
+	i := 0
+	for i+direntSize <= len(buf) && binary.LittleEndian.Uint16(buf[i:]) != 0 {
+		i += direntSize
+	}
+	if i+direntSize > len(buf) {
+		buf = append(buf[:i], make([]byte, direntSize)...)
+	}
+	de := buf[i : i+direntSize]
+	binary.LittleEndian.PutUint16(de, uint16(ino))
+	copy(de[2:], make([]byte, nameLen))
+	copy(de[2:], name)
+	return f.write(dp, buf)
+}
+
+// unlink clears the entry called name in directory dp.
+func (f *FS) unlink(dp *Inode, name string) error {
+	buf, err := f.read(dp)
+	if err != nil {
+		return err
+	}
+	for i := 0; i+direntSize <= len(buf); i += direntSize {
+		if binary.LittleEndian.Uint16(buf[i:]) != 0 && strings.TrimRight(string(buf[i+2:i+direntSize]), "\x00") == name {
+			binary.LittleEndian.PutUint16(buf[i:], 0)
+			return f.write(dp, buf)
+		}
+	}
+	return fs.ErrNotExist
+}
+
+// v6mode returns the V6 permissions corresponding to perm.
+func v6mode(perm fs.FileMode) uint16 {
+	m := uint16(perm.Perm())
+	if perm&fs.ModeSetuid != 0 {
+		m |= ISUID
+	}
+	if perm&fs.ModeSetgid != 0 {
+		m |= ISGID
+	}
+	if perm&fs.ModeSticky != 0 {
+		m |= ISVTX
+	}
+	return m
+}
+
+// create makes a new inode with the given mode, called name in directory
+// dp.
+func (f *FS) create(dp *Inode, name string, mode uint16) (*Inode, error) {
+	if len(name) > nameLen || name == "." || name == ".." {
+		return nil, fs.ErrInvalid
+	}
+	ip, err := f.ialloc()
+	if err != nil {
+		return nil, err
+	}
+	now := time.Now()
+	ip.Mode = IALLOC | mode
+	ip.Nlink = 1
+	ip.Atime, ip.Mtime = now, now
+	if err := f.writeInode(ip); err != nil {
+		return nil, err
+	}
+	if err := f.link(dp, name, ip.Ino); err != nil {
+		ip.Mode = 0
+		f.writeInode(ip)
+		f.ifree(ip.Ino)
+		return nil, err
+	}
+	return ip, nil
+}
+
+// WriteFile writes data to the file called name, creating it with the
+// permissions perm if it does not exist, and truncating it if it does.
+func (f *FS) WriteFile(name string, data []byte, perm fs.FileMode) error {
+	err := f.writeFile(name, data, perm)
+	if serr := f.sync(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (f *FS) writeFile(name string, data []byte, perm fs.FileMode) error {
+	dp, base, err := f.parent("write", name)
+	if err != nil {
+		return err
+	}
+	ip, err := f.lookup(dp, base)
+	switch {
+	case errors.Is(err, fs.ErrNotExist):
+		ip, err = f.create(dp, base, v6mode(perm))
+	case err == nil && ip.IsDir():
+		err = errIsDir
+	case err == nil && !ip.isRegular():
+		err = fs.ErrInvalid
+	}
+	if err == nil {
+		err = f.write(ip, data)
+	}
+	if err != nil {
+		return &fs.PathError{Op: "write", Path: name, Err: err}
+	}
+	return nil
+}
+
+// Mkdir creates a directory called name with the permissions perm.
+func (f *FS) Mkdir(name string, perm fs.FileMode) error {
+	err := f.mkdir(name, perm)
+	if serr := f.sync(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (f *FS) mkdir(name string, perm fs.FileMode) error {
+	dp, base, err := f.parent("mkdir", name)
+	if err != nil {
+		return err
+	}
+	if _, err := f.lookup(dp, base); err == nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: fs.ErrExist}
+	}
+	ip, err := f.create(dp, base, IFDIR|v6mode(perm))
+	if err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	// the directory is linked from its parent and from "."; the parent
+	// gains a link from "..".
+	ip.Nlink = 2
+	buf := make([]byte, 2*direntSize)
+	binary.LittleEndian.PutUint16(buf, uint16(ip.Ino))
+	copy(buf[2:], ".")
+	binary.LittleEndian.PutUint16(buf[direntSize:], uint16(dp.Ino))
+	copy(buf[direntSize+2:], "..")
+	if err := f.write(ip, buf); err != nil {
+		return &fs.PathError{Op: "mkdir", Path: name, Err: err}
+	}
+	dp.Nlink++
+	return f.writeInode(dp)
+}
+
+// Remove removes the file or empty directory called name. The inode of
+// a file is freed when its last link is removed.
+func (f *FS) Remove(name string) error {
+	err := f.remove(name)
+	if serr := f.sync(); err == nil {
+		err = serr
+	}
+	return err
+}
+
+func (f *FS) remove(name string) error {
+	dp, base, err := f.parent("remove", name)
+	if err != nil {
+		return err
+	}
+	if base == ".." {
+		return &fs.PathError{Op: "remove", Path: name, Err: fs.ErrInvalid}
+	}
+	ip, err := f.lookup(dp, base)
+	if err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if ip.IsDir() {
+		des, err := f.entries(ip)
+		if err != nil {
+			return &fs.PathError{Op: "remove", Path: name, Err: err}
+		}
+		for _, de := range des {
+			if de.name != "." && de.name != ".." {
+				return &fs.PathError{Op: "remove", Path: name, Err: errNotEmpty}
+			}
+		}
+		ip.Nlink = 1 // the link from "." goes with it
+		dp.Nlink--
+		if err := f.writeInode(dp); err != nil {
+			return err
+		}
+	}
+	if err := f.unlink(dp, base); err != nil {
+		return &fs.PathError{Op: "remove", Path: name, Err: err}
+	}
+	if ip.Nlink--; ip.Nlink > 0 {
+		return f.writeInode(ip)
+	}
+	if err := f.truncate(ip); err != nil {
+		return err
+	}
+	*ip = Inode{Ino: ip.Ino}
+	if err := f.writeInode(ip); err != nil {
+		return err
+	}
+	f.ifree(ip.Ino)
+	return nil
+}
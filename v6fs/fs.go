@@ -0,0 +1,244 @@
+// Package v6fs reads and writes UNIX V6 filesystems, such as the one on
+// the RK05 image rk0.
+//
+// A filesystem is a sequence of 512 byte blocks. Block 0 holds the boot
+// program and block 1 the superblock, which records the size of the
+// filesystem and caches up to 100 free blocks and 100 free inodes. The
+// inodes follow, 16 to a block, numbered from 1, the root directory. The
+// remaining blocks hold data or are on the free list, a chain of blocks
+// each listing up to 100 more free blocks.
+//
+// FS implements io/fs.FS. Names are slash separated and unrooted, as
+// io/fs requires, so the root is "." and /bin/ls is "bin/ls".
+//
+// Changes are written through to the disk as they are made. A
+// filesystem must not be changed while a running guest has it mounted,
+// as the guest caches the superblock and inodes.
+package v6fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"io"
+	"time"
+)
+
+// BlockSize is the size of a block, in bytes.
+const BlockSize = 512
+
+// Disk holds a filesystem, such as an *os.File or an Image.
+type Disk interface {
+	io.ReaderAt
+	io.WriterAt
+}
+
+// Image is a disk image held in memory.
+type Image []byte
+
+func (m Image) ReadAt(b []byte, off int64) (int, error) {
+	if off < 0 || off >= int64(len(m)) {
+		return 0, io.EOF
+	}
+	n := copy(b, m[off:])
+	if n < len(b) {
+		return n, io.EOF
+	}
+	return n, nil
+}
+
+func (m Image) WriteAt(b []byte, off int64) (int, error) {
+	if off < 0 || off+int64(len(b)) > int64(len(m)) {
+		return 0, errors.New("v6fs: write beyond end of image")
+	}
+	return copy(m[off:], b), nil
+}
+
+var (
+	ErrNoSpace  = errors.New("v6fs: no space left on device")
+	ErrNoInodes = errors.New("v6fs: out of inodes")
+)
+
+// superblock is block 1 of a filesystem.
+type superblock struct {
+	Isize  uint16 // blocks of inodes
+	Fsize  uint16 // blocks in the filesystem
+	Nfree  int16
+	Free   [100]uint16
+	Ninode int16
+	Inode  [100]uint16
+	Flock  uint8
+	Ilock  uint8
+	Fmod   uint8
+	Ronly  uint8
+	Time   [2]uint16 // high word first
+	Pad    [48]uint16
+}
+
+// FS is a V6 filesystem.
+type FS struct {
+	d  Disk
+	sb superblock
+}
+
+// Mount returns the filesystem on d.
+func Mount(d Disk) (*FS, error) {
+	f := &FS{d: d}
+	b, err := f.readBlock(1)
+	if err != nil {
+		return nil, err
+	}
+	if err := binary.Read(bytes.NewReader(b), binary.LittleEndian, &f.sb); err != nil {
+		return nil, err
+	}
+	sb := &f.sb
+	switch {
+	case sb.Isize == 0 || sb.Fsize <= sb.Isize+2:
+		return nil, fmt.Errorf("v6fs: bad superblock: isize %d, fsize %d", sb.Isize, sb.Fsize)
+	case sb.Nfree < 0 || sb.Nfree > 100 || sb.Ninode < 0 || sb.Ninode > 100:
+		return nil, fmt.Errorf("v6fs: bad superblock: nfree %d, ninode %d", sb.Nfree, sb.Ninode)
+	}
+	return f, nil
+}
+
+// Size returns the number of blocks in the filesystem, and the number
+// of inodes.
+func (f *FS) Size() (blocks, inodes int) {
+	return int(f.sb.Fsize), int(f.sb.Isize) * 16
+}
+
+// readBlock returns block n of the disk.
+func (f *FS) readBlock(n uint16) ([]byte, error) {
+	b := make([]byte, BlockSize)
+	if _, err := f.d.ReadAt(b, int64(n)*BlockSize); err != nil {
+		return nil, fmt.Errorf("v6fs: reading block %d: %v", n, err)
+	}
+	return b, nil
+}
+
+func (f *FS) writeBlock(n uint16, b []byte) error {
+	if _, err := f.d.WriteAt(b, int64(n)*BlockSize); err != nil {
+		return fmt.Errorf("v6fs: writing block %d: %v", n, err)
+	}
+	return nil
+}
+
+// isData reports whether n is the number of a data block.
+func (f *FS) isData(n uint16) bool {
+	return n >= f.sb.Isize+2 && n < f.sb.Fsize
+}
+
+// data returns data block n, checking that it is one.
+func (f *FS) data(n uint16) ([]byte, error) {
+	if !f.isData(n) {
+		return nil, fmt.Errorf("v6fs: bad block number %d", n)
+	}
+	return f.readBlock(n)
+}
+
+// sync writes the superblock, stamped with the time.
+func (f *FS) sync() error {
+	t := uint32(time.Now().Unix())
+	f.sb.Time = [2]uint16{uint16(t >> 16), uint16(t)}
+	var b bytes.Buffer
+	binary.Write(&b, binary.LittleEndian, &f.sb)
+	return f.writeBlock(1, b.Bytes())
+}
+
+// alloc allocates a zeroed data block from the free list.
+func (f *FS) alloc() (uint16, error) {
+	sb := &f.sb
+	if sb.Nfree <= 0 {
+		return 0, ErrNoSpace
+	}
+	sb.Nfree--
+	n := sb.Free[sb.Nfree]
+	if n == 0 {
+		sb.Nfree = 0
+		return 0, ErrNoSpace
+	}
+	if !f.isData(n) {
+		return 0, fmt.Errorf("v6fs: bad block %d on free list", n)
+	}
+	if sb.Nfree == 0 {
+		// n holds the next part of the list.
+		b, err := f.readBlock(n)
+		if err != nil {
+			return 0, err
+		}
+		sb.Nfree = int16(binary.LittleEndian.Uint16(b))
+		if sb.Nfree < 0 || sb.Nfree > 100 {
+			return 0, fmt.Errorf("v6fs: bad free list block %d", n)
+		}
+		for i := range sb.Free {
+			sb.Free[i] = binary.LittleEndian.Uint16(b[2+2*i:])
+		}
+	}
+	return n, f.writeBlock(n, make([]byte, BlockSize))
+}
+
+// free returns block n to the free list.
+func (f *FS) free(n uint16) error {
+	sb := &f.sb
+	if sb.Nfree <= 0 {
+		sb.Nfree = 1
+		sb.Free[0] = 0
+	}
+	if sb.Nfree >= 100 {
+		// n becomes the head of the list.
+		b := make([]byte, BlockSize)
+		binary.LittleEndian.PutUint16(b, uint16(sb.Nfree))
+		for i, v := range sb.Free {
+			binary.LittleEndian.PutUint16(b[2+2*i:], v)
+		}
+		if err := f.writeBlock(n, b); err != nil {
+			return err
+		}
+		sb.Nfree = 0
+	}
+	sb.Free[sb.Nfree] = n
+	sb.Nfree++
+	return nil
+}
+
+// ialloc allocates an inode, refilling the cache in the superblock by
+// searching the inodes if it is empty.
+func (f *FS) ialloc() (*Inode, error) {
+	sb := &f.sb
+	for {
+		if sb.Ninode <= 0 {
+			for ino := 1; ino <= int(sb.Isize)*16 && sb.Ninode < 100; ino++ {
+				ip, err := f.inode(ino)
+				if err != nil {
+					return nil, err
+				}
+				if ip.Mode&IALLOC == 0 {
+					sb.Inode[sb.Ninode] = uint16(ino)
+					sb.Ninode++
+				}
+			}
+			if sb.Ninode <= 0 {
+				return nil, ErrNoInodes
+			}
+		}
+		sb.Ninode--
+		ip, err := f.inode(int(sb.Inode[sb.Ninode]))
+		if err != nil {
+			return nil, err
+		}
+		if ip.Mode&IALLOC == 0 {
+			return &Inode{Ino: ip.Ino}, nil
+		}
+		// the cache was stale.
+	}
+}
+
+// ifree returns inode ino to the cache in the superblock, if there is
+// room.
+func (f *FS) ifree(ino int) {
+	if f.sb.Ninode < 100 {
+		f.sb.Inode[f.sb.Ninode] = uint16(ino)
+		f.sb.Ninode++
+	}
+}
@@ -0,0 +1,236 @@
+package v6fs
+
+import (
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io/fs"
+	"io/ioutil"
+	"strings"
+	"testing"
+	"testing/fstest"
+)
+
+// rk0 returns a copy of the RK05 image in the parent directory.
+func rk0(t *testing.T) Image {
+	img, err := ioutil.ReadFile("../rk0")
+	if err != nil {
+		t.Skip(err)
+	}
+	return Image(img)
+}
+
+// mkfs returns an image holding an empty filesystem of the given number
+// of blocks, with isize blocks of inodes.
+func mkfs(t *testing.T, blocks, isize int) Image {
+	img := make(Image, blocks*BlockSize)
+	sb := img[BlockSize:]
+	binary.LittleEndian.PutUint16(sb, uint16(isize))
+	binary.LittleEndian.PutUint16(sb[2:], uint16(blocks))
+	// the root directory, holding . and .., is in the first data block.
+	root := uint16(isize + 2)
+	ino := img[2*BlockSize:]
+	binary.LittleEndian.PutUint16(ino, IALLOC|IFDIR|0755)
+	ino[2] = 2
+	binary.LittleEndian.PutUint16(ino[6:], 2*direntSize)
+	binary.LittleEndian.PutUint16(ino[8:], root)
+	de := img[int(root)*BlockSize:]
+	binary.LittleEndian.PutUint16(de, rootIno)
+	copy(de[2:], ".")
+	binary.LittleEndian.PutUint16(de[direntSize:], rootIno)
+	copy(de[direntSize+2:], "..")
+	f := mount(t, img)
+	for b := blocks - 1; b > int(root); b-- {
+		if err := f.free(uint16(b)); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.sync(); err != nil {
+		t.Fatal(err)
+	}
+	return img
+}
+
+func mount(t *testing.T, img Image) *FS {
+	f, err := Mount(img)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return f
+}
+
+func TestReadFS(t *testing.T) {
+	f := mount(t, rk0(t))
+	echo, err := f.ReadFile("bin/echo")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(echo) < 16 || echo[0] != 007 || echo[1] != 001 {
+		t.Fatalf("bin/echo: got % x, want an a.out", echo[:16])
+	}
+	fi, err := f.Stat("bin")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !fi.IsDir() || fi.Mode().Perm() == 0 || fi.Sys().(*Inode).Nlink < 2 {
+		t.Errorf("bin: got mode %v, nlink %d", fi.Mode(), fi.Sys().(*Inode).Nlink)
+	}
+	if testing.Short() {
+		return
+	}
+	if err := fstest.TestFS(f, "bin/echo", "unix", "etc/passwd"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestWriteFS(t *testing.T) {
+	img := mkfs(t, 4000, 8)
+	f := mount(t, img)
+	if err := f.Mkdir("tmp", 0777); err != nil {
+		t.Fatal(err)
+	}
+	files := map[string][]byte{
+		"tmp/small":      []byte("hello, world\n"),
+		"tmp/empty":      nil,
+		"tmp/large":      bytes.Repeat([]byte("0123456789abcdef"), 9*BlockSize/16+3),
+		"tmp/huge":       bytes.Repeat([]byte{1, 2, 3}, 7*256*BlockSize/3+BlockSize),
+		"tmp/d/e/nested": []byte("nested\n"),
+	}
+	for _, name := range []string{"tmp/d", "tmp/d/e"} {
+		if err := f.Mkdir(name, 0755); err != nil {
+			t.Fatal(err)
+		}
+	}
+	for name, data := range files {
+		if err := f.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	// overwriting replaces the contents.
+	files["tmp/small"] = []byte("goodbye\n")
+	if err := f.WriteFile("tmp/small", files["tmp/small"], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// everything must be there after mounting again.
+	f = mount(t, img)
+	for name, data := range files {
+		got, err := f.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(got, data) {
+			t.Errorf("%s: read %d bytes, want %d", name, len(got), len(data))
+		}
+	}
+	if fi, _ := f.Stat("tmp/d"); fi.Sys().(*Inode).Nlink != 3 {
+		t.Errorf("tmp/d: nlink %d, want 3", fi.Sys().(*Inode).Nlink)
+	}
+
+	if err := f.Remove("tmp/d"); err == nil {
+		t.Error("removed a directory which is not empty")
+	}
+	for _, name := range []string{"tmp/d/e/nested", "tmp/d/e", "tmp/d", "tmp/huge"} {
+		if err := f.Remove(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if _, err := f.Stat("tmp/d"); !errors.Is(err, fs.ErrNotExist) {
+		t.Errorf("stat of removed directory: got %v, want %v", err, fs.ErrNotExist)
+	}
+	// the blocks of the removed file can be used again.
+	if err := f.WriteFile("tmp/huge2", files["tmp/huge"], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// a file too large for the disk is left empty, and its blocks free.
+	if err := f.WriteFile("tmp/toobig", make([]byte, 4000*BlockSize), 0644); !errors.Is(err, ErrNoSpace) {
+		t.Fatalf("got %v, want %v", err, ErrNoSpace)
+	}
+	if fi, err := f.Stat("tmp/toobig"); err != nil || fi.Size() != 0 {
+		t.Fatalf("tmp/toobig: got %v, %v, want an empty file", fi, err)
+	}
+	if err := f.WriteFile("tmp/huge3", files["tmp/huge"], 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	check(t, f)
+
+	for _, tt := range []struct {
+		name string
+		err  error
+	}{
+		{"tmp/small/x", errNotDir},
+		{"nosuchdir/x", fs.ErrNotExist},
+		{"tmp/fifteen_chars__", fs.ErrInvalid},
+		{"/tmp/x", fs.ErrInvalid},
+		{"tmp", errIsDir},
+	} {
+		if err := f.WriteFile(tt.name, nil, 0644); !errors.Is(err, tt.err) {
+			t.Errorf("WriteFile(%q): got %v, want %v", tt.name, err, tt.err)
+		}
+	}
+}
+
+// check fails the test if f is not consistent.
+func check(t *testing.T, f *FS) {
+	t.Helper()
+	r, err := f.Check(false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range r.Problems {
+		t.Error(p)
+	}
+}
+
+func TestCheck(t *testing.T) {
+	check(t, mount(t, rk0(t)))
+
+	img := mkfs(t, 400, 4)
+	f := mount(t, img)
+	for name, data := range map[string][]byte{"a": []byte("a\n"), "b": make([]byte, 3*BlockSize)} {
+		if err := f.WriteFile(name, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := f.Mkdir("d", 0755); err != nil {
+		t.Fatal(err)
+	}
+	check(t, f)
+
+	a, _ := f.Stat("a")
+	ip := a.Sys().(*Inode)
+	ip.Nlink = 5
+	f.writeInode(ip)
+	root, _ := f.inode(rootIno)
+	f.link(root, "ghost", 50)
+	f.alloc()
+	f.sync()
+	r, err := f.Check(true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"1 blocks missing",
+		"/ghost: entry for unallocated or bad inode 50",
+		"/a: link count 5, should be 1",
+	}
+	if strings.Join(r.Problems, "\n") != strings.Join(want, "\n") || !r.Repaired {
+		t.Errorf("got problems %q, want %q", r.Problems, want)
+	}
+	f = mount(t, img)
+	check(t, f)
+	if buf, err := f.ReadFile("a"); err != nil || string(buf) != "a\n" {
+		t.Errorf("a: got %q, %v after repair", buf, err)
+	}
+
+	// a block used by two files is reported.
+	b, _ := f.Stat("b")
+	ip = b.Sys().(*Inode)
+	ip.Addr[1] = ip.Addr[0]
+	f.writeInode(ip)
+	if r, err = f.Check(false); err != nil || r.OK() {
+		t.Errorf("got %v, %v, want a problem", r, err)
+	}
+}
@@ -0,0 +1,324 @@
+package v6fs
+
+import (
+	"encoding/binary"
+	"fmt"
+	"time"
+)
+
+// Inode modes
+const (
+	IALLOC = 0100000 // in use
+	IFMT   = 060000  // type of file
+	IFDIR  = 040000  // directory
+	IFCHR  = 020000  // character special
+	IFBLK  = 060000  // block special
+	ILARG  = 010000  // large file, addressed through indirect blocks
+	ISUID  = 04000   // set user id on execution
+	ISGID  = 02000   // set group id on execution
+	ISVTX  = 01000   // save text after execution
+)
+
+// inodeSize is the size of an inode on disk.
+const inodeSize = 32
+
+// maxSize is the size of the largest file, limited by the 24 bit size.
+const maxSize = 1<<24 - 1
+
+// Inode is a V6 inode.
+type Inode struct {
+	Ino      int // inode number, not stored
+	Mode     uint16
+	Nlink    uint8
+	Uid, Gid uint8
+	Size     int       // 24 bits
+	Addr     [8]uint16 // blocks, or indirect blocks of a large file
+	Atime    time.Time
+	Mtime    time.Time
+}
+
+// IsDir reports whether ip is a directory.
+func (ip *Inode) IsDir() bool { return ip.Mode&IFMT == IFDIR }
+
+// isRegular reports whether ip is an ordinary file.
+func (ip *Inode) isRegular() bool { return ip.Mode&IFMT == 0 }
+
+// iblock returns the block holding inode ino and its offset in it.
+func (f *FS) iblock(ino int) (uint16, int, error) {
+	if ino < 1 || ino > int(f.sb.Isize)*16 {
+		return 0, 0, fmt.Errorf("v6fs: bad inode number %d", ino)
+	}
+	return uint16(2 + (ino-1)/16), (ino - 1) % 16 * inodeSize, nil
+}
+
+// inode reads inode ino.
+func (f *FS) inode(ino int) (*Inode, error) {
+	n, off, err := f.iblock(ino)
+	if err != nil {
+		return nil, err
+	}
+	b, err := f.readBlock(n)
+	if err != nil {
+		return nil, err
+	}
+	b = b[off:]
+	word := func(i int) uint16 { return binary.LittleEndian.Uint16(b[i:]) }
+	long := func(i int) int64 { return int64(word(i))<<16 | int64(word(i+2)) }
+	ip := &Inode{
+		Ino:   ino,
+		Mode:  word(0),
+		Nlink: b[2],
+		Uid:   b[3],
+		Gid:   b[4],
+		Size:  int(b[5])<<16 | int(word(6)),
+		Atime: time.Unix(long(24), 0),
+		Mtime: time.Unix(long(28), 0),
+	}
+	for i := range ip.Addr {
+		ip.Addr[i] = word(8 + 2*i)
+	}
+	return ip, nil
+}
+
+// writeInode writes ip to disk.
+func (f *FS) writeInode(ip *Inode) error {
+	n, off, err := f.iblock(ip.Ino)
+	if err != nil {
+		return err
+	}
+	blk, err := f.readBlock(n)
+	if err != nil {
+		return err
+	}
+	b := blk[off:]
+	put := func(i int, v uint16) { binary.LittleEndian.PutUint16(b[i:], v) }
+	putLong := func(i int, t time.Time) {
+		v := uint32(t.Unix())
+		put(i, uint16(v>>16))
+		put(i+2, uint16(v))
+	}
+	put(0, ip.Mode)
+	b[2], b[3], b[4] = ip.Nlink, ip.Uid, ip.Gid
+	b[5] = byte(ip.Size >> 16)
+	put(6, uint16(ip.Size))
+	for i, a := range ip.Addr {
+		put(8+2*i, a)
+	}
+	putLong(24, ip.Atime)
+	putLong(28, ip.Mtime)
+	return f.writeBlock(n, blk)
+}
+
+// blocks returns the data blocks of ip in order, 0 for a hole, and the
+// indirect blocks holding their numbers.
+//
+// A small file has up to 8 blocks, listed in Addr. A large file has
+// Addr[0] to Addr[6] each naming an indirect block of 256 block
+// numbers, and Addr[7] naming a double indirect block of 256 indirect
+// blocks.
+func (f *FS) blocks(ip *Inode) (data, ind []uint16, err error) {
+	n := (ip.Size + BlockSize - 1) / BlockSize
+	if ip.Mode&ILARG == 0 {
+		if n > len(ip.Addr) {
+			return nil, nil, fmt.Errorf("v6fs: inode %d: size %d too large for a small file", ip.Ino, ip.Size)
+		}
+		return ip.Addr[:n], nil, nil
+	}
+	// indirect appends the block numbers in block a, or a hole.
+	indirect := func(list []uint16, a uint16) ([]uint16, error) {
+		if a == 0 {
+			return append(list, make([]uint16, 256)...), nil
+		}
+		ind = append(ind, a)
+		b, err := f.data(a)
+		if err != nil {
+			return nil, err
+		}
+		for i := 0; i < 256; i++ {
+			list = append(list, binary.LittleEndian.Uint16(b[2*i:]))
+		}
+		return list, nil
+	}
+	for _, a := range ip.Addr[:7] {
+		if len(data) >= n {
+			break
+		}
+		if data, err = indirect(data, a); err != nil {
+			return nil, nil, err
+		}
+	}
+	if len(data) < n && ip.Addr[7] != 0 {
+		dbl, err := indirect(nil, ip.Addr[7])
+		if err != nil {
+			return nil, nil, err
+		}
+		for _, a := range dbl {
+			if len(data) >= n {
+				break
+			}
+			if data, err = indirect(data, a); err != nil {
+				return nil, nil, err
+			}
+		}
+	}
+	if len(data) < n {
+		return nil, nil, fmt.Errorf("v6fs: inode %d: size %d too large", ip.Ino, ip.Size)
+	}
+	return data[:n], ind, nil
+}
+
+// read returns the contents of ip.
+func (f *FS) read(ip *Inode) ([]byte, error) {
+	blocks, _, err := f.blocks(ip)
+	if err != nil {
+		return nil, err
+	}
+	buf := make([]byte, 0, len(blocks)*BlockSize)
+	for _, a := range blocks {
+		if a == 0 {
+			buf = append(buf, make([]byte, BlockSize)...)
+			continue
+		}
+		b, err := f.data(a)
+		if err != nil {
+			return nil, err
+		}
+		buf = append(buf, b...)
+	}
+	return buf[:ip.Size], nil
+}
+
+// truncate frees the blocks of ip and sets its size to zero. The inode is
+// not written.
+func (f *FS) truncate(ip *Inode) error {
+	data, ind, err := f.blocks(ip)
+	if err != nil {
+		return err
+	}
+	for _, list := range [][]uint16{data, ind} {
+		for _, a := range list {
+			if a != 0 {
+				if err := f.free(a); err != nil {
+					return err
+				}
+			}
+		}
+	}
+	ip.Mode &^= ILARG
+	ip.Size = 0
+	ip.Addr = [8]uint16{}
+	return nil
+}
+
+// write replaces the contents of ip with buf, and writes the inode.
+func (f *FS) write(ip *Inode, buf []byte) error {
+	if len(buf) > maxSize {
+		return fmt.Errorf("v6fs: file too large")
+	}
+	if err := f.truncate(ip); err != nil {
+		return err
+	}
+	ip.Mtime = time.Now()
+	if err := f.fill(ip, buf); err != nil {
+		// leave the file empty rather than half written.
+		f.truncate(ip)
+		f.writeInode(ip)
+		return err
+	}
+	return f.writeInode(ip)
+}
+
+// fill allocates and writes the blocks of the empty ip to hold buf.
+func (f *FS) fill(ip *Inode, buf []byte) error {
+	var data []uint16
+	for off := 0; off < len(buf); off += BlockSize {
+		a, err := f.alloc()
+		if err != nil {
+			return err
+		}
+		// record the block at once, so that truncate frees it on error.
+		data = append(data, a)
+		ip.Size = off + 1
+		if err := f.record(ip, data); err != nil {
+			ip.Size = off
+			f.free(a)
+			return err
+		}
+		b := make([]byte, BlockSize)
+		copy(b, buf[off:])
+		if err := f.writeBlock(a, b); err != nil {
+			return err
+		}
+	}
+	ip.Size = len(buf)
+	return nil
+}
+
+// record sets the addresses of ip to list the blocks in data, which
+// extends the blocks it lists by one.
+func (f *FS) record(ip *Inode, data []uint16) error {
+	n := len(data)
+	switch {
+	case n <= 8:
+		ip.Addr[n-1] = data[n-1]
+		return nil
+	case n == 9:
+		// convert to a large file.
+		ind, err := f.alloc()
+		if err != nil {
+			return err
+		}
+		if err := f.writeBlock(ind, blockOf(data)); err != nil {
+			return err
+		}
+		ip.Addr = [8]uint16{ind}
+		ip.Mode |= ILARG
+		return nil
+	}
+	i := (n - 1) / 256 // indirect block
+	if i >= 7+256 {
+		return fmt.Errorf("v6fs: file too large")
+	}
+	group := data[i*256:]
+	var a uint16
+	if i < 7 {
+		if ip.Addr[i] == 0 {
+			var err error
+			if ip.Addr[i], err = f.alloc(); err != nil {
+				return err
+			}
+		}
+		a = ip.Addr[i]
+	} else {
+		// through the double indirect block.
+		if ip.Addr[7] == 0 {
+			var err error
+			if ip.Addr[7], err = f.alloc(); err != nil {
+				return err
+			}
+		}
+		dbl, err := f.data(ip.Addr[7])
+		if err != nil {
+			return err
+		}
+		if a = binary.LittleEndian.Uint16(dbl[2*(i-7):]); a == 0 {
+			if a, err = f.alloc(); err != nil {
+				return err
+			}
+			binary.LittleEndian.PutUint16(dbl[2*(i-7):], a)
+			if err := f.writeBlock(ip.Addr[7], dbl); err != nil {
+				return err
+			}
+		}
+	}
+	return f.writeBlock(a, blockOf(group))
+}
+
+// blockOf returns a block holding the block numbers in list.
+func blockOf(list []uint16) []byte {
+	b := make([]byte, BlockSize)
+	for i, a := range list {
+		binary.LittleEndian.PutUint16(b[2*i:], a)
+	}
+	return b
+}
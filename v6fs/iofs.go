@@ -0,0 +1,160 @@
+package v6fs
+
+import (
+	"bytes"
+	"io"
+	"io/fs"
+	"path"
+	"sort"
+	"time"
+)
+
+// Open opens the file called name for reading.
+func (f *FS) Open(name string) (fs.File, error) {
+	ip, err := f.namei("open", name)
+	if err != nil {
+		return nil, err
+	}
+	info := &fileInfo{name: path.Base(name), ip: ip}
+	if ip.IsDir() {
+		des, err := f.readDir(name, ip)
+		if err != nil {
+			return nil, err
+		}
+		return &dir{info: info, entries: des}, nil
+	}
+	buf, err := f.read(ip)
+	if err != nil {
+		return nil, &fs.PathError{Op: "open", Path: name, Err: err}
+	}
+	return &file{Reader: bytes.NewReader(buf), info: info}, nil
+}
+
+// Stat returns a FileInfo describing the file called name. Its Sys
+// method returns the *Inode.
+func (f *FS) Stat(name string) (fs.FileInfo, error) {
+	ip, err := f.namei("stat", name)
+	if err != nil {
+		return nil, err
+	}
+	return &fileInfo{name: path.Base(name), ip: ip}, nil
+}
+
+// ReadFile returns the contents of the file called name.
+func (f *FS) ReadFile(name string) ([]byte, error) {
+	ip, err := f.namei("read", name)
+	if err != nil {
+		return nil, err
+	}
+	if ip.IsDir() {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: errIsDir}
+	}
+	buf, err := f.read(ip)
+	if err != nil {
+		return nil, &fs.PathError{Op: "read", Path: name, Err: err}
+	}
+	return buf, nil
+}
+
+// ReadDir returns the entries of the directory called name, other than
+// . and .., sorted by name.
+func (f *FS) ReadDir(name string) ([]fs.DirEntry, error) {
+	ip, err := f.namei("readdir", name)
+	if err != nil {
+		return nil, err
+	}
+	return f.readDir(name, ip)
+}
+
+func (f *FS) readDir(name string, dp *Inode) ([]fs.DirEntry, error) {
+	des, err := f.entries(dp)
+	if err != nil {
+		return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+	}
+	var list []fs.DirEntry
+	for _, de := range des {
+		if de.name == "." || de.name == ".." {
+			continue
+		}
+		ip, err := f.inode(de.ino)
+		if err != nil {
+			return nil, &fs.PathError{Op: "readdir", Path: name, Err: err}
+		}
+		list = append(list, fs.FileInfoToDirEntry(&fileInfo{name: de.name, ip: ip}))
+	}
+	sort.Slice(list, func(i, j int) bool { return list[i].Name() < list[j].Name() })
+	return list, nil
+}
+
+// fileInfo describes a file, for io/fs.
+type fileInfo struct {
+	name string
+	ip   *Inode
+}
+
+func (fi *fileInfo) Name() string       { return fi.name }
+func (fi *fileInfo) Size() int64        { return int64(fi.ip.Size) }
+func (fi *fileInfo) ModTime() time.Time { return fi.ip.Mtime }
+func (fi *fileInfo) IsDir() bool        { return fi.ip.IsDir() }
+func (fi *fileInfo) Sys() interface{}   { return fi.ip }
+
+func (fi *fileInfo) Mode() fs.FileMode {
+	m := fs.FileMode(fi.ip.Mode & 0777)
+	switch fi.ip.Mode & IFMT {
+	case IFDIR:
+		m |= fs.ModeDir
+	case IFCHR:
+		m |= fs.ModeDevice | fs.ModeCharDevice
+	case IFBLK:
+		m |= fs.ModeDevice
+	}
+	if fi.ip.Mode&ISUID != 0 {
+		m |= fs.ModeSetuid
+	}
+	if fi.ip.Mode&ISGID != 0 {
+		m |= fs.ModeSetgid
+	}
+	if fi.ip.Mode&ISVTX != 0 {
+		m |= fs.ModeSticky
+	}
+	return m
+}
+
+// file is an open file, read into memory.
+type file struct {
+	*bytes.Reader
+	info *fileInfo
+}
+
+func (f *file) Stat() (fs.FileInfo, error) { return f.info, nil }
+func (f *file) Close() error               { return nil }
+
+// dir is an open directory.
+type dir struct {
+	info    *fileInfo
+	entries []fs.DirEntry
+}
+
+func (d *dir) Stat() (fs.FileInfo, error) { return d.info, nil }
+func (d *dir) Close() error               { return nil }
+
+func (d *dir) Read([]byte) (int, error) {
+	return 0, &fs.PathError{Op: "read", Path: d.info.name, Err: errIsDir}
+}
+
+func (d *dir) ReadDir(n int) ([]fs.DirEntry, error) {
+	if n <= 0 {
+		des := d.entries
+		d.entries = nil
+		return des, nil
+	}
+	if len(d.entries) == 0 {
+		return nil, io.EOF
+	}
+	if n > len(d.entries) {
+		n = len(d.entries)
+	}
+	des := d.entries[:n]
+	d.entries = d.entries[n:]
+	return des, nil
+}
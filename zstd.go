@@ -0,0 +1,773 @@
+package pdp11
+
+import (
+	"encoding/binary"
+	"errors"
+	"math/bits"
+)
+
+// This file implements a decoder for the Zstandard format of RFC 8878,
+// sufficient to read disk images compressed with zstd. Frames which need
+// a dictionary are not supported.
+
+var errZstdCorrupt = errors.New("zstd: corrupt input")
+
+// zstdDecode returns the decompressed contents of the zstd frames in src.
+func zstdDecode(src []byte) ([]byte, error) {
+	var d zstdDecoder
+	for len(src) > 0 {
+		if len(src) < 8 {
+			return nil, errZstdCorrupt
+		}
+		switch magic := binary.LittleEndian.Uint32(src); {
+		case magic == 0xfd2fb528:
+			var err error
+			if src, err = d.frame(src[4:]); err != nil {
+				return nil, err
+			}
+		case magic&^0xf == 0x184d2a50:
+			// a skippable frame.
+			n := binary.LittleEndian.Uint32(src[4:])
+			if uint64(len(src)-8) < uint64(n) {
+				return nil, errZstdCorrupt
+			}
+			src = src[8+n:]
+		default:
+			return nil, errors.New("zstd: bad magic number")
+		}
+	}
+	return d.out, nil
+}
+
+// zstdDecoder holds the state carried from block to block of a frame.
+type zstdDecoder struct {
+	out        []byte // decompressed so far, including earlier frames
+	start      int    // the start of the current frame in out
+	rep        [3]int // the repeated offsets
+	lits       []byte // the literals of the current block
+	huff       []huffEntry
+	huffLog    uint8
+	ll, of, ml *fseTable // the tables of the last block, for repeat mode
+}
+
+// frame decompresses the frame at the start of src, after the magic
+// number, returning the rest of src.
+func (d *zstdDecoder) frame(src []byte) ([]byte, error) {
+	fhd := src[0]
+	src = src[1:]
+	if fhd&0x08 != 0 {
+		return nil, errZstdCorrupt
+	}
+	single := fhd&0x20 != 0
+	if !single {
+		// the window descriptor: the whole frame is kept in memory.
+		src = src[1:]
+	}
+	dictSize := [4]int{0, 1, 2, 4}[fhd&3]
+	fcsSize := [4]int{0, 2, 4, 8}[fhd>>6]
+	if fcsSize == 0 && single {
+		fcsSize = 1
+	}
+	if len(src) < dictSize+fcsSize {
+		return nil, errZstdCorrupt
+	}
+	if le(src[:dictSize]) != 0 {
+		return nil, errors.New("zstd: dictionaries are not supported")
+	}
+	fcs := le(src[dictSize : dictSize+fcsSize])
+	if fcsSize == 2 {
+		fcs += 256
+	}
+	src = src[dictSize+fcsSize:]
+
+	d.start = len(d.out)
+	d.rep = [3]int{1, 4, 8}
+	d.huff = nil
+	d.ll, d.of, d.ml = nil, nil, nil
+	for last := false; !last; {
+		if len(src) < 3 {
+			return nil, errZstdCorrupt
+		}
+		h := le(src[:3])
+		src = src[3:]
+		last = h&1 != 0
+		size := int(h >> 3)
+		switch h >> 1 & 3 {
+		case 0:
+			if len(src) < size {
+				return nil, errZstdCorrupt
+			}
+			d.out = append(d.out, src[:size]...)
+			src = src[size:]
+		case 1:
+			if len(src) < 1 {
+				return nil, errZstdCorrupt
+			}
+			for i := 0; i < size; i++ {
+				d.out = append(d.out, src[0])
+			}
+			src = src[1:]
+		case 2:
+			if len(src) < size {
+				return nil, errZstdCorrupt
+			}
+			if err := d.block(src[:size]); err != nil {
+				return nil, err
+			}
+			src = src[size:]
+		default:
+			return nil, errZstdCorrupt
+		}
+	}
+	if fcsSize > 0 && uint64(len(d.out)-d.start) != fcs {
+		return nil, errors.New("zstd: wrong decompressed size")
+	}
+	if fhd&0x04 != 0 {
+		if len(src) < 4 {
+			return nil, errZstdCorrupt
+		}
+		if uint32(xxhash64(d.out[d.start:])) != binary.LittleEndian.Uint32(src) {
+			return nil, errors.New("zstd: checksum mismatch")
+		}
+		src = src[4:]
+	}
+	return src, nil
+}
+
+// le returns the little endian number in b.
+func le(b []byte) uint64 {
+	var v uint64
+	for i := len(b) - 1; i >= 0; i-- {
+		v = v<<8 | uint64(b[i])
+	}
+	return v
+}
+
+// block decompresses a compressed block.
+func (d *zstdDecoder) block(src []byte) error {
+	n, err := d.literals(src)
+	if err != nil {
+		return err
+	}
+	return d.sequences(src[n:])
+}
+
+// literals decodes the literals section at the start of src into d.lits,
+// returning its length.
+func (d *zstdDecoder) literals(src []byte) (int, error) {
+	if len(src) < 1 {
+		return 0, errZstdCorrupt
+	}
+	typ, format := src[0]&3, src[0]>>2&3
+	if typ < 2 {
+		// raw or run length literals.
+		n := [4]int{1, 2, 1, 3}[format]
+		if len(src) < n+1 {
+			return 0, errZstdCorrupt
+		}
+		size := int(le(src[:n]) >> 4)
+		if n == 1 {
+			size = int(src[0] >> 3)
+		}
+		if typ == 1 {
+			d.lits = d.lits[:0]
+			for i := 0; i < size; i++ {
+				d.lits = append(d.lits, src[n])
+			}
+			return n + 1, nil
+		}
+		if len(src) < n+size {
+			return 0, errZstdCorrupt
+		}
+		d.lits = append(d.lits[:0], src[n:n+size]...)
+		return n + size, nil
+	}
+
+	// Huffman coded literals, in one stream or four.
+	n, sizeBits := [4]int{3, 3, 4, 5}[format], [4]uint{10, 10, 14, 18}[format]
+	if len(src) < n {
+		return 0, errZstdCorrupt
+	}
+	h := le(src[:n]) >> 4
+	regen := int(h & (1<<sizeBits - 1))
+	comp := int(h >> sizeBits & (1<<sizeBits - 1))
+	if len(src) < n+comp {
+		return 0, errZstdCorrupt
+	}
+	data := src[n : n+comp]
+	if typ == 2 {
+		m, err := d.huffTable(data)
+		if err != nil {
+			return 0, err
+		}
+		data = data[m:]
+	} else if d.huff == nil {
+		return 0, errZstdCorrupt
+	}
+	d.lits = d.lits[:0]
+	if format == 0 {
+		if err := d.huffStream(data, regen); err != nil {
+			return 0, err
+		}
+		return n + comp, nil
+	}
+	if len(data) < 6 {
+		return 0, errZstdCorrupt
+	}
+	sizes := [4]int{int(le(data[0:2])), int(le(data[2:4])), int(le(data[4:6]))}
+	sizes[3] = len(data) - 6 - sizes[0] - sizes[1] - sizes[2]
+	if sizes[3] < 0 {
+		return 0, errZstdCorrupt
+	}
+	data = data[6:]
+	each := (regen + 3) / 4
+	for i, size := range sizes {
+		count := each
+		if i == 3 {
+			count = regen - 3*each
+		}
+		if count < 0 {
+			return 0, errZstdCorrupt
+		}
+		if err := d.huffStream(data[:size], count); err != nil {
+			return 0, err
+		}
+		data = data[size:]
+	}
+	return n + comp, nil
+}
+
+// huffEntry is an entry of a Huffman decoding table, indexed by the next
+// bits of the stream.
+type huffEntry struct {
+	sym  byte
+	bits uint8 // the length of the code
+}
+
+// huffTable reads the Huffman tree description at the start of src,
+// returning its length.
+func (d *zstdDecoder) huffTable(src []byte) (int, error) {
+	if len(src) < 1 {
+		return 0, errZstdCorrupt
+	}
+	h := int(src[0])
+	var weights []uint8
+	if h >= 128 {
+		// the weights are stored directly, two to a byte.
+		nw := h - 127
+		if len(src) < 1+(nw+1)/2 {
+			return 0, errZstdCorrupt
+		}
+		for i := 0; i < nw; i++ {
+			b := src[1+i/2]
+			if i%2 == 0 {
+				b >>= 4
+			}
+			weights = append(weights, b&0xf)
+		}
+		h = (nw + 1) / 2
+	} else {
+		if len(src) < 1+h {
+			return 0, errZstdCorrupt
+		}
+		var err error
+		if weights, err = fseWeights(src[1 : 1+h]); err != nil {
+			return 0, err
+		}
+	}
+
+	// the weight of the last symbol is implied by the others.
+	var total uint32
+	for _, w := range weights {
+		if w > 11 {
+			return 0, errZstdCorrupt
+		}
+		if w > 0 {
+			total += 1 << (w - 1)
+		}
+	}
+	if total == 0 || len(weights) > 255 {
+		return 0, errZstdCorrupt
+	}
+	maxBits := uint8(bits.Len32(total))
+	rest := uint32(1)<<maxBits - total
+	if maxBits > 11 || rest&(rest-1) != 0 {
+		return 0, errZstdCorrupt
+	}
+	weights = append(weights, uint8(bits.Len32(rest)))
+
+	// codes are assigned in order of increasing weight, so the longest
+	// codes have the lowest values.
+	table := make([]huffEntry, 1<<maxBits)
+	pos := 0
+	for w := uint8(1); w <= maxBits; w++ {
+		for sym, sw := range weights {
+			if sw != w {
+				continue
+			}
+			e := huffEntry{sym: byte(sym), bits: maxBits + 1 - w}
+			for i := 0; i < 1<<(w-1); i++ {
+				table[pos] = e
+				pos++
+			}
+		}
+	}
+	d.huff, d.huffLog = table, maxBits
+	return 1 + h, nil
+}
+
+// fseWeights decodes the FSE compressed Huffman weights in src.
+func fseWeights(src []byte) ([]uint8, error) {
+	t, n, err := readFSE(src, 6, 12)
+	if err != nil {
+		return nil, err
+	}
+	r, err := newRbits(src[n:])
+	if err != nil {
+		return nil, err
+	}
+	// two states are used in turn until the stream is exhausted.
+	s := [2]uint16{t.init(r), t.init(r)}
+	var weights []uint8
+	for i := 0; ; i ^= 1 {
+		if len(weights) > 255 {
+			return nil, errZstdCorrupt
+		}
+		weights = append(weights, t.e[s[i]].sym)
+		s[i] = t.next(s[i], r)
+		if r.pos < 0 {
+			return append(weights, t.e[s[i^1]].sym), nil
+		}
+	}
+}
+
+// huffStream decodes count literals from the Huffman coded stream src.
+func (d *zstdDecoder) huffStream(src []byte, count int) error {
+	r, err := newRbits(src)
+	if err != nil {
+		return err
+	}
+	for i := 0; i < count; i++ {
+		e := d.huff[r.peek(d.huffLog)]
+		d.lits = append(d.lits, e.sym)
+		r.pos -= int(e.bits)
+	}
+	if r.pos != 0 {
+		return errZstdCorrupt
+	}
+	return nil
+}
+
+// The baselines and extra bits of the literal length and match length
+// codes.
+var (
+	llBase = [36]int{
+		0, 1, 2, 3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15,
+		16, 18, 20, 22, 24, 28, 32, 40, 48, 64, 128, 256, 512, 1024, 2048, 4096,
+		8192, 16384, 32768, 65536,
+	}
+	llBits = [36]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 6, 7, 8, 9, 10, 11, 12,
+		13, 14, 15, 16,
+	}
+	mlBase = [53]int{
+		3, 4, 5, 6, 7, 8, 9, 10, 11, 12, 13, 14, 15, 16, 17, 18,
+		19, 20, 21, 22, 23, 24, 25, 26, 27, 28, 29, 30, 31, 32, 33, 34,
+		35, 37, 39, 41, 43, 47, 51, 59, 67, 83, 99, 131, 259, 515, 1027, 2051,
+		4099, 8195, 16387, 32771, 65539,
+	}
+	mlBits = [53]uint8{
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0, 0,
+		1, 1, 1, 1, 2, 2, 3, 3, 4, 4, 5, 7, 8, 9, 10, 11,
+		12, 13, 14, 15, 16,
+	}
+)
+
+// The predefined distributions of the literal length, offset and match
+// length codes.
+var (
+	llDefault = mustFSE([]int16{
+		4, 3, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 2, 1, 1, 1,
+		2, 2, 2, 2, 2, 2, 2, 2, 2, 3, 2, 1, 1, 1, 1, 1,
+		-1, -1, -1, -1,
+	}, 6)
+	ofDefault = mustFSE([]int16{
+		1, 1, 1, 1, 1, 1, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, -1, -1, -1, -1, -1,
+	}, 5)
+	mlDefault = mustFSE([]int16{
+		1, 4, 3, 2, 2, 2, 2, 2, 2, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1,
+		1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, 1, -1, -1,
+		-1, -1, -1, -1, -1,
+	}, 6)
+)
+
+// sequences decodes the sequences section src and executes the
+// sequences, appending the block to d.out.
+func (d *zstdDecoder) sequences(src []byte) error {
+	if len(src) < 1 {
+		return errZstdCorrupt
+	}
+	nseq := int(src[0])
+	src = src[1:]
+	switch {
+	case nseq == 0:
+		d.out = append(d.out, d.lits...)
+		return nil
+	case nseq == 255 && len(src) >= 2:
+		nseq = int(le(src[:2])) + 0x7f00
+		src = src[2:]
+	case nseq >= 128 && nseq < 255 && len(src) >= 1:
+		nseq = (nseq-128)<<8 + int(src[0])
+		src = src[1:]
+	case nseq >= 128:
+		return errZstdCorrupt
+	}
+	if len(src) < 1 || src[0]&3 != 0 {
+		return errZstdCorrupt
+	}
+	modes := src[0]
+	src = src[1:]
+	var err error
+	var n int
+	if d.ll, n, err = fseMode(src, modes>>6, d.ll, llDefault, 9, 35); err != nil {
+		return err
+	}
+	src = src[n:]
+	if d.of, n, err = fseMode(src, modes>>4&3, d.of, ofDefault, 8, 31); err != nil {
+		return err
+	}
+	src = src[n:]
+	if d.ml, n, err = fseMode(src, modes>>2&3, d.ml, mlDefault, 9, 52); err != nil {
+		return err
+	}
+	src = src[n:]
+
+	r, err := newRbits(src)
+	if err != nil {
+		return err
+	}
+	ll, of, ml := d.ll.init(r), d.of.init(r), d.ml.init(r)
+	lits := d.lits
+	for i := 0; i < nseq; i++ {
+		llc, ofc, mlc := d.ll.e[ll].sym, d.of.e[of].sym, d.ml.e[ml].sym
+		off := 1<<ofc + int(r.read(ofc))
+		mlen := mlBase[mlc] + int(r.read(mlBits[mlc]))
+		llen := llBase[llc] + int(r.read(llBits[llc]))
+		if off > 3 {
+			off -= 3
+			d.rep = [3]int{off, d.rep[0], d.rep[1]}
+		} else {
+			if llen == 0 {
+				off++
+			}
+			switch off {
+			case 1:
+				off = d.rep[0]
+			case 2:
+				off = d.rep[1]
+				d.rep = [3]int{off, d.rep[0], d.rep[2]}
+			case 3:
+				off = d.rep[2]
+				d.rep = [3]int{off, d.rep[0], d.rep[1]}
+			case 4:
+				off = d.rep[0] - 1
+				d.rep = [3]int{off, d.rep[0], d.rep[1]}
+			}
+		}
+		if llen > len(lits) {
+			return errZstdCorrupt
+		}
+		d.out = append(d.out, lits[:llen]...)
+		lits = lits[llen:]
+		if off <= 0 || off > len(d.out)-d.start {
+			return errZstdCorrupt
+		}
+		p := len(d.out) - off
+		if off >= mlen {
+			d.out = append(d.out, d.out[p:p+mlen]...)
+		} else {
+			for j := 0; j < mlen; j++ {
+				d.out = append(d.out, d.out[p+j])
+			}
+		}
+		if i < nseq-1 {
+			ll, ml, of = d.ll.next(ll, r), d.ml.next(ml, r), d.of.next(of, r)
+		}
+	}
+	if r.pos != 0 {
+		return errZstdCorrupt
+	}
+	d.out = append(d.out, lits...)
+	return nil
+}
+
+// fseMode returns the table of a sequence code given by mode, reading it
+// from the start of src if it is FSE compressed, and the length read.
+func fseMode(src []byte, mode uint8, last, def *fseTable, maxLog uint8, maxSym int) (*fseTable, int, error) {
+	switch mode {
+	case 0:
+		return def, 0, nil
+	case 1:
+		if len(src) < 1 || int(src[0]) > maxSym {
+			return nil, 0, errZstdCorrupt
+		}
+		return &fseTable{e: []fseEntry{{sym: src[0]}}}, 1, nil
+	case 2:
+		return readFSE(src, maxLog, maxSym)
+	default:
+		if last == nil {
+			return nil, 0, errZstdCorrupt
+		}
+		return last, 0, nil
+	}
+}
+
+// fseEntry is a state of an FSE decoding table.
+type fseEntry struct {
+	sym  uint8
+	bits uint8  // read to find the next state
+	base uint16 // added to them
+}
+
+// fseTable is an FSE decoding table of 1<<log states.
+type fseTable struct {
+	log uint8
+	e   []fseEntry
+}
+
+// init reads the initial state from r.
+func (t *fseTable) init(r *rbits) uint16 { return uint16(r.read(t.log)) }
+
+// next reads the state which follows s from r.
+func (t *fseTable) next(s uint16, r *rbits) uint16 {
+	e := t.e[s]
+	return e.base + uint16(r.read(e.bits))
+}
+
+// readFSE reads the normalized distribution at the start of src,
+// returning its decoding table and the length read.
+func readFSE(src []byte, maxLog uint8, maxSym int) (*fseTable, int, error) {
+	r := fbits{b: src}
+	log := uint8(r.read(4)) + 5
+	if log > maxLog {
+		return nil, 0, errZstdCorrupt
+	}
+	remaining := 1<<log + 1
+	threshold := 1 << log
+	nbits := int(log) + 1
+	var norm []int16
+	zero := false
+	for remaining > 1 && len(norm) <= maxSym {
+		if zero {
+			// a run of symbols with no probability.
+			for {
+				n := r.read(2)
+				for i := 0; i < n; i++ {
+					norm = append(norm, 0)
+				}
+				if n != 3 {
+					break
+				}
+			}
+			if len(norm) > maxSym {
+				return nil, 0, errZstdCorrupt
+			}
+		}
+		max := 2*threshold - 1 - remaining
+		count := r.peek(nbits)
+		if count&(threshold-1) < max {
+			count &= threshold - 1
+			r.pos += nbits - 1
+		} else {
+			count &= 2*threshold - 1
+			if count >= threshold {
+				count -= max
+			}
+			r.pos += nbits
+		}
+		count--
+		if count < 0 {
+			remaining += count
+		} else {
+			remaining -= count
+		}
+		norm = append(norm, int16(count))
+		zero = count == 0
+		if remaining < 1 {
+			return nil, 0, errZstdCorrupt
+		}
+		for remaining < threshold {
+			nbits--
+			threshold >>= 1
+		}
+	}
+	if remaining != 1 || r.pos > len(src)*8 {
+		return nil, 0, errZstdCorrupt
+	}
+	t, err := newFSETable(norm, log)
+	return t, (r.pos + 7) / 8, err
+}
+
+// newFSETable returns the decoding table of the normalized distribution
+// norm, in which -1 is a probability less than one.
+func newFSETable(norm []int16, log uint8) (*fseTable, error) {
+	size := 1 << log
+	t := &fseTable{log: log, e: make([]fseEntry, size)}
+	next := make([]uint16, len(norm))
+	high := size - 1
+	for s, c := range norm {
+		switch {
+		case c == -1:
+			t.e[high].sym = uint8(s)
+			high--
+			next[s] = 1
+		case c > 0:
+			next[s] = uint16(c)
+		}
+	}
+	step, mask, pos := size>>1+size>>3+3, size-1, 0
+	for s, c := range norm {
+		for i := 0; i < int(c); i++ {
+			t.e[pos].sym = uint8(s)
+			for pos = (pos + step) & mask; pos > high; pos = (pos + step) & mask {
+			}
+		}
+	}
+	if pos != 0 {
+		return nil, errZstdCorrupt
+	}
+	for i := range t.e {
+		e := &t.e[i]
+		n := next[e.sym]
+		next[e.sym]++
+		e.bits = log + 1 - uint8(bits.Len16(n))
+		e.base = n<<e.bits - uint16(size)
+	}
+	return t, nil
+}
+
+func mustFSE(norm []int16, log uint8) *fseTable {
+	t, err := newFSETable(norm, log)
+	if err != nil {
+		panic(err)
+	}
+	return t
+}
+
+// fbits reads a bit stream forwards, from the low bit of its first byte.
+type fbits struct {
+	b   []byte
+	pos int
+}
+
+// peek returns the next n bits, which are zero beyond the end of the
+// stream.
+func (r *fbits) peek(n int) int {
+	v := 0
+	for i := 0; i < n; i++ {
+		if k := r.pos + i; k < len(r.b)*8 && r.b[k/8]>>uint(k%8)&1 != 0 {
+			v |= 1 << uint(i)
+		}
+	}
+	return v
+}
+
+func (r *fbits) read(n int) int {
+	v := r.peek(n)
+	r.pos += n
+	return v
+}
+
+// rbits reads a bit stream backwards from its end, which is marked by
+// the highest set bit of the last byte, as zstd writes its Huffman and
+// FSE coded streams. pos is the number of bits left to read, and goes
+// negative if the stream is overread.
+type rbits struct {
+	b   []byte
+	pos int
+}
+
+func newRbits(b []byte) (*rbits, error) {
+	if len(b) == 0 || b[len(b)-1] == 0 {
+		return nil, errZstdCorrupt
+	}
+	return &rbits{b: b, pos: len(b)*8 - 9 + bits.Len8(b[len(b)-1])}, nil
+}
+
+// peek returns the next n bits, which are zero beyond the start of the
+// stream.
+func (r *rbits) peek(n uint8) uint64 {
+	start := r.pos - int(n)
+	lo := start
+	if lo < 0 {
+		lo = 0
+	}
+	if r.pos <= lo {
+		return 0
+	}
+	var v uint64
+	for i := (r.pos - 1) / 8; i >= lo/8; i-- {
+		v = v<<8 | uint64(r.b[i])
+	}
+	v = v >> uint(lo%8) & (1<<uint(r.pos-lo) - 1)
+	return v << uint(lo-start)
+}
+
+func (r *rbits) read(n uint8) uint64 {
+	v := r.peek(n)
+	r.pos -= int(n)
+	return v
+}
+
+// xxhash64 returns the XXH64 hash of b, with a seed of 0, of which zstd
+// keeps the low 32 bits as a frame's checksum.
+func xxhash64(b []byte) uint64 {
+	const (
+		p1 uint64 = 11400714785074694791
+		p2 uint64 = 14029467366897019727
+		p3 uint64 = 1609587929392839161
+		p4 uint64 = 9650029242287828579
+		p5 uint64 = 2870177450012600261
+	)
+	round := func(acc, v uint64) uint64 {
+		return bits.RotateLeft64(acc+v*p2, 31) * p1
+	}
+	n := uint64(len(b))
+	var h uint64
+	if len(b) >= 32 {
+		v := [4]uint64{p1, p2, 0, ^p1 + 1}
+		v[0] += p2
+		for ; len(b) >= 32; b = b[32:] {
+			for i := range v {
+				v[i] = round(v[i], binary.LittleEndian.Uint64(b[8*i:]))
+			}
+		}
+		h = bits.RotateLeft64(v[0], 1) + bits.RotateLeft64(v[1], 7) + bits.RotateLeft64(v[2], 12) + bits.RotateLeft64(v[3], 18)
+		for i := range v {
+			h = (h^round(0, v[i]))*p1 + p4
+		}
+	} else {
+		h = p5
+	}
+	h += n
+	for ; len(b) >= 8; b = b[8:] {
+		h = bits.RotateLeft64(h^round(0, binary.LittleEndian.Uint64(b)), 27)*p1 + p4
+	}
+	if len(b) >= 4 {
+		h = bits.RotateLeft64(h^uint64(binary.LittleEndian.Uint32(b))*p1, 23)*p2 + p3
+		b = b[4:]
+	}
+	for _, c := range b {
+		h = bits.RotateLeft64(h^uint64(c)*p5, 11) * p1
+	}
+	h ^= h >> 33
+	h *= p2
+	h ^= h >> 29
+	h *= p3
+	h ^= h >> 32
+	return h
+}